@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/arca"
+	"github.com/devsamuele/service-kit/web"
+)
+
+type ArcaGroup struct {
+	srv arca.Service
+}
+
+func NewArcaGroup(srv arca.Service) ArcaGroup {
+	return ArcaGroup{
+		srv: srv,
+	}
+}
+
+func (g ArcaGroup) QueryArticles(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	params := web.QueryParams(r)
+
+	articles, err := g.srv.QueryArticles(ctx, params["search"], params["active"])
+	if err != nil {
+		return web.ErrHandler(err)
+	}
+
+	return web.Respond(ctx, w, articles, http.StatusOK)
+}
+
+func (g ArcaGroup) QueryArticleByID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	article, err := g.srv.QueryArticleByID(ctx, web.URIParams(r)["cd_ar"])
+	if err != nil {
+		return web.ErrHandler(err)
+	}
+
+	return web.Respond(ctx, w, article, http.StatusOK)
+}
+
+func (g ArcaGroup) QueryLots(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	params := web.QueryParams(r)
+
+	lots, err := g.srv.QueryLots(ctx, params["cd_ar"], params["search"])
+	if err != nil {
+		return web.ErrHandler(err)
+	}
+
+	return web.Respond(ctx, w, lots, http.StatusOK)
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/devsamuele/service-kit/auth"
+	"github.com/devsamuele/service-kit/web"
+)
+
+// authorize lets through the requests whose token grants all of scopes. It
+// follows mid.Authenticate, whose claims it reads: the scopes are those
+// signed in the token, never a header of the request.
+func authorize(scopes ...string) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context")
+			}
+
+			for _, scope := range scopes {
+				if !hasScope(claims, scope) {
+					return web.NewRequestError(
+						errors.New("you are not authorized for that action"),
+						http.StatusForbidden,
+						"", "", "",
+					)
+				}
+			}
+
+			return handler(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return m
+}
+
+func hasScope(claims auth.Claims, scope string) bool {
+	for _, s := range claims.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
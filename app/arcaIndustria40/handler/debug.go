@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/profile"
+)
+
+// Profile serves, on the debug listener, the active profile with the values
+// it sets and the configuration in effect, one flag per line with the
+// secrets masked.
+func Profile(p profile.Profile, config string) http.Handler {
+	body := struct {
+		Profile profile.Profile `json:"profile"`
+		Config  []string        `json:"config"`
+	}{
+		Profile: p,
+		Config:  strings.Split(config, "\n"),
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
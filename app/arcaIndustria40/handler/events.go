@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/events"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/lotcode"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/pasteurizer"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/spindryer"
+	"github.com/devsamuele/service-kit/web"
+	"github.com/devsamuele/service-kit/ws"
+)
+
+// Websocket messages of the subscriptions.
+const (
+	eventSubscribe      = "subscribe"
+	eventSubscribed     = "subscribed"
+	eventSubscribeError = events.SubscribeError
+	eventSnapshot       = "snapshot"
+)
+
+// snapshotTimeout bounds the queries of a snapshot.
+const snapshotTimeout = 10 * time.Second
+
+type EventsGroup struct {
+	log         *log.Logger
+	stream      *events.Stream
+	spindryer   *spindryer.Service
+	pasteurizer *pasteurizer.Service
+}
+
+func NewEventsGroup(log *log.Logger, stream *events.Stream, spindryer *spindryer.Service, pasteurizer *pasteurizer.Service) EventsGroup {
+	return EventsGroup{
+		log:         log,
+		stream:      stream,
+		spindryer:   spindryer,
+		pasteurizer: pasteurizer,
+	}
+}
+
+// Connect handles a new websocket client. The client receives only the legacy
+// broadcasts until it sends a subscribe message; it is answered with subscribed, carrying
+// everything subscribed so far, or with subscribe_error. A client resuming
+// from an event no longer kept is sent a snapshot after subscribed.
+func (g EventsGroup) Connect(r *http.Request, socket *ws.Socket) {
+	var subscribed events.Subscribed
+
+	g.stream.Register(socket)
+	socket.OnDisconnect(func() {
+		g.stream.Leave(socket)
+	})
+
+	socket.On(eventSubscribe, func(msg json.RawMessage) {
+		var sub events.Subscription
+		if err := json.Unmarshal(msg, &sub); err != nil {
+			g.reply(socket, eventSubscribeError, map[string]string{"error": "invalid subscription: " + err.Error()})
+			return
+		}
+
+		replayed, resumed, seq, err := g.stream.Join(socket, sub)
+		if err != nil {
+			g.reply(socket, eventSubscribeError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		epoch, _ := g.stream.Position()
+		subscribed.Machines = append(subscribed.Machines, sub.Machines...)
+		subscribed.Lots = append(subscribed.Lots, sub.Lots...)
+		subscribed.Epoch = epoch
+		subscribed.Seq = seq
+		subscribed.Replayed = replayed
+		subscribed.Snapshot = !resumed
+		g.reply(socket, eventSubscribed, subscribed)
+
+		if resumed {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+		defer cancel()
+
+		snapshot, err := g.snapshot(ctx, sub, epoch, seq)
+		if err != nil {
+			g.log.Printf("events: snapshot: %s", err)
+			g.reply(socket, eventSubscribeError, map[string]string{"error": "snapshot failed, reload the data"})
+			return
+		}
+		g.reply(socket, eventSnapshot, snapshot)
+	})
+}
+
+// snapshot reads the state of the machines and lots of sub. It is read after
+// the event seq, so it covers it.
+func (g EventsGroup) snapshot(ctx context.Context, sub events.Subscription, epoch int64, seq uint64) (events.Snapshot, error) {
+	snapshot := events.Snapshot{
+		Epoch:    epoch,
+		Seq:      seq,
+		Machines: make([]events.MachineSnapshot, 0, len(sub.Machines)),
+		Lots:     make([]events.LotSnapshot, 0, len(sub.Lots)),
+	}
+
+	for _, m := range sub.Machines {
+		ms := events.MachineSnapshot{Machine: m}
+		switch m {
+		case lotcode.MachineSpindryer:
+			works, next, err := g.spindryer.QueryWork(ctx, spindryer.WorkQuery{})
+			if err != nil {
+				return events.Snapshot{}, fmt.Errorf("%s: %w", m, err)
+			}
+			ms.Connected = g.spindryer.GetOpcuaConnection(ctx).Connected
+			ms.Works, ms.NextPageToken = works, next
+		case lotcode.MachinePasteurizer:
+			works, next, err := g.pasteurizer.QueryWork(ctx, pasteurizer.WorkQuery{})
+			if err != nil {
+				return events.Snapshot{}, fmt.Errorf("%s: %w", m, err)
+			}
+			ms.Connected = g.pasteurizer.GetOpcuaConnection(ctx).Connected
+			ms.Works, ms.NextPageToken = works, next
+		}
+		snapshot.Machines = append(snapshot.Machines, ms)
+	}
+
+	for _, l := range sub.Lots {
+		l = strings.TrimSpace(l)
+		sw, _, err := g.spindryer.QueryWork(ctx, spindryer.WorkQuery{CdLotto: l})
+		if err != nil {
+			return events.Snapshot{}, fmt.Errorf("lot %s: %w", l, err)
+		}
+		for _, w := range sw {
+			snapshot.Lots = append(snapshot.Lots, events.LotSnapshot{CdLotto: l, Machine: lotcode.MachineSpindryer, Work: w})
+		}
+
+		pw, _, err := g.pasteurizer.QueryWork(ctx, pasteurizer.WorkQuery{CdLotto: l})
+		if err != nil {
+			return events.Snapshot{}, fmt.Errorf("lot %s: %w", l, err)
+		}
+		for _, w := range pw {
+			snapshot.Lots = append(snapshot.Lots, events.LotSnapshot{CdLotto: l, Machine: lotcode.MachinePasteurizer, Work: w})
+		}
+	}
+
+	return snapshot, nil
+}
+
+// reply queues data for socket after the events queued for it so far.
+func (g EventsGroup) reply(socket *ws.Socket, event string, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		g.log.Printf("events: %s: %s", event, err)
+		return
+	}
+
+	g.stream.Send(socket, event, b)
+}
+
+// Schema describes the websocket messages and every event the backend emits.
+func (g EventsGroup) Schema(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	return web.Respond(ctx, w, events.Describe(), http.StatusOK)
+}
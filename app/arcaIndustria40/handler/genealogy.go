@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/genealogy"
+	"github.com/devsamuele/service-kit/web"
+)
+
+type GenealogyGroup struct {
+	srv genealogy.Service
+}
+
+func NewGenealogyGroup(srv genealogy.Service) GenealogyGroup {
+	return GenealogyGroup{
+		srv: srv,
+	}
+}
+
+func (g GenealogyGroup) Upstream(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	nodes, err := g.srv.Upstream(ctx, web.URIParams(r)["cd_lotto"], web.QueryParams(r)["cd_ar"])
+	if err != nil {
+		return web.ErrHandler(err)
+	}
+
+	return web.Respond(ctx, w, nodes, http.StatusOK)
+}
+
+func (g GenealogyGroup) Downstream(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	nodes, err := g.srv.Downstream(ctx, web.URIParams(r)["cd_lotto"], web.QueryParams(r)["cd_ar"])
+	if err != nil {
+		return web.ErrHandler(err)
+	}
+
+	return web.Respond(ctx, w, nodes, http.StatusOK)
+}
@@ -1,45 +1,120 @@
 package handler
 
 import (
-	"database/sql"
 	"log"
 	"net/http"
 	"os"
 
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/arca"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/events"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/genealogy"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/lotcode"
 	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/pasteurizer"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/sample"
 	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/spindryer"
+	"github.com/devsamuele/service-kit/auth"
 	"github.com/devsamuele/service-kit/mid"
 	"github.com/devsamuele/service-kit/web"
 	"github.com/devsamuele/service-kit/ws"
 )
 
-func API(build string, db *sql.DB, io *ws.EventEmitter, shutdown chan os.Signal, log *log.Logger) *web.Router {
+// scopeRWWorkCorrection grants the right to correct the quantities of a work.
+const scopeRWWorkCorrection = "rw_work_correction"
 
-	handler := io.OnConnection(func(r *http.Request, socket *ws.Socket) {})
-	router := web.NewRouter(shutdown, mid.Logger(log), mid.Errors(log), mid.Metrics(), mid.Panic(log))
+// scopeRWWorkArchive grants the right to delete, that is archive, and to
+// restore a work.
+const scopeRWWorkArchive = "rw_work_archive"
+
+// scopeRWAdmin grants the right to operate the background jobs of the
+// backend.
+const scopeRWAdmin = "rw_admin"
+
+// HeaderNextPageToken carries the token of the next page of a paged list,
+// absent on its last page. The body of the list stays a plain array.
+const HeaderNextPageToken = "X-Next-Page-Token"
+
+// APIConfig contains the dependencies of the API handlers.
+type APIConfig struct {
+	Build       string
+	Shutdown    chan os.Signal
+	Log         *log.Logger
+	IO          *ws.EventEmitter
+	Events      *events.Stream
+	Auth        *auth.Auth
+	Arca        arca.Service
+	Lots        *lotcode.Service
+	Genealogy   genealogy.Service
+	Samples     sample.Service
+	Maintainer  *sample.Maintainer
+	Spindryer   *spindryer.Service
+	Pasteurizer *pasteurizer.Service
+}
+
+func API(cfg APIConfig) *web.Router {
+
+	eventsGroup := NewEventsGroup(cfg.Log, cfg.Events, cfg.Spindryer, cfg.Pasteurizer)
+	handler := cfg.IO.OnConnection(eventsGroup.Connect)
+	router := web.NewRouter(cfg.Shutdown, mid.Logger(cfg.Log), mid.Errors(cfg.Log), mid.Metrics(), mid.Panic(cfg.Log))
 
 	v1 := router.Group("/v1")
 	v1.HandleFn(http.MethodGet, "/ws", handler)
 
+	arcaGroup := NewArcaGroup(cfg.Arca)
+	v1.HandleFn(http.MethodGet, "/articles", arcaGroup.QueryArticles)
+	v1.HandleFn(http.MethodGet, "/articles/:cd_ar", arcaGroup.QueryArticleByID)
+	v1.HandleFn(http.MethodGet, "/lots", arcaGroup.QueryLots)
+
+	lotcodeGroup := NewLotcodeGroup(cfg.Lots)
+	v1.HandleFn(http.MethodPost, "/lots/next", lotcodeGroup.NextLot)
+
+	genealogyGroup := NewGenealogyGroup(cfg.Genealogy)
+	v1.HandleFn(http.MethodGet, "/lots/:cd_lotto/upstream", genealogyGroup.Upstream)
+	v1.HandleFn(http.MethodGet, "/lots/:cd_lotto/downstream", genealogyGroup.Downstream)
+
+	sampleGroup := NewSampleGroup(cfg.Samples, cfg.Maintainer)
+	v1.HandleFn(http.MethodGet, "/samples/:machine", sampleGroup.QuerySeries)
+
+	v1.HandleFn(http.MethodGet, "/events/schema", eventsGroup.Schema)
+	v1.HandleFn(http.MethodGet, "/stream", eventsGroup.Stream)
+
+	adminRouter := v1.SubGroup("/admin")
+	adminRouter.HandleFn(http.MethodGet, "/samples/maintenance", sampleGroup.MaintenanceStats, mid.Authenticate(cfg.Auth), authorize(scopeRWAdmin))
+	adminRouter.HandleFn(http.MethodPost, "/samples/maintenance/pause", sampleGroup.PauseMaintenance, mid.Authenticate(cfg.Auth), authorize(scopeRWAdmin))
+	adminRouter.HandleFn(http.MethodPost, "/samples/maintenance/resume", sampleGroup.ResumeMaintenance, mid.Authenticate(cfg.Auth), authorize(scopeRWAdmin))
+
 	spindryerRouter := v1.SubGroup("/spindryer")
-	spindryerGroup := NewSpindryerGroup(spindryer.NewService(spindryer.NewStore(db, log), shutdown, log, io))
+	spindryerGroup := NewSpindryerGroup(cfg.Spindryer)
 	spindryerRouter.HandleFn(http.MethodPost, "/createdDocuments", spindryerGroup.CreatedDocument)
 	spindryerRouter.HandleFn(http.MethodPost, "/opcuaConnect", spindryerGroup.OpcuaConnect)
 	spindryerRouter.HandleFn(http.MethodPost, "/opcuaDisconnect", spindryerGroup.OpcuaDisconnect)
 	spindryerRouter.HandleFn(http.MethodPost, "/work", spindryerGroup.InsertWork)
 	spindryerRouter.HandleFn(http.MethodGet, "/work", spindryerGroup.QueryWork)
+	spindryerRouter.HandleFn(http.MethodGet, "/work/archive", spindryerGroup.QueryArchive)
 	spindryerRouter.HandleFn(http.MethodGet, "/opcuaConnection", spindryerGroup.GetOpcuaConnection)
-	spindryerRouter.HandleFn(http.MethodDelete, "/work/:id", spindryerGroup.DeleteWork)
+	spindryerRouter.HandleFn(http.MethodDelete, "/work/:id", spindryerGroup.DeleteWork, mid.Authenticate(cfg.Auth), authorize(scopeRWWorkArchive))
+	spindryerRouter.HandleFn(http.MethodPost, "/work/:id/restore", spindryerGroup.RestoreWork, mid.Authenticate(cfg.Auth), authorize(scopeRWWorkArchive))
+	spindryerRouter.HandleFn(http.MethodPatch, "/work/:id", spindryerGroup.CorrectWork, mid.Authenticate(cfg.Auth), authorize(scopeRWWorkCorrection))
+	spindryerRouter.HandleFn(http.MethodGet, "/work/:id/corrections", spindryerGroup.QueryCorrections)
+	spindryerRouter.HandleFn(http.MethodGet, "/work/:id/movements", spindryerGroup.QueryMovements)
+	spindryerRouter.HandleFn(http.MethodGet, "/report/cycleTimes", spindryerGroup.QueryCycleTimes)
+	spindryerRouter.HandleFn(http.MethodGet, "/orders", spindryerGroup.QueryOrders)
 
 	pasteurizerRouter := v1.SubGroup("/pasteurizer")
-	pasteurizerGroup := NewPasteurizerGroup(pasteurizer.NewService(pasteurizer.NewStore(db, log), shutdown, log, io))
+	pasteurizerGroup := NewPasteurizerGroup(cfg.Pasteurizer)
 	pasteurizerRouter.HandleFn(http.MethodPost, "/createdDocuments", pasteurizerGroup.CreatedDocument)
 	pasteurizerRouter.HandleFn(http.MethodPost, "/opcuaConnect", pasteurizerGroup.OpcuaConnect)
 	pasteurizerRouter.HandleFn(http.MethodPost, "/opcuaDisconnect", pasteurizerGroup.OpcuaDisconnect)
 	pasteurizerRouter.HandleFn(http.MethodPost, "/work", pasteurizerGroup.InsertWork)
 	pasteurizerRouter.HandleFn(http.MethodGet, "/work", pasteurizerGroup.QueryWork)
+	pasteurizerRouter.HandleFn(http.MethodGet, "/work/archive", pasteurizerGroup.QueryArchive)
 	pasteurizerRouter.HandleFn(http.MethodGet, "/opcuaConnection", pasteurizerGroup.GetOpcuaConnection)
-	pasteurizerRouter.HandleFn(http.MethodDelete, "/work/:id", pasteurizerGroup.DeleteWork)
+	pasteurizerRouter.HandleFn(http.MethodDelete, "/work/:id", pasteurizerGroup.DeleteWork, mid.Authenticate(cfg.Auth), authorize(scopeRWWorkArchive))
+	pasteurizerRouter.HandleFn(http.MethodPost, "/work/:id/restore", pasteurizerGroup.RestoreWork, mid.Authenticate(cfg.Auth), authorize(scopeRWWorkArchive))
+	pasteurizerRouter.HandleFn(http.MethodPatch, "/work/:id", pasteurizerGroup.CorrectWork, mid.Authenticate(cfg.Auth), authorize(scopeRWWorkCorrection))
+	pasteurizerRouter.HandleFn(http.MethodGet, "/work/:id/corrections", pasteurizerGroup.QueryCorrections)
+	pasteurizerRouter.HandleFn(http.MethodGet, "/work/:id/movements", pasteurizerGroup.QueryMovements)
+	pasteurizerRouter.HandleFn(http.MethodGet, "/report/cycleTimes", pasteurizerGroup.QueryCycleTimes)
+	pasteurizerRouter.HandleFn(http.MethodGet, "/orders", pasteurizerGroup.QueryOrders)
 
 	return router
 }
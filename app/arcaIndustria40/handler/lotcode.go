@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/lotcode"
+	"github.com/devsamuele/service-kit/web"
+)
+
+type LotcodeGroup struct {
+	srv *lotcode.Service
+}
+
+func NewLotcodeGroup(srv *lotcode.Service) LotcodeGroup {
+	return LotcodeGroup{
+		srv: srv,
+	}
+}
+
+func (g LotcodeGroup) NextLot(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var nl lotcode.NewLot
+	if err := web.Decode(r, &nl); err != nil {
+		return fmt.Errorf("decoding error: %w", err)
+	}
+
+	lot, err := g.srv.NextLot(ctx, nl, v.Now)
+	if err != nil {
+		return web.ErrHandler(err)
+	}
+
+	return web.Respond(ctx, w, lot, http.StatusOK)
+}
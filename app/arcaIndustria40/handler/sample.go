@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/sample"
+	"github.com/devsamuele/service-kit/web"
+)
+
+type SampleGroup struct {
+	srv        sample.Service
+	maintainer *sample.Maintainer
+}
+
+func NewSampleGroup(srv sample.Service, maintainer *sample.Maintainer) SampleGroup {
+	return SampleGroup{
+		srv:        srv,
+		maintainer: maintainer,
+	}
+}
+
+func (g SampleGroup) QuerySeries(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	params := web.QueryParams(r)
+
+	series, err := g.srv.Query(ctx, web.URIParams(r)["machine"], sample.Query{
+		Tag:    params["tag"],
+		From:   params["from"],
+		To:     params["to"],
+		WorkID: params["work_id"],
+		Step:   params["step"],
+	}, v.Now)
+	if err != nil {
+		return web.ErrHandler(err)
+	}
+
+	return web.Respond(ctx, w, series, http.StatusOK)
+}
+
+func (g SampleGroup) MaintenanceStats(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	return web.Respond(ctx, w, g.maintainer.Stats(), http.StatusOK)
+}
+
+func (g SampleGroup) PauseMaintenance(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	return web.Respond(ctx, w, g.maintainer.Pause(), http.StatusOK)
+}
+
+func (g SampleGroup) ResumeMaintenance(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	return web.Respond(ctx, w, g.maintainer.Resume(), http.StatusOK)
+}
@@ -2,11 +2,14 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/spindryer"
+	"github.com/devsamuele/service-kit/auth"
 	"github.com/devsamuele/service-kit/web"
 )
 
@@ -54,14 +57,51 @@ func (g SpindryerGroup) QueryWork(ctx context.Context, w http.ResponseWriter, r
 		return web.NewShutdownError("web value missing from context")
 	}
 
-	work, err := g.srv.QueryWork(ctx)
+	work, next, err := g.srv.QueryWork(ctx, spindryerWorkQuery(web.QueryParams(r)))
 	if err != nil {
-		return err
+		return web.ErrHandler(err)
+	}
+
+	if next != "" {
+		w.Header().Set(HeaderNextPageToken, next)
+	}
+
+	return web.Respond(ctx, w, work, http.StatusOK)
+}
+
+func (g SpindryerGroup) QueryArchive(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	work, next, err := g.srv.QueryArchive(ctx, spindryerWorkQuery(web.QueryParams(r)))
+	if err != nil {
+		return web.ErrHandler(err)
+	}
+
+	if next != "" {
+		w.Header().Set(HeaderNextPageToken, next)
 	}
 
 	return web.Respond(ctx, w, work, http.StatusOK)
 }
 
+// spindryerWorkQuery reads the filters, the sort and the page of a work list.
+func spindryerWorkQuery(params map[string]string) spindryer.WorkQuery {
+	return spindryer.WorkQuery{
+		From:            params["from"],
+		To:              params["to"],
+		CdLotto:         params["cd_lotto"],
+		CdAr:            params["cd_ar"],
+		Status:          params["status"],
+		DocumentCreated: params["document_created"],
+		Sort:            params["sort"],
+		Limit:           params["limit"],
+		PageToken:       params["page_token"],
+	}
+}
+
 func (g SpindryerGroup) GetOpcuaConnection(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	_, ok := ctx.Value(web.KeyValues).(*web.Values)
 	if !ok {
@@ -74,21 +114,50 @@ func (g SpindryerGroup) GetOpcuaConnection(ctx context.Context, w http.ResponseW
 }
 
 func (g SpindryerGroup) DeleteWork(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
 	if !ok {
 		return web.NewShutdownError("web value missing from context")
 	}
 
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
 	id := web.URIParams(r)["id"]
 
-	err := g.srv.DeleteWork(ctx, id)
+	// The reason is required: a request without a body gets the same 400
+	// as one without the reason.
+	var dw spindryer.DeleteWork
+	if err := web.Decode(r, &dw); err != nil {
+		if errors.Is(err, io.EOF) {
+			return web.ErrHandler(web.NewError("reason is required", web.ErrReasonRequired, "argument", "reason"))
+		}
+		return fmt.Errorf("decoding error: %w", err)
+	}
+
+	err := g.srv.DeleteWork(ctx, id, dw, claims.Subject, v.Now)
 	if err != nil {
-		return err
+		return web.ErrHandler(err)
 	}
 
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
 
+func (g SpindryerGroup) RestoreWork(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	work, err := g.srv.RestoreWork(ctx, web.URIParams(r)["id"], v.Now)
+	if err != nil {
+		return web.ErrHandler(err)
+	}
+
+	return web.Respond(ctx, w, work, http.StatusOK)
+}
+
 func (g SpindryerGroup) InsertWork(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
 	_, ok := ctx.Value(web.KeyValues).(*web.Values)
@@ -103,7 +172,7 @@ func (g SpindryerGroup) InsertWork(ctx context.Context, w http.ResponseWriter, r
 
 	work, err := g.srv.InsertWork(ctx, nw, time.Now())
 	if err != nil {
-		return err
+		return web.ErrHandler(err)
 	}
 
 	return web.Respond(ctx, w, work, http.StatusCreated)
@@ -111,7 +180,7 @@ func (g SpindryerGroup) InsertWork(ctx context.Context, w http.ResponseWriter, r
 
 func (g SpindryerGroup) CreatedDocument(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
-	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
 	if !ok {
 		return web.NewShutdownError("web value missing from context")
 	}
@@ -121,10 +190,96 @@ func (g SpindryerGroup) CreatedDocument(ctx context.Context, w http.ResponseWrit
 		return fmt.Errorf("decoding error: %w", err)
 	}
 
-	err := g.srv.SetCreatedDocument(ctx, ids)
+	err := g.srv.CreateDocuments(ctx, ids, v.Now)
 	if err != nil {
-		return err
+		return web.ErrHandler(err)
 	}
 
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
+
+func (g SpindryerGroup) CorrectWork(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := web.URIParams(r)["id"]
+
+	var cw spindryer.CorrectWork
+	if err := web.Decode(r, &cw); err != nil {
+		return fmt.Errorf("decoding error: %w", err)
+	}
+
+	work, err := g.srv.CorrectWork(ctx, id, cw, claims.Subject, v.Now)
+	if err != nil {
+		return web.ErrHandler(err)
+	}
+
+	return web.Respond(ctx, w, work, http.StatusOK)
+}
+
+func (g SpindryerGroup) QueryCorrections(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	id := web.URIParams(r)["id"]
+
+	corrections, err := g.srv.QueryCorrections(ctx, id)
+	if err != nil {
+		return web.ErrHandler(err)
+	}
+
+	return web.Respond(ctx, w, corrections, http.StatusOK)
+}
+
+func (g SpindryerGroup) QueryCycleTimes(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	params := web.QueryParams(r)
+
+	cycleTimes, err := g.srv.QueryCycleTimes(ctx, params["from"], params["to"], v.Now)
+	if err != nil {
+		return web.ErrHandler(err)
+	}
+
+	return web.Respond(ctx, w, cycleTimes, http.StatusOK)
+}
+
+func (g SpindryerGroup) QueryOrders(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	orders, err := g.srv.QueryOrders(ctx)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, orders, http.StatusOK)
+}
+
+func (g SpindryerGroup) QueryMovements(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	movements, err := g.srv.QueryMovements(ctx, web.URIParams(r)["id"])
+	if err != nil {
+		return web.ErrHandler(err)
+	}
+
+	return web.Respond(ctx, w, movements, http.StatusOK)
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/events"
+	"github.com/devsamuele/service-kit/web"
+)
+
+// sseHeartbeat is how often a comment is sent on an idle event stream, to
+// keep the proxies from closing it and to notice the clients gone.
+const sseHeartbeat = 15 * time.Second
+
+// sseRetry is the reconnection delay, in milliseconds, asked to the clients.
+const sseRetry = 3000
+
+// Stream sends the events as Server-Sent Events, for the clients that cannot
+// use the websocket. The machine parameter lists, comma separated, the
+// machines followed, all of them when absent. A client resuming with
+// Last-Event-ID, or the last_event_id parameter, gets the events it missed
+// or, when they are no longer kept, a snapshot event.
+//
+// The connection is hijacked, as the websocket does, so that the write
+// timeout of the server does not cut the stream.
+func (g EventsGroup) Stream(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	params := web.QueryParams(r)
+
+	sub := events.Subscription{Machines: events.Machines}
+	if params["machine"] != "" {
+		sub.Machines = strings.Split(params["machine"], ",")
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = params["last_event_id"]
+	}
+	if lastEventID != "" {
+		epoch, seq, err := events.ParseID(lastEventID)
+		if err != nil {
+			return web.ErrHandler(web.NewError(err.Error(), web.ErrReasonInvalidParameter, "parameter", "Last-Event-ID"))
+		}
+		sub.Epoch, sub.LastSeq = epoch, &seq
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return errors.New("event stream: connection cannot be hijacked")
+	}
+
+	l, err := g.stream.Listen(sub)
+	if err != nil {
+		if errors.Is(err, events.ErrStreamClosed) {
+			return web.ErrHandler(web.NewError("server shutting down", web.ErrReasonInternalError, "", ""))
+		}
+		return web.ErrHandler(web.NewError(err.Error(), web.ErrReasonInvalidParameter, "parameter", "machine"))
+	}
+	defer l.Close()
+
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return fmt.Errorf("event stream: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Time{})
+
+	// The client sends nothing more: a read ending tells it is gone.
+	gone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, buf.Reader)
+		close(gone)
+	}()
+
+	h := w.Header().Clone()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "close")
+	h.Set("X-Accel-Buffering", "no")
+
+	if _, err := fmt.Fprintf(buf.Writer, "HTTP/1.1 200 OK\r\n"); err != nil {
+		return nil
+	}
+	if err := h.Write(buf.Writer); err != nil {
+		return nil
+	}
+	if _, err := fmt.Fprintf(buf.Writer, "\r\nretry: %d\n\n", sseRetry); err != nil {
+		return nil
+	}
+
+	if !l.Resumed {
+		sctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+		snapshot, err := g.snapshot(sctx, sub, l.Epoch, l.Seq)
+		cancel()
+		if err != nil {
+			g.log.Printf("events: snapshot: %s", err)
+			return nil
+		}
+
+		b, err := json.Marshal(snapshot)
+		if err != nil {
+			g.log.Printf("events: snapshot: %s", err)
+			return nil
+		}
+		if err := writeEvent(buf.Writer, events.Message{ID: events.FormatID(l.Epoch, l.Seq), Event: eventSnapshot, Data: b}); err != nil {
+			return nil
+		}
+	}
+
+	for _, m := range l.Missed {
+		if err := writeEvent(buf.Writer, m); err != nil {
+			return nil
+		}
+	}
+	if err := buf.Writer.Flush(); err != nil {
+		return nil
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case m, ok := <-l.C:
+			if !ok {
+				return nil
+			}
+			if err := writeEvent(buf.Writer, m); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(buf.Writer, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+		case <-gone:
+			return nil
+		}
+
+		if err := buf.Writer.Flush(); err != nil {
+			return nil
+		}
+	}
+}
+
+// writeEvent writes m in the Server-Sent Events format. The data is compact
+// JSON, so it fits a single data line.
+func writeEvent(w *bufio.Writer, m events.Message) error {
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", m.ID, m.Event, m.Data)
+	return err
+}
@@ -7,6 +7,8 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"expvar"
 	"fmt"
 	"log"
@@ -18,7 +20,21 @@ import (
 
 	"github.com/ardanlabs/conf"
 	"github.com/devsamuele/millefrutti-industria_4_0_backend/app/arcaIndustria40/handler"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/arca"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/events"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/genealogy"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/lotcode"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/pasteurizer"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/sample"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/schema"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/spindryer"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/stock"
 	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/database"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/jwks"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/opcuaconn"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/outbox"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/profile"
+	"github.com/devsamuele/service-kit/auth"
 	"github.com/devsamuele/service-kit/ws"
 	"github.com/rs/cors"
 )
@@ -43,20 +59,109 @@ func run(log *log.Logger) error {
 	// Configuration
 	var cfg struct {
 		conf.Version
-		Web struct {
+		Args conf.Args
+		Web  struct {
 			APIHost         string        `conf:"default:0.0.0.0:9000"`
 			DebugHost       string        `conf:"default:0.0.0.0:8000"`
 			ReadTimeout     time.Duration `conf:"default:10s"`
 			WriteTimeout    time.Duration `conf:"default:10s"`
 			ShutdownTimeout time.Duration `conf:"default:10s"`
 		}
+		// The tokens are verified with the keys of the set at KeySetURL,
+		// whatever their jku header says. Without it the routes needing a
+		// scope reject every request.
 		Auth struct {
-			Algorithm string `conf:"default:RS256"`
+			Algorithm     string `conf:"default:RS256"`
+			KeySetURL     string
+			KeySetTimeout time.Duration `conf:"default:10s"`
+			KeySetRefresh time.Duration `conf:"default:1h"`
 		}
+		// Profile selects the database, the machine endpoints and the
+		// feature toggles of the environment: production, demo or local,
+		// the offline development one. The settings of the profile can
+		// still be overridden one by one through the environment or the
+		// flags.
+		Profile string `conf:"default:production"`
+		// The password is read from PasswordFile when set, e.g. a Docker
+		// secret, else from --db-password or CONTACT_DB_PASSWORD.
 		DB struct {
-			// URI     string        `conf:"default:localhost"`
-			Name    string        `conf:"default:contact"`
-			Timeout time.Duration `conf:"default:10s"`
+			Driver          string
+			User            string
+			Password        string `conf:"mask"`
+			PasswordFile    string
+			Host            string
+			Name            string
+			Path            string
+			Timeout         time.Duration `conf:"default:10s"`
+			ConnectTimeout  time.Duration `conf:"default:10s"`
+			MaxOpenConns    int           `conf:"default:20"`
+			MaxIdleConns    int           `conf:"default:5"`
+			ConnMaxLifetime time.Duration `conf:"default:30m"`
+			MigrateOnStart  bool          `conf:"default:false"`
+			MigrateTimeout  time.Duration `conf:"default:30m"`
+		}
+		Spindryer struct {
+			DocumentType     string `conf:"default:PCE"`
+			Warehouse        string `conf:"default:00001"`
+			Causale          string `conf:"default:CPR"`
+			Party            string
+			OrderType        string `conf:"default:OPC"`
+			OrderUnit        string `conf:"default:KG"`
+			LotCode          string `conf:"default:C"`
+			LotPattern       string `conf:"default:{yy}{julian}{machine}{seq:3}"`
+			LotLabel         string `conf:"default:Centrifuga"`
+			ShelfLife        int    `conf:"default:365"`
+			StockMovements   bool   `conf:"default:false"`
+			LoadWarehouse    string `conf:"default:00001"`
+			OpcuaEndpoint    string
+			OpcuaDialTimeout time.Duration `conf:"default:10s"`
+		}
+		Pasteurizer struct {
+			DocumentType     string `conf:"default:PPA"`
+			Warehouse        string `conf:"default:00001"`
+			Causale          string `conf:"default:CPR"`
+			Party            string
+			OrderType        string `conf:"default:OPP"`
+			OrderUnit        string `conf:"default:KG"`
+			LotCode          string `conf:"default:P"`
+			LotPattern       string `conf:"default:{yy}{julian}{machine}{seq:3}"`
+			LotLabel         string `conf:"default:Pastorizzatore"`
+			ShelfLife        int    `conf:"default:365"`
+			StockMovements   bool   `conf:"default:false"`
+			LoadWarehouse    string `conf:"default:00001"`
+			UnloadWarehouse  string `conf:"default:00001"`
+			OpcuaEndpoint    string
+			OpcuaDialTimeout time.Duration `conf:"default:10s"`
+		}
+		Outbox struct {
+			Dir string `conf:"default:outbox"`
+		}
+		Documents struct {
+			SyncInterval time.Duration `conf:"default:1m"`
+		}
+		// Archived works are purged once older than Retention, 0 keeps
+		// them forever.
+		Archive struct {
+			Retention     time.Duration `conf:"default:2160h"`
+			PurgeInterval time.Duration `conf:"default:1h"`
+		}
+		// Every tag notification is stored, in batches of BatchSize or
+		// every FlushInterval. Up to MaxPending samples are kept while the
+		// database is unreachable. Each MaintenanceInterval the samples are
+		// rolled up into minutes and hours, and the samples and the minutes
+		// older than their retention are purged, 0 keeping them forever.
+		Samples struct {
+			BatchSize           int           `conf:"default:200"`
+			FlushInterval       time.Duration `conf:"default:1s"`
+			MaxPending          int           `conf:"default:10000"`
+			MaintenanceInterval time.Duration `conf:"default:1m"`
+			RawRetention        time.Duration `conf:"default:720h"`
+			MinuteRetention     time.Duration `conf:"default:8760h"`
+		}
+		// The last ReplayBuffer events are kept to be sent again to the
+		// websocket clients reconnecting after a gap.
+		Events struct {
+			ReplayBuffer int `conf:"default:1000"`
 		}
 	}
 
@@ -84,6 +189,21 @@ func run(log *log.Logger) error {
 		return fmt.Errorf("parsing config: %w", err)
 	}
 
+	// Parsed again with the profile, between the defaults and the
+	// environment and the flags.
+	prof, err := profile.Lookup(cfg.Profile)
+	if err != nil {
+		return fmt.Errorf("parsing config: %w", err)
+	}
+	if err := conf.Parse(os.Args[1:], "CONTACT", &cfg, prof); err != nil {
+		return fmt.Errorf("parsing config with profile %s: %w", prof.Name, err)
+	}
+
+	cfg.DB.Password, err = profile.Secret(cfg.DB.Password, cfg.DB.PasswordFile)
+	if err != nil {
+		return fmt.Errorf("parsing config: db password: %w", err)
+	}
+
 	log.Printf("main: Started: Application initializing: version %q", build)
 	defer log.Println("main: Completed")
 
@@ -91,15 +211,64 @@ func run(log *log.Logger) error {
 	if err != nil {
 		return fmt.Errorf("generating config for output: %w", err)
 	}
+	log.Printf("main: Profile: %s", prof.Name)
 	log.Printf("main: Config:\n%v\n", out)
 
 	// Database
 	log.Println("main: Initializing database support")
-	db, err := database.Open()
+	switch cfg.DB.Driver {
+	case database.DriverSQLServer, database.DriverSQLite:
+	default:
+		return fmt.Errorf("main: unknown db driver %q", cfg.DB.Driver)
+	}
+
+	if cfg.DB.Driver == database.DriverSQLServer && cfg.DB.Password == "" {
+		return errors.New("main: db password missing, set CONTACT_DB_PASSWORD or --db-password-file")
+	}
+
+	db, err := database.Open(database.Config{
+		Driver:          cfg.DB.Driver,
+		User:            cfg.DB.User,
+		Password:        cfg.DB.Password,
+		Host:            cfg.DB.Host,
+		Name:            cfg.DB.Name,
+		Path:            cfg.DB.Path,
+		MaxOpenConns:    cfg.DB.MaxOpenConns,
+		MaxIdleConns:    cfg.DB.MaxIdleConns,
+		ConnMaxLifetime: cfg.DB.ConnMaxLifetime,
+		ConnectTimeout:  cfg.DB.ConnectTimeout,
+	})
 	if err != nil {
 		return fmt.Errorf("main: opening db: %w", err)
 	}
 
+	// Schema
+	switch cfg.Args.Num(0) {
+	case "migrate":
+		return migrate(log, db, cfg.DB.Driver, cfg.Args.Num(1), cfg.DB.MigrateTimeout)
+	case "":
+	default:
+		return fmt.Errorf("main: unknown command %q", cfg.Args.Num(0))
+	}
+
+	// The development database is created and kept up to date on its own.
+	migrateOnStart := cfg.DB.MigrateOnStart || cfg.DB.Driver == database.DriverSQLite
+	checkDB := func() error {
+		return checkSchema(log, db, cfg.DB.Driver, migrateOnStart, cfg.DB.Timeout, cfg.DB.MigrateTimeout)
+	}
+
+	// When the database cannot be reached the machines keep running on the
+	// outbox, and the schema is checked on the first successful connect.
+	schemaChecked := true
+	if err := checkDB(); err != nil {
+		if !errors.Is(err, errUnreachable) {
+			return err
+		}
+		log.Printf("main: schema version not checked yet: %v", err)
+		schemaChecked = false
+	}
+
+	schemaErrors := make(chan error, 1)
 	go func() {
 		var connected bool
 		for {
@@ -113,6 +282,15 @@ func run(log *log.Logger) error {
 					log.Printf("sql: db connected")
 				}
 				connected = true
+
+				if !schemaChecked {
+					if err := checkDB(); !errors.Is(err, errUnreachable) {
+						schemaChecked = true
+						if err != nil {
+							schemaErrors <- err
+						}
+					}
+				}
 			}
 			time.Sleep(time.Second * 5)
 		}
@@ -121,7 +299,10 @@ func run(log *log.Logger) error {
 	// Debug Service
 	// /debug/pprof endpoint
 	// /debug/vars endpoint
+	// /debug/profile endpoint
 	expvar.NewString("build").Set(build)
+	expvar.NewString("profile").Set(prof.Name)
+	http.Handle("/debug/profile", handler.Profile(prof, out))
 	log.Println("main: Initializing debugging support")
 	go func() {
 		log.Printf("main: Debug Listening %s", cfg.Web.DebugHost)
@@ -130,27 +311,188 @@ func run(log *log.Logger) error {
 		}
 	}()
 
+	// Auth
+	log.Println("main: Initializing authentication support")
+	a, err := auth.New(cfg.Auth.Algorithm)
+	if err != nil {
+		return fmt.Errorf("main: constructing auth: %w", err)
+	}
+	if cfg.Auth.KeySetURL == "" {
+		log.Println("main: no auth key set configured, the routes needing a scope reject every request")
+	}
+	keys := jwks.New(jwks.Config{
+		URL:          cfg.Auth.KeySetURL,
+		Timeout:      cfg.Auth.KeySetTimeout,
+		RefreshEvery: cfg.Auth.KeySetRefresh,
+	})
+	if err := a.SetLookup(keys.Lookup); err != nil {
+		return fmt.Errorf("main: constructing auth: %w", err)
+	}
+
 	// websocket init
 	log.Println("main: Initializing websocket support")
 	io := ws.New(nil)
+	stream := events.NewStream(cfg.Events.ReplayBuffer)
 
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
-	// OPCUA Spindryer Service
+	// OPCUA Services
 	log.Println("main: Initializing opcua support")
+	stores := newStores(cfg.DB.Driver, db, log)
+
+	spindryerOutbox, err := outbox.Open(cfg.Outbox.Dir, lotcode.MachineSpindryer)
+	if err != nil {
+		return fmt.Errorf("main: opening spindryer outbox: %w", err)
+	}
+	defer spindryerOutbox.Close()
+
+	pasteurizerOutbox, err := outbox.Open(cfg.Outbox.Dir, lotcode.MachinePasteurizer)
+	if err != nil {
+		return fmt.Errorf("main: opening pasteurizer outbox: %w", err)
+	}
+	defer pasteurizerOutbox.Close()
+
+	lots, err := lotcode.NewService(stores.lots, stores.arca, map[string]lotcode.Config{
+		lotcode.MachineSpindryer:   {Code: cfg.Spindryer.LotCode, Pattern: cfg.Spindryer.LotPattern},
+		lotcode.MachinePasteurizer: {Code: cfg.Pasteurizer.LotCode, Pattern: cfg.Pasteurizer.LotPattern},
+	}, log)
+	if err != nil {
+		return fmt.Errorf("main: constructing lot numbering: %w", err)
+	}
+
+	// Tag history
+	log.Println("main: Initializing tag samples recorder")
+	samples := sample.NewRecorder(stores.samples, sample.RecorderConfig{
+		BatchSize:     cfg.Samples.BatchSize,
+		FlushInterval: cfg.Samples.FlushInterval,
+		MaxPending:    cfg.Samples.MaxPending,
+	}, log)
+
+	samplesCtx, samplesCancel := context.WithCancel(context.Background())
+	samplesDone := make(chan struct{})
+	go func() {
+		samples.Run(samplesCtx)
+		close(samplesDone)
+	}()
+	defer func() {
+		samplesCancel()
+		<-samplesDone
+	}()
+
+	if cfg.Spindryer.Party == "" || cfg.Pasteurizer.Party == "" {
+		log.Println("main: no document party configured for a machine, its Arca documents cannot be created")
+	}
+
+	spindryerService := spindryer.NewService(stores.spindryer, stores.arca, arca.DocumentConfig{
+		DocumentType: cfg.Spindryer.DocumentType,
+		Warehouse:    cfg.Spindryer.Warehouse,
+		Causale:      cfg.Spindryer.Causale,
+		Party:        cfg.Spindryer.Party,
+		OrderType:    cfg.Spindryer.OrderType,
+		OrderUnit:    cfg.Spindryer.OrderUnit,
+	}, arca.LotConfig{
+		Label:         cfg.Spindryer.LotLabel,
+		ShelfLifeDays: cfg.Spindryer.ShelfLife,
+	}, lots, spindryerOutbox, stock.NewService(stores.stock, lotcode.MachineSpindryer, stock.Config{
+		Enabled:       cfg.Spindryer.StockMovements,
+		LoadWarehouse: cfg.Spindryer.LoadWarehouse,
+	}, log), samples, opcuaconn.Config{
+		Endpoint:    cfg.Spindryer.OpcuaEndpoint,
+		DialTimeout: cfg.Spindryer.OpcuaDialTimeout,
+	}, shutdown, log, stream)
+
+	pasteurizerService := pasteurizer.NewService(stores.pasteurizer, stores.arca, arca.DocumentConfig{
+		DocumentType: cfg.Pasteurizer.DocumentType,
+		Warehouse:    cfg.Pasteurizer.Warehouse,
+		Causale:      cfg.Pasteurizer.Causale,
+		Party:        cfg.Pasteurizer.Party,
+		OrderType:    cfg.Pasteurizer.OrderType,
+		OrderUnit:    cfg.Pasteurizer.OrderUnit,
+	}, arca.LotConfig{
+		Label:         cfg.Pasteurizer.LotLabel,
+		ShelfLifeDays: cfg.Pasteurizer.ShelfLife,
+	}, lots, stores.genealogy, pasteurizerOutbox, stock.NewService(stores.stock, lotcode.MachinePasteurizer, stock.Config{
+		Enabled:         cfg.Pasteurizer.StockMovements,
+		LoadWarehouse:   cfg.Pasteurizer.LoadWarehouse,
+		UnloadWarehouse: cfg.Pasteurizer.UnloadWarehouse,
+	}, log), samples, opcuaconn.Config{
+		Endpoint:    cfg.Pasteurizer.OpcuaEndpoint,
+		DialTimeout: cfg.Pasteurizer.OpcuaDialTimeout,
+	}, shutdown, log, stream)
+
+	// Arca documents sync
+	log.Println("main: Initializing documents sync")
+	syncCtx, syncCancel := context.WithCancel(context.Background())
+	defer syncCancel()
+
+	go syncDocuments(syncCtx, log, cfg.Documents.SyncInterval, map[string]func(context.Context) error{
+		"spindryer":   spindryerService.SyncDocuments,
+		"pasteurizer": pasteurizerService.SyncDocuments,
+	})
+
+	// Archive purge
+	if cfg.Archive.Retention > 0 {
+		log.Println("main: Initializing archive purge")
+		go purgeArchive(syncCtx, log, cfg.Archive.PurgeInterval, cfg.Archive.Retention, map[string]func(context.Context, time.Time) (int, error){
+			"spindryer":   spindryerService.PurgeArchive,
+			"pasteurizer": pasteurizerService.PurgeArchive,
+		})
+	}
+
+	// Tag history maintenance
+	log.Println("main: Initializing tag samples maintenance")
+	samplesMaintenance := sample.MaintenanceConfig{
+		Interval:        cfg.Samples.MaintenanceInterval,
+		RawRetention:    cfg.Samples.RawRetention,
+		MinuteRetention: cfg.Samples.MinuteRetention,
+	}
+	maintainer := sample.NewMaintainer(stores.samples, samplesMaintenance, log)
+	expvar.Publish("samples_maintenance", expvar.Func(func() interface{} { return maintainer.Stats() }))
+	go maintainer.Run(syncCtx)
 
 	// Start API Service
 	log.Println("main: Initializing API support")
 
 	serverErrors := make(chan error, 1)
 
+	// As cors.AllowAll, exposing to the browser the headers of the API.
+	apiCors := cors.New(cors.Options{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{
+			http.MethodHead,
+			http.MethodGet,
+			http.MethodPost,
+			http.MethodPut,
+			http.MethodPatch,
+			http.MethodDelete,
+		},
+		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{handler.HeaderNextPageToken},
+	})
+
 	api := http.Server{
-		Addr:              cfg.Web.APIHost,
-		Handler:           cors.AllowAll().Handler(handler.API(build, db, &io, shutdown, log)),
+		Addr: cfg.Web.APIHost,
+		Handler: apiCors.Handler(handler.API(handler.APIConfig{
+			Build:       build,
+			Shutdown:    shutdown,
+			Log:         log,
+			IO:          &io,
+			Events:      stream,
+			Auth:        a,
+			Arca:        arca.NewService(stores.arca, log),
+			Lots:        lots,
+			Genealogy:   genealogy.NewService(stores.genealogy, log),
+			Samples:     sample.NewService(stores.samples, samplesMaintenance, log),
+			Maintainer:  maintainer,
+			Spindryer:   spindryerService,
+			Pasteurizer: pasteurizerService,
+		})),
 		ReadHeaderTimeout: cfg.Web.ReadTimeout,
 		WriteTimeout:      cfg.Web.WriteTimeout,
 	}
+	// The event streams are hijacked connections, not waited by Shutdown.
+	api.RegisterOnShutdown(stream.Close)
 
 	go func() {
 		log.Printf("main: API listening on %s", api.Addr)
@@ -161,6 +503,8 @@ func run(log *log.Logger) error {
 	select {
 	case err := <-serverErrors:
 		return fmt.Errorf("server error: %w", err)
+	case err := <-schemaErrors:
+		return err
 	case sig := <-shutdown:
 		log.Printf("main: %v : Start shutdown", sig)
 
@@ -174,3 +518,176 @@ func run(log *log.Logger) error {
 	}
 	return nil
 }
+
+// syncDocuments runs the documents sync of every machine each interval until
+// ctx is cancelled.
+func syncDocuments(ctx context.Context, log *log.Logger, interval time.Duration, syncs map[string]func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for machine, sync := range syncs {
+			if err := sync(ctx); err != nil {
+				log.Printf("main: %s documents sync: %v", machine, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeArchive removes, each interval until ctx is cancelled, the works of
+// every machine archived for longer than retention.
+func purgeArchive(ctx context.Context, log *log.Logger, interval, retention time.Duration, purges map[string]func(context.Context, time.Time) (int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		before := time.Now().Add(-retention)
+		for machine, purge := range purges {
+			n, err := purge(ctx, before)
+			if err != nil {
+				log.Printf("main: %s archive purge: %v", machine, err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("main: %s archive purge: %d works purged", machine, n)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// migrate applies the pending migrations, or lists the applied ones when
+// command is status.
+func migrate(log *log.Logger, db *sql.DB, driver, command string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch command {
+	case "":
+		applied, err := schema.Migrate(ctx, db, driver, time.Now())
+		for _, m := range applied {
+			log.Printf("main: migration %04d %s applied", m.Version, m.Description)
+		}
+		if err != nil {
+			return fmt.Errorf("main: migrating: %w", err)
+		}
+		log.Printf("main: %d migrations applied", len(applied))
+		return nil
+
+	case "status":
+		if _, err := schema.Current(ctx, db, driver); err != nil {
+			return fmt.Errorf("main: reading schema version: %w", err)
+		}
+
+		applied, err := schema.QueryApplied(ctx, db)
+		if err != nil {
+			return fmt.Errorf("main: reading schema version: %w", err)
+		}
+		for _, a := range applied {
+			log.Printf("main: migration %04d %s applied %s", a.Version, a.Description, a.Applied.Format(time.RFC3339))
+		}
+
+		latest, err := schema.Latest(driver)
+		if err != nil {
+			return err
+		}
+		log.Printf("main: latest migration %04d", latest)
+		return nil
+	}
+
+	return fmt.Errorf("main: unknown migrate command %q", command)
+}
+
+// errUnreachable is returned by checkSchema when the database cannot be
+// reached, so that the check is retried once it can.
+var errUnreachable = errors.New("database unreachable")
+
+// checkSchema refuses to start when the database misses migrations, applying
+// them first, within migrateTimeout, when migrateOnStart is set. It returns
+// errUnreachable when the database cannot be reached within timeout.
+func checkSchema(log *log.Logger, db *sql.DB, driver string, migrateOnStart bool, timeout, migrateTimeout time.Duration) error {
+	pingCtx, pingCancel := context.WithTimeout(context.Background(), timeout)
+	err := db.PingContext(pingCtx)
+	pingCancel()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnreachable, err)
+	}
+
+	if migrateOnStart {
+		migrateCtx, migrateCancel := context.WithTimeout(context.Background(), migrateTimeout)
+		applied, err := schema.Migrate(migrateCtx, db, driver, time.Now())
+		migrateCancel()
+		for _, m := range applied {
+			log.Printf("main: migration %04d %s applied", m.Version, m.Description)
+		}
+		if err != nil {
+			return fmt.Errorf("main: migrating: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	current, err := schema.Current(ctx, db, driver)
+	if err != nil {
+		return fmt.Errorf("main: reading schema version: %w", err)
+	}
+
+	latest, err := schema.Latest(driver)
+	if err != nil {
+		return err
+	}
+
+	if current < latest {
+		return fmt.Errorf("main: database schema at version %d, %d required: run the migrate command", current, latest)
+	}
+
+	log.Printf("main: database schema at version %d", current)
+	return nil
+}
+
+// stores holds the data stores of the configured database driver.
+type stores struct {
+	arca        arca.Storer
+	lots        lotcode.Storer
+	genealogy   genealogy.Storer
+	stock       stock.Storer
+	spindryer   spindryer.Storer
+	pasteurizer pasteurizer.Storer
+	samples     sample.Storer
+}
+
+func newStores(driver string, db *sql.DB, log *log.Logger) stores {
+	if driver == database.DriverSQLite {
+		return stores{
+			arca:        arca.NewSQLiteStore(db, log),
+			lots:        lotcode.NewSQLiteStore(db, log),
+			genealogy:   genealogy.NewSQLiteStore(db, log),
+			stock:       stock.NewSQLiteStore(db, log),
+			spindryer:   spindryer.NewSQLiteStore(db, log),
+			pasteurizer: pasteurizer.NewSQLiteStore(db, log),
+			samples:     sample.NewSQLiteStore(db, log),
+		}
+	}
+
+	return stores{
+		arca:        arca.NewStore(db, log),
+		lots:        lotcode.NewStore(db, log),
+		genealogy:   genealogy.NewStore(db, log),
+		stock:       stock.NewStore(db, log),
+		spindryer:   spindryer.NewStore(db, log),
+		pasteurizer: pasteurizer.NewStore(db, log),
+		samples:     sample.NewStore(db, log),
+	}
+}
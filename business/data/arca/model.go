@@ -0,0 +1,94 @@
+package arca
+
+import "time"
+
+// DocumentConfig selects the Arca document type, warehouse and causale used
+// to register the production of a machine, the party (Cd_CF) the documents
+// are made out to, and the document type of its production orders with the
+// unit of measure their lines must be in, the one the machine measures.
+type DocumentConfig struct {
+	DocumentType string
+	Warehouse    string
+	Causale      string
+	Party        string
+	OrderType    string
+	OrderUnit    string
+}
+
+// Link is the DoTes column recording the work a document was created for.
+type Link string
+
+const (
+	LinkSpindryer   Link = "xId_Centrifuga"
+	LinkPasteurizer Link = "xId_Pastorizzatore"
+)
+
+// NewDocument is what is needed to load a produced lot into Arca.
+type NewDocument struct {
+	CdAr     string
+	CdLotto  string
+	Quantity float64
+	Date     time.Time
+}
+
+type Document struct {
+	ID           int       `json:"id" db:"Id_DoTes"`
+	DocumentType string    `json:"document_type" db:"Cd_DO"`
+	Number       int       `json:"number" db:"NumeroDoc"`
+	Date         time.Time `json:"date" db:"DataDoc"`
+}
+
+// Maximum lengths of the Arca codes, as declared by the AR and ARLotto columns.
+const (
+	MaxArticleLength = 20
+	MaxLotLength     = 20
+)
+
+type Article struct {
+	CdAr          string `json:"cd_ar" db:"Cd_AR"`
+	Description   string `json:"description" db:"Descrizione"`
+	UnitOfMeasure string `json:"unit_of_measure" db:"Cd_ARMisura"`
+	Active        bool   `json:"active"`
+}
+
+type Lot struct {
+	CdLotto            string `json:"cd_lotto" db:"Cd_ARLotto"`
+	CdAr               string `json:"cd_ar" db:"Cd_AR"`
+	Description        string `json:"description" db:"Descrizione"`
+	ArticleDescription string `json:"article_description"`
+}
+
+// LotConfig describes the lots created for a machine: the label their
+// description starts with and the shelf life, in days, of the articles
+// without one of their own. A zero shelf life leaves the expiry date empty.
+type LotConfig struct {
+	Label         string
+	ShelfLifeDays int
+}
+
+// NewLot is a lot to register in ARLotto.
+type NewLot struct {
+	CdAr           string
+	CdLotto        string
+	ProductionDate time.Time
+}
+
+// Order is an open line of a production order.
+type Order struct {
+	ID                 int        `json:"id" db:"Id_DoRig"`
+	DocumentID         int        `json:"document_id" db:"Id_DoTes"`
+	DocumentType       string     `json:"document_type" db:"Cd_DO"`
+	Number             int        `json:"number" db:"NumeroDoc"`
+	Date               time.Time  `json:"date" db:"DataDoc"`
+	CdAr               string     `json:"cd_ar" db:"Cd_AR"`
+	ArticleDescription string     `json:"article_description" db:"Descrizione"`
+	Quantity           float64    `json:"quantity" db:"Qta"`
+	UnitOfMeasure      string     `json:"unit_of_measure" db:"Cd_ARMisura"`
+	ProducedQuantity   float64    `json:"produced_quantity" db:"xQtaProdotta"`
+	DueDate            *time.Time `json:"due_date" db:"DataConsegna"`
+}
+
+// Open reports whether the order still has quantity to produce.
+func (o Order) Open() bool {
+	return o.ProducedQuantity < o.Quantity
+}
@@ -0,0 +1,106 @@
+package arca
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/devsamuele/service-kit/web"
+)
+
+// Service exposes the Arca master data used by the machines.
+type Service struct {
+	store Storer
+	log   *log.Logger
+}
+
+func NewService(store Storer, log *log.Logger) Service {
+	return Service{store: store, log: log}
+}
+
+// QueryArticles searches the articles by code or description. active, when
+// set, must be a boolean and limits the result to the active articles.
+func (s Service) QueryArticles(ctx context.Context, search, active string) ([]Article, error) {
+	var onlyActive bool
+	if active != "" {
+		var err error
+		onlyActive, err = strconv.ParseBool(active)
+		if err != nil {
+			return make([]Article, 0), web.NewError("active must be a boolean", web.ErrReasonInvalidParameter, "parameter", "active")
+		}
+	}
+
+	articles, err := s.store.QueryArticles(ctx, search, onlyActive)
+	if err != nil {
+		return make([]Article, 0), err
+	}
+	return articles, nil
+}
+
+func (s Service) QueryArticleByID(ctx context.Context, cdAr string) (Article, error) {
+	a, err := s.store.QueryArticleByID(ctx, cdAr)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return Article{}, web.NewError(fmt.Sprintf("article %s not found", cdAr), web.ErrReasonNotFound, "parameter", "cd_ar")
+		}
+		return Article{}, err
+	}
+	return a, nil
+}
+
+// QueryLots searches the lots by code or description, optionally of a
+// single article.
+func (s Service) QueryLots(ctx context.Context, cdAr, search string) ([]Lot, error) {
+	lots, err := s.store.QueryLots(ctx, cdAr, search)
+	if err != nil {
+		return make([]Lot, 0), err
+	}
+	return lots, nil
+}
+
+// ValidateArticle checks that cdAr is an active article and reports the
+// problem on the cd_ar field otherwise.
+func ValidateArticle(ctx context.Context, store Storer, cdAr string) error {
+	a, err := store.QueryArticleByID(ctx, cdAr)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return web.NewError(fmt.Sprintf("article %s does not exist", cdAr), web.ErrReasonInvalidArgument, "argument", "cd_ar")
+		}
+		return err
+	}
+
+	if !a.Active {
+		return web.NewError(fmt.Sprintf("article %s is not active", cdAr), web.ErrReasonInvalidArgument, "argument", "cd_ar")
+	}
+
+	return nil
+}
+
+// ValidateOrder checks that id is an open line of a production order of
+// orderType in unit and returns it, reporting the problem on the order_id
+// field otherwise.
+func ValidateOrder(ctx context.Context, store Storer, orderType, unit string, id int) (Order, error) {
+	o, err := store.QueryOrderByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return Order{}, web.NewError(fmt.Sprintf("order line %d does not exist", id), web.ErrReasonInvalidArgument, "argument", "order_id")
+		}
+		return Order{}, err
+	}
+
+	if o.DocumentType != orderType {
+		return Order{}, web.NewError(fmt.Sprintf("order line %d is not a %s production order", id, orderType), web.ErrReasonInvalidArgument, "argument", "order_id")
+	}
+
+	if o.UnitOfMeasure != unit {
+		return Order{}, web.NewError(fmt.Sprintf("order line %d is in %s, the machine measures %s", id, o.UnitOfMeasure, unit), web.ErrReasonInvalidArgument, "argument", "order_id")
+	}
+
+	if !o.Open() {
+		return Order{}, web.NewError(fmt.Sprintf("order line %d is already produced", id), web.ErrReasonConflict, "argument", "order_id")
+	}
+
+	return o, nil
+}
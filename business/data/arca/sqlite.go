@@ -0,0 +1,253 @@
+package arca
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// SQLiteStore implements Storer on the fake Arca tables of the development
+// database, see the schema package.
+type SQLiteStore struct {
+	db  *sql.DB
+	log *log.Logger
+}
+
+func NewSQLiteStore(db *sql.DB, log *log.Logger) SQLiteStore {
+	return SQLiteStore{db: db, log: log}
+}
+
+func (s SQLiteStore) CreateDocument(ctx context.Context, tx *sql.Tx, cfg DocumentConfig, nd NewDocument) (Document, error) {
+	nd.Date = nd.Date.Local()
+
+	if cfg.Party == "" {
+		return Document{}, fmt.Errorf("no party configured for the %s documents", cfg.DocumentType)
+	}
+
+	row := tx.QueryRowContext(ctx, `select TipoDocumento from DO where Cd_DO = ?1`, cfg.DocumentType)
+	var kind string
+	if err := row.Scan(&kind); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Document{}, fmt.Errorf("document type %s not found", cfg.DocumentType)
+		}
+		return Document{}, err
+	}
+
+	row = tx.QueryRowContext(ctx, `select Cd_MGEsercizio from MGEsercizio where ?1 between date(DataInizio) and date(DataFine) limit 1`, nd.Date.Format("2006-01-02"))
+	var year string
+	if err := row.Scan(&year); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Document{}, fmt.Errorf("no fiscal year includes %s", nd.Date.Format("2006-01-02"))
+		}
+		return Document{}, err
+	}
+
+	row = tx.QueryRowContext(ctx, `select count(*) from CF where Cd_CF = ?1`, cfg.Party)
+	var parties int
+	if err := row.Scan(&parties); err != nil {
+		return Document{}, err
+	}
+	if parties == 0 {
+		return Document{}, fmt.Errorf("party %s not found", cfg.Party)
+	}
+
+	row = tx.QueryRowContext(ctx, `select coalesce(max(NumeroDoc), 0) + 1 from DoTes where Cd_DO = ?1 and Cd_MGEsercizio = ?2`, cfg.DocumentType, year)
+	var number int
+	if err := row.Scan(&number); err != nil {
+		return Document{}, err
+	}
+
+	row = tx.QueryRowContext(ctx, `insert into DoTes (Cd_DO, TipoDocumento, Cd_CF, NumeroDoc, DataDoc, Cd_MGEsercizio, Cd_MG_A, UserIns, UserUpd, TimeIns, TimeUpd)
+	values(?1,?2,?3,?4,?5,?6,?7,?8,?9,?10,?11) returning Id_DoTes`, cfg.DocumentType, kind, cfg.Party, number, nd.Date, year, cfg.Warehouse, user, user, nd.Date, nd.Date)
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return Document{}, err
+	}
+
+	_, err := tx.ExecContext(ctx, `insert into DoRig (Id_DoTes, Riga, Cd_CF, Cd_AR, Cd_ARMisura, Cd_ARLotto, Qta, Cd_MG_A, Cd_MGCausale, DataDoc, UserIns, UserUpd, TimeIns, TimeUpd)
+	select ?1, ?2, ?3, ?4, (select Cd_ARMisura from ARARMisura where Cd_AR = ?4 and DefaultMisura = 1 limit 1), ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13`,
+		id, 1, cfg.Party, nd.CdAr, nd.CdLotto, nd.Quantity, cfg.Warehouse, cfg.Causale, nd.Date, user, user, nd.Date, nd.Date)
+	if err != nil {
+		return Document{}, err
+	}
+
+	return Document{
+		ID:           id,
+		DocumentType: cfg.DocumentType,
+		Number:       number,
+		Date:         nd.Date,
+	}, nil
+}
+
+func (s SQLiteStore) LinkDocument(ctx context.Context, tx *sql.Tx, link Link, documentID, workID int) error {
+	if err := link.validate(); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `update DoTes set `+string(link)+` = ?1 where Id_DoTes = ?2`, workID, documentID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+const sqliteArticleColumns = `a.Cd_AR, coalesce(a.Descrizione, ''), coalesce(m.Cd_ARMisura, ''), case when a.Obsoleto = 0 then 1 else 0 end`
+
+func (s SQLiteStore) QueryArticles(ctx context.Context, search string, onlyActive bool) ([]Article, error) {
+	rows, err := s.db.QueryContext(ctx, `select `+sqliteArticleColumns+articleFrom+`
+	where (a.Cd_AR like ?1 or a.Descrizione like ?1) and (?2 = 0 or a.Obsoleto = 0) order by a.Cd_AR limit 50`, "%"+search+"%", onlyActive)
+	if err != nil {
+		return make([]Article, 0), err
+	}
+	defer rows.Close()
+
+	articles := make([]Article, 0)
+	for rows.Next() {
+		var a Article
+		if err := rows.Scan(&a.CdAr, &a.Description, &a.UnitOfMeasure, &a.Active); err != nil {
+			return make([]Article, 0), err
+		}
+		articles = append(articles, a)
+	}
+
+	return articles, nil
+}
+
+func (s SQLiteStore) QueryArticleByID(ctx context.Context, cdAr string) (Article, error) {
+	row := s.db.QueryRowContext(ctx, `select `+sqliteArticleColumns+articleFrom+` where a.Cd_AR = ?1 limit 1`, cdAr)
+	if err := row.Err(); err != nil {
+		return Article{}, err
+	}
+
+	var a Article
+	if err := row.Scan(&a.CdAr, &a.Description, &a.UnitOfMeasure, &a.Active); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Article{}, ErrNotFound
+		}
+		return Article{}, err
+	}
+
+	return a, nil
+}
+
+func (s SQLiteStore) QueryLots(ctx context.Context, cdAr, search string) ([]Lot, error) {
+	rows, err := s.db.QueryContext(ctx, `select l.Cd_ARLotto, l.Cd_AR, coalesce(l.Descrizione, ''), coalesce(a.Descrizione, '')
+	from ARLotto l join AR a on a.Cd_AR = l.Cd_AR
+	where (?1 = '' or l.Cd_AR = ?1) and (l.Cd_ARLotto like ?2 or l.Descrizione like ?2) order by l.TimeIns desc limit 50`, cdAr, "%"+search+"%")
+	if err != nil {
+		return make([]Lot, 0), err
+	}
+	defer rows.Close()
+
+	lots := make([]Lot, 0)
+	for rows.Next() {
+		var l Lot
+		if err := rows.Scan(&l.CdLotto, &l.CdAr, &l.Description, &l.ArticleDescription); err != nil {
+			return make([]Lot, 0), err
+		}
+		lots = append(lots, l)
+	}
+
+	return lots, nil
+}
+
+func (s SQLiteStore) QueryShelfLife(ctx context.Context, tx *sql.Tx, cdAr string) (int, error) {
+	row := tx.QueryRowContext(ctx, `select coalesce(xGiorniScadenza, 0) from AR where Cd_AR = ?1`, cdAr)
+
+	var days int
+	if err := row.Scan(&days); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	return days, nil
+}
+
+func (s SQLiteStore) CreateLot(ctx context.Context, tx *sql.Tx, cfg LotConfig, nl NewLot, now time.Time) error {
+	nl.ProductionDate, now = nl.ProductionDate.Local(), now.Local()
+	expiry, err := expiryDate(ctx, tx, s, cfg, nl.CdAr, nl.ProductionDate)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `insert into ARLotto (Cd_ARLotto, Cd_AR, Descrizione, DataProduzione, DataScadenza, UserIns, UserUpd, TimeIns, TimeUpd)
+	values(?1,?2,?3,?4,?5,?6,?7,?8,?9)`, nl.CdLotto, nl.CdAr, lotDescription(cfg, nl.ProductionDate), nl.ProductionDate, expiry, user, user, now, now)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s SQLiteStore) UpdateLotDates(ctx context.Context, tx *sql.Tx, cfg LotConfig, nl NewLot, now time.Time) error {
+	nl.ProductionDate, now = nl.ProductionDate.Local(), now.Local()
+	expiry, err := expiryDate(ctx, tx, s, cfg, nl.CdAr, nl.ProductionDate)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update ARLotto set Descrizione = ?1, DataProduzione = ?2, DataScadenza = ?3, UserUpd = ?4, TimeUpd = ?5
+	where Cd_ARLotto = ?6 and Cd_AR = ?7 and UserIns = ?4`, lotDescription(cfg, nl.ProductionDate), nl.ProductionDate, expiry, user, now, nl.CdLotto, nl.CdAr)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+const sqliteOrderColumns = `r.Id_DoRig, t.Id_DoTes, t.Cd_DO, t.NumeroDoc, t.DataDoc, r.Cd_AR, coalesce(a.Descrizione, ''), r.Qta, ` + orderUnit + `, coalesce(r.xQtaProdotta, 0), r.DataConsegna`
+
+func (s SQLiteStore) QueryOpenOrders(ctx context.Context, documentType, unit string) ([]Order, error) {
+	rows, err := s.db.QueryContext(ctx, `select `+sqliteOrderColumns+orderFrom+`
+	where t.Cd_DO = ?1 and `+orderUnit+` = ?2 and r.Cd_AR is not null and r.Evasa = 0 and coalesce(r.xQtaProdotta, 0) < r.Qta
+	order by case when r.DataConsegna is null then 1 else 0 end, r.DataConsegna, t.DataDoc, r.Riga limit 50`, documentType, unit)
+	if err != nil {
+		return make([]Order, 0), err
+	}
+	defer rows.Close()
+
+	orders := make([]Order, 0)
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return make([]Order, 0), err
+		}
+		orders = append(orders, o)
+	}
+
+	return orders, nil
+}
+
+func (s SQLiteStore) QueryOrderByID(ctx context.Context, id int) (Order, error) {
+	row := s.db.QueryRowContext(ctx, `select `+sqliteOrderColumns+orderFrom+` where r.Id_DoRig = ?1`, id)
+	if err := row.Err(); err != nil {
+		return Order{}, err
+	}
+
+	o, err := scanOrder(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Order{}, ErrNotFound
+		}
+		return Order{}, err
+	}
+
+	return o, nil
+}
+
+func (s SQLiteStore) AddOrderProgress(ctx context.Context, tx *sql.Tx, id int, quantity float64, unit string, now time.Time) error {
+	now = now.Local()
+	res, err := tx.ExecContext(ctx, `update DoRig as r set xQtaProdotta = coalesce(r.xQtaProdotta, 0) + ?1, UserUpd = ?2, TimeUpd = ?3
+	where r.Id_DoRig = ?4 and `+orderUnit+` = ?5`, quantity, user, now, id, unit)
+	if err != nil {
+		return err
+	}
+
+	return skippedProgress(res, s.log, id, unit)
+}
@@ -0,0 +1,367 @@
+package arca
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// user is written in the Arca audit columns of the records created by the service.
+const user = "opcua-service"
+
+// Storer is the Arca data the service reads and writes. Store implements it
+// on the Arca database, SQLiteStore on the fake copy used offline. Arca keeps
+// local times, so the times written are converted to the local zone.
+type Storer interface {
+	CreateDocument(ctx context.Context, tx *sql.Tx, cfg DocumentConfig, nd NewDocument) (Document, error)
+	LinkDocument(ctx context.Context, tx *sql.Tx, link Link, documentID, workID int) error
+	QueryArticles(ctx context.Context, search string, onlyActive bool) ([]Article, error)
+	QueryArticleByID(ctx context.Context, cdAr string) (Article, error)
+	QueryLots(ctx context.Context, cdAr, search string) ([]Lot, error)
+	QueryShelfLife(ctx context.Context, tx *sql.Tx, cdAr string) (int, error)
+	CreateLot(ctx context.Context, tx *sql.Tx, cfg LotConfig, nl NewLot, now time.Time) error
+	UpdateLotDates(ctx context.Context, tx *sql.Tx, cfg LotConfig, nl NewLot, now time.Time) error
+	QueryOpenOrders(ctx context.Context, documentType, unit string) ([]Order, error)
+	QueryOrderByID(ctx context.Context, id int) (Order, error)
+	AddOrderProgress(ctx context.Context, tx *sql.Tx, id int, quantity float64, unit string, now time.Time) error
+}
+
+type Store struct {
+	db  *sql.DB
+	log *log.Logger
+}
+
+func NewStore(db *sql.DB, log *log.Logger) Store {
+	return Store{db: db, log: log}
+}
+
+// CreateDocument creates a production document with a single line loading
+// the lot into the configured warehouse. The document type, the fiscal year
+// of the document date and the party must exist in Arca; the document number
+// is the next one of the document type in that fiscal year.
+func (s Store) CreateDocument(ctx context.Context, tx *sql.Tx, cfg DocumentConfig, nd NewDocument) (Document, error) {
+	nd.Date = nd.Date.Local()
+
+	if cfg.Party == "" {
+		return Document{}, fmt.Errorf("no party configured for the %s documents", cfg.DocumentType)
+	}
+
+	row := tx.QueryRowContext(ctx, `select TipoDocumento from DO where Cd_DO = @p1`, cfg.DocumentType)
+	var kind string
+	if err := row.Scan(&kind); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Document{}, fmt.Errorf("document type %s not found", cfg.DocumentType)
+		}
+		return Document{}, err
+	}
+
+	// The date is compared as a date: a time would be sent with its offset.
+	row = tx.QueryRowContext(ctx, `select top(1) Cd_MGEsercizio from MGEsercizio where cast(@p1 as date) between DataInizio and DataFine`, nd.Date)
+	var year string
+	if err := row.Scan(&year); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Document{}, fmt.Errorf("no fiscal year includes %s", nd.Date.Format("2006-01-02"))
+		}
+		return Document{}, err
+	}
+
+	row = tx.QueryRowContext(ctx, `select count(*) from CF where Cd_CF = @p1`, cfg.Party)
+	var parties int
+	if err := row.Scan(&parties); err != nil {
+		return Document{}, err
+	}
+	if parties == 0 {
+		return Document{}, fmt.Errorf("party %s not found", cfg.Party)
+	}
+
+	row = tx.QueryRowContext(ctx, `select isnull(max(NumeroDoc), 0) + 1 from DoTes with (updlock, holdlock) where Cd_DO = @p1 and Cd_MGEsercizio = @p2`, cfg.DocumentType, year)
+	var number int
+	if err := row.Scan(&number); err != nil {
+		return Document{}, err
+	}
+
+	row = tx.QueryRowContext(ctx, `insert into DoTes (Cd_DO, TipoDocumento, Cd_CF, NumeroDoc, DataDoc, Cd_MGEsercizio, Cd_MG_A, UserIns, UserUpd, TimeIns, TimeUpd) 
+	output inserted.Id_DoTes values(@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8,@p9,@p10,@p11)`, cfg.DocumentType, kind, cfg.Party, number, nd.Date, year, cfg.Warehouse, user, user, nd.Date, nd.Date)
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return Document{}, err
+	}
+
+	// The line is in the default unit of measure of the article.
+	_, err := tx.ExecContext(ctx, `insert into DoRig (Id_DoTes, Riga, Cd_CF, Cd_AR, Cd_ARMisura, Cd_ARLotto, Qta, Cd_MG_A, Cd_MGCausale, DataDoc, UserIns, UserUpd, TimeIns, TimeUpd) 
+	select @p1, @p2, @p3, @p4, (select top(1) Cd_ARMisura from ARARMisura where Cd_AR = @p4 and DefaultMisura = 1), @p5, @p6, @p7, @p8, @p9, @p10, @p11, @p12, @p13`,
+		id, 1, cfg.Party, nd.CdAr, nd.CdLotto, nd.Quantity, cfg.Warehouse, cfg.Causale, nd.Date, user, user, nd.Date, nd.Date)
+	if err != nil {
+		return Document{}, err
+	}
+
+	return Document{
+		ID:           id,
+		DocumentType: cfg.DocumentType,
+		Number:       number,
+		Date:         nd.Date,
+	}, nil
+}
+
+// LinkDocument records on the Arca document the work it was created for.
+func (s Store) LinkDocument(ctx context.Context, tx *sql.Tx, link Link, documentID, workID int) error {
+	if err := link.validate(); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `update DoTes set `+string(link)+` = @p1 where Id_DoTes = @p2`, workID, documentID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validate guards the column name, which is written into the statement.
+func (l Link) validate() error {
+	switch l {
+	case LinkSpindryer, LinkPasteurizer:
+		return nil
+	}
+	return fmt.Errorf("unknown document link %q", string(l))
+}
+
+const articleColumns = `a.Cd_AR, isnull(a.Descrizione, ''), isnull(m.Cd_ARMisura, ''), case when a.Obsoleto = 0 then 1 else 0 end`
+
+// articleFrom joins every article with its default unit of measure.
+const articleFrom = ` from AR a left join ARARMisura m on m.Cd_AR = a.Cd_AR and m.DefaultMisura = 1`
+
+// QueryArticles returns the first 50 articles whose code or description
+// contains search, optionally only the active ones.
+func (s Store) QueryArticles(ctx context.Context, search string, onlyActive bool) ([]Article, error) {
+	rows, err := s.db.QueryContext(ctx, `select top(50) `+articleColumns+articleFrom+` 
+	where (a.Cd_AR like @p1 or a.Descrizione like @p1) and (@p2 = 0 or a.Obsoleto = 0) order by a.Cd_AR`, "%"+search+"%", onlyActive)
+	if err != nil {
+		return make([]Article, 0), err
+	}
+	defer rows.Close()
+
+	articles := make([]Article, 0)
+	for rows.Next() {
+		var a Article
+		if err := rows.Scan(&a.CdAr, &a.Description, &a.UnitOfMeasure, &a.Active); err != nil {
+			return make([]Article, 0), err
+		}
+		articles = append(articles, a)
+	}
+
+	return articles, nil
+}
+
+func (s Store) QueryArticleByID(ctx context.Context, cdAr string) (Article, error) {
+	row := s.db.QueryRowContext(ctx, `select top(1) `+articleColumns+articleFrom+` where a.Cd_AR = @p1`, cdAr)
+	if err := row.Err(); err != nil {
+		return Article{}, err
+	}
+
+	var a Article
+	if err := row.Scan(&a.CdAr, &a.Description, &a.UnitOfMeasure, &a.Active); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Article{}, ErrNotFound
+		}
+		return Article{}, err
+	}
+
+	return a, nil
+}
+
+// QueryLots returns the first 50 lots whose code or description contains
+// search, limited to the lots of cdAr when it is not empty.
+func (s Store) QueryLots(ctx context.Context, cdAr, search string) ([]Lot, error) {
+	rows, err := s.db.QueryContext(ctx, `select top(50) l.Cd_ARLotto, l.Cd_AR, isnull(l.Descrizione, ''), isnull(a.Descrizione, '') 
+	from ARLotto l join AR a on a.Cd_AR = l.Cd_AR 
+	where (@p1 = '' or l.Cd_AR = @p1) and (l.Cd_ARLotto like @p2 or l.Descrizione like @p2) order by l.TimeIns desc`, cdAr, "%"+search+"%")
+	if err != nil {
+		return make([]Lot, 0), err
+	}
+	defer rows.Close()
+
+	lots := make([]Lot, 0)
+	for rows.Next() {
+		var l Lot
+		if err := rows.Scan(&l.CdLotto, &l.CdAr, &l.Description, &l.ArticleDescription); err != nil {
+			return make([]Lot, 0), err
+		}
+		lots = append(lots, l)
+	}
+
+	return lots, nil
+}
+
+// QueryShelfLife returns the shelf life in days configured on the article, or
+// zero when it has none.
+func (s Store) QueryShelfLife(ctx context.Context, tx *sql.Tx, cdAr string) (int, error) {
+	row := tx.QueryRowContext(ctx, `select isnull(xGiorniScadenza, 0) from AR where Cd_AR = @p1`, cdAr)
+
+	var days int
+	if err := row.Scan(&days); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	return days, nil
+}
+
+// CreateLot registers the lot in ARLotto with its production and expiry
+// dates and a description built from the configuration.
+func (s Store) CreateLot(ctx context.Context, tx *sql.Tx, cfg LotConfig, nl NewLot, now time.Time) error {
+	nl.ProductionDate, now = nl.ProductionDate.Local(), now.Local()
+	expiry, err := expiryDate(ctx, tx, s, cfg, nl.CdAr, nl.ProductionDate)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `insert into ARLotto (Cd_ARLotto, Cd_AR, Descrizione, DataProduzione, DataScadenza, UserIns, UserUpd, TimeIns, TimeUpd) 
+	values(@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8,@p9)`, nl.CdLotto, nl.CdAr, lotDescription(cfg, nl.ProductionDate), nl.ProductionDate, expiry, user, user, now, now)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateLotDates sets the real production date of the lot and recomputes
+// its expiry date and description. Only the lots created by the service,
+// recognized by UserIns, are touched: a lot registered in Arca beforehand
+// keeps the dates and description given to it there.
+func (s Store) UpdateLotDates(ctx context.Context, tx *sql.Tx, cfg LotConfig, nl NewLot, now time.Time) error {
+	nl.ProductionDate, now = nl.ProductionDate.Local(), now.Local()
+	expiry, err := expiryDate(ctx, tx, s, cfg, nl.CdAr, nl.ProductionDate)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update ARLotto set Descrizione = @p1, DataProduzione = @p2, DataScadenza = @p3, UserUpd = @p4, TimeUpd = @p5 
+	where Cd_ARLotto = @p6 and Cd_AR = @p7 and UserIns = @p4`, lotDescription(cfg, nl.ProductionDate), nl.ProductionDate, expiry, user, now, nl.CdLotto, nl.CdAr)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// expiryDate adds to the production date the shelf life of the article, or
+// the one of the configuration when the article has none.
+func expiryDate(ctx context.Context, tx *sql.Tx, store Storer, cfg LotConfig, cdAr string, production time.Time) (*time.Time, error) {
+	days, err := store.QueryShelfLife(ctx, tx, cdAr)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	if days <= 0 {
+		days = cfg.ShelfLifeDays
+	}
+
+	if days <= 0 {
+		return nil, nil
+	}
+
+	expiry := production.AddDate(0, 0, days)
+	return &expiry, nil
+}
+
+func lotDescription(cfg LotConfig, production time.Time) string {
+	return fmt.Sprintf("%s %s", cfg.Label, production.Format("02/01/2006"))
+}
+
+// orderUnit is the unit of measure of an order line, the default one of its
+// article when the line has none.
+const orderUnit = `coalesce(r.Cd_ARMisura, (select max(m.Cd_ARMisura) from ARARMisura m where m.Cd_AR = r.Cd_AR and m.DefaultMisura = 1), '')`
+
+const orderColumns = `r.Id_DoRig, t.Id_DoTes, t.Cd_DO, t.NumeroDoc, t.DataDoc, r.Cd_AR, isnull(a.Descrizione, ''), r.Qta, ` + orderUnit + `, isnull(r.xQtaProdotta, 0), r.DataConsegna`
+
+const orderFrom = ` from DoRig r join DoTes t on t.Id_DoTes = r.Id_DoTes left join AR a on a.Cd_AR = r.Cd_AR`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOrder(row scanner) (Order, error) {
+	var o Order
+	if err := row.Scan(&o.ID, &o.DocumentID, &o.DocumentType, &o.Number, &o.Date, &o.CdAr, &o.ArticleDescription, &o.Quantity, &o.UnitOfMeasure, &o.ProducedQuantity, &o.DueDate); err != nil {
+		return Order{}, err
+	}
+	return o, nil
+}
+
+// QueryOpenOrders returns the first 50 order lines of the document type in
+// the unit of measure with quantity still to produce, the most urgent first.
+func (s Store) QueryOpenOrders(ctx context.Context, documentType, unit string) ([]Order, error) {
+	rows, err := s.db.QueryContext(ctx, `select top(50) `+orderColumns+orderFrom+` 
+	where t.Cd_DO = @p1 and `+orderUnit+` = @p2 and r.Cd_AR is not null and r.Evasa = 0 and isnull(r.xQtaProdotta, 0) < r.Qta 
+	order by case when r.DataConsegna is null then 1 else 0 end, r.DataConsegna, t.DataDoc, r.Riga`, documentType, unit)
+	if err != nil {
+		return make([]Order, 0), err
+	}
+	defer rows.Close()
+
+	orders := make([]Order, 0)
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return make([]Order, 0), err
+		}
+		orders = append(orders, o)
+	}
+
+	return orders, nil
+}
+
+func (s Store) QueryOrderByID(ctx context.Context, id int) (Order, error) {
+	row := s.db.QueryRowContext(ctx, `select top(1) `+orderColumns+orderFrom+` where r.Id_DoRig = @p1`, id)
+	if err := row.Err(); err != nil {
+		return Order{}, err
+	}
+
+	o, err := scanOrder(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Order{}, ErrNotFound
+		}
+		return Order{}, err
+	}
+
+	return o, nil
+}
+
+// AddOrderProgress adds quantity, negative to subtract it, to the quantity
+// produced for the order line. quantity is in unit: a line in another unit
+// of measure, e.g. changed in Arca after the work was inserted, is left as
+// it is.
+func (s Store) AddOrderProgress(ctx context.Context, tx *sql.Tx, id int, quantity float64, unit string, now time.Time) error {
+	now = now.Local()
+	res, err := tx.ExecContext(ctx, `update r set xQtaProdotta = isnull(r.xQtaProdotta, 0) + @p1, UserUpd = @p2, TimeUpd = @p3 
+	from DoRig r where r.Id_DoRig = @p4 and `+orderUnit+` = @p5`, quantity, user, now, id, unit)
+	if err != nil {
+		return err
+	}
+
+	return skippedProgress(res, s.log, id, unit)
+}
+
+// skippedProgress logs the order progress not recorded because the line is
+// not in the unit of measure of the quantity.
+func skippedProgress(res sql.Result, log *log.Logger, id int, unit string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		log.Printf("arca: order line %d is not in %s, its progress is not recorded", id, unit)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+// Package events is the catalog of the events pushed to the websocket
+// clients. Every event is an Envelope whose data is the payload of its type,
+// and the version of a type changes whenever its payload does, so that a
+// client can tell the shapes it understands.
+package events
+
+import (
+	"time"
+)
+
+// Types of the events.
+const (
+	TypeWorkCreated       = "work.created"
+	TypeWorkUpdated       = "work.updated"
+	TypeWorkPurged        = "work.purged"
+	TypeQuantityChanged   = "work.quantity_changed"
+	TypeStatusChanged     = "work.status_changed"
+	TypeConnectionChanged = "connection.changed"
+	TypeAlarm             = "alarm"
+)
+
+// Changes reported by work.updated.
+const (
+	ChangeDocumentCreated = "document_created"
+	ChangeDocumentRemoved = "document_removed"
+	ChangeCorrected       = "corrected"
+	ChangeArchived        = "archived"
+	ChangeRestored        = "restored"
+	ChangePaused          = "paused"
+	ChangeResumed         = "resumed"
+	ChangeRecovered       = "recovered"
+)
+
+// Sources of a quantity change.
+const (
+	SourcePLC        = "plc"
+	SourceCorrection = "correction"
+	SourceReconcile  = "reconcile"
+)
+
+// Codes and severities of the alarms.
+const (
+	AlarmConnectionLost        = "connection_lost"
+	AlarmNotificationBacklog   = "notification_backlog"
+	AlarmNotificationDiscarded = "notification_discarded"
+
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// Payload is the data of an event.
+type Payload interface {
+	EventType() string
+	EventVersion() int
+}
+
+// Envelope is the message sent for every event, under its type.
+type Envelope struct {
+	Epoch   int64     `json:"epoch" doc:"start of the numbering of seq, in unix milliseconds; it changes when the backend restarts"`
+	Seq     uint64    `json:"seq" doc:"sequence of the event within the epoch, increasing by one across all machines"`
+	Type    string    `json:"type" doc:"type of the event, also the name it is sent under"`
+	Version int       `json:"version" doc:"version of the payload of the type"`
+	Machine string    `json:"machine" doc:"spindryer or pasteurizer"`
+	Time    time.Time `json:"time" doc:"when the event was emitted"`
+	Data    Payload   `json:"data" doc:"payload of the type"`
+}
+
+// WorkCreated is emitted when a work is inserted.
+type WorkCreated struct {
+	WorkID  int         `json:"work_id" doc:"id of the work"`
+	CdLotto string      `json:"cd_lotto" doc:"lot produced by the work"`
+	Work    interface{} `json:"work" doc:"the work, as returned by GET /v1/{machine}/work"`
+}
+
+func (WorkCreated) EventType() string { return TypeWorkCreated }
+func (WorkCreated) EventVersion() int { return 1 }
+
+// WorkUpdated is emitted when a work changes other than by a quantity or
+// status change of the machine. A recovered change follows the transitions
+// and quantities the service caught up with after missing them, e.g. while
+// it was restarted.
+type WorkUpdated struct {
+	WorkID  int         `json:"work_id" doc:"id of the work"`
+	CdLotto string      `json:"cd_lotto" doc:"lot produced by the work"`
+	Change  string      `json:"change" doc:"document_created, document_removed, corrected, archived, restored, paused, resumed or recovered"`
+	Work    interface{} `json:"work" doc:"the work, as returned by GET /v1/{machine}/work"`
+}
+
+func (WorkUpdated) EventType() string { return TypeWorkUpdated }
+func (WorkUpdated) EventVersion() int { return 1 }
+
+// WorkPurged is emitted when archived works are removed for good.
+type WorkPurged struct {
+	Count  int       `json:"count" doc:"number of works removed"`
+	Before time.Time `json:"before" doc:"the works archived before this time were removed"`
+}
+
+func (WorkPurged) EventType() string { return TypeWorkPurged }
+func (WorkPurged) EventVersion() int { return 1 }
+
+// QuantityChanged is emitted when a quantity of a work changes, read from
+// the PLC or corrected by hand.
+type QuantityChanged struct {
+	WorkID   int     `json:"work_id" doc:"id of the work"`
+	CdLotto  string  `json:"cd_lotto" doc:"lot produced by the work"`
+	Quantity string  `json:"quantity" doc:"name of the quantity: cycles, basil_amount or packages"`
+	Previous float64 `json:"previous" doc:"value before the change"`
+	Value    float64 `json:"value" doc:"value after the change"`
+	Source   string  `json:"source" doc:"plc, correction or reconcile, when caught up after a restart"`
+}
+
+func (QuantityChanged) EventType() string { return TypeQuantityChanged }
+func (QuantityChanged) EventVersion() int { return 1 }
+
+// StatusChanged is emitted when a work moves along its lifecycle.
+type StatusChanged struct {
+	WorkID   int         `json:"work_id" doc:"id of the work"`
+	CdLotto  string      `json:"cd_lotto" doc:"lot produced by the work"`
+	Previous string      `json:"previous" doc:"status before the change"`
+	Status   string      `json:"status" doc:"status after the change: sent, work or done"`
+	Work     interface{} `json:"work" doc:"the work, as returned by GET /v1/{machine}/work"`
+}
+
+func (StatusChanged) EventType() string { return TypeStatusChanged }
+func (StatusChanged) EventVersion() int { return 1 }
+
+// ConnectionChanged is emitted when the connection to the OPC UA server of
+// the machine is opened or lost.
+type ConnectionChanged struct {
+	Connected bool   `json:"connected" doc:"whether the machine is connected"`
+	Endpoint  string `json:"endpoint" doc:"OPC UA endpoint of the machine"`
+}
+
+func (ConnectionChanged) EventType() string { return TypeConnectionChanged }
+func (ConnectionChanged) EventVersion() int { return 1 }
+
+// Alarm is emitted when a condition needing attention is raised and again
+// when it clears. A notification_discarded alarm is raised once for each
+// notification moved to the dead-letter file and does not clear.
+type Alarm struct {
+	Code     string `json:"code" doc:"connection_lost, notification_backlog or notification_discarded"`
+	Severity string `json:"severity" doc:"warning or error"`
+	Active   bool   `json:"active" doc:"true when raised, false when cleared"`
+	Message  string `json:"message" doc:"description for the operator"`
+	WorkID   *int   `json:"work_id" doc:"id of the work affected, if any"`
+}
+
+func (Alarm) EventType() string { return TypeAlarm }
+func (Alarm) EventVersion() int { return 1 }
@@ -0,0 +1,59 @@
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Publisher emits the events of a machine to the websocket clients, through
+// the stream shared by the machines. The events are emitted once the change
+// is stored and a failure is only logged: the stored data stays the
+// reference, GET /work included.
+type Publisher struct {
+	stream  *Stream
+	machine string
+	log     *log.Logger
+}
+
+func NewPublisher(stream *Stream, machine string, log *log.Logger) Publisher {
+	return Publisher{stream: stream, machine: machine, log: log}
+}
+
+func (p Publisher) Publish(data Payload) {
+	if p.stream == nil {
+		return
+	}
+
+	err := p.stream.emit(Envelope{
+		Type:    data.EventType(),
+		Version: data.EventVersion(),
+		Machine: p.machine,
+		Time:    time.Now(),
+		Data:    data,
+	})
+	if err != nil {
+		p.log.Printf("events: %s: %s", data.EventType(), err)
+	}
+}
+
+// Legacy broadcasts data, as JSON, to every socket under the name the
+// frontend used before the catalog, such as spindryer-status-change. These
+// events are neither numbered nor kept for a replay, are missed by a socket
+// falling behind, and go away once the frontend has moved to the catalog.
+func (p Publisher) Legacy(event string, data interface{}) {
+	if p.stream == nil {
+		return
+	}
+
+	var b []byte
+	if data != nil {
+		var err error
+		if b, err = json.Marshal(data); err != nil {
+			p.log.Printf("events: %s: %s", event, err)
+			return
+		}
+	}
+
+	p.stream.broadcast(event, b)
+}
@@ -0,0 +1,116 @@
+package events
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// catalog lists the payload of every type with its description.
+var catalog = []struct {
+	payload     Payload
+	description string
+}{
+	{WorkCreated{}, "A work was inserted."},
+	{WorkUpdated{}, "A work changed other than by a quantity or status change of the machine."},
+	{WorkPurged{}, "Archived works were removed for good."},
+	{QuantityChanged{}, "A quantity of a work changed, read from the PLC or corrected by hand."},
+	{StatusChanged{}, "A work moved along its lifecycle."},
+	{ConnectionChanged{}, "The connection to the OPC UA server of the machine was opened or lost."},
+	{Alarm{}, "A condition needing attention was raised or cleared."},
+}
+
+// Field describes a field of a payload.
+type Field struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Nullable    bool   `json:"nullable"`
+	Description string `json:"description"`
+}
+
+// Definition describes a type of event.
+type Definition struct {
+	Type        string  `json:"type"`
+	Version     int     `json:"version"`
+	Description string  `json:"description"`
+	Fields      []Field `json:"fields"`
+}
+
+// Schema describes the subscribe message and its answers, the envelope and
+// the payload of every type.
+type Schema struct {
+	Subscribe  []Field      `json:"subscribe"`
+	Subscribed []Field      `json:"subscribed"`
+	Snapshot   []Field      `json:"snapshot"`
+	Envelope   []Field      `json:"envelope"`
+	Events     []Definition `json:"events"`
+}
+
+// Describe returns the schema of the catalog.
+func Describe() Schema {
+	schema := Schema{
+		Subscribe:  fields(reflect.TypeOf(Subscription{})),
+		Subscribed: fields(reflect.TypeOf(Subscribed{})),
+		Snapshot:   fields(reflect.TypeOf(Snapshot{})),
+		Envelope:   fields(reflect.TypeOf(Envelope{})),
+		Events:     make([]Definition, 0, len(catalog)),
+	}
+
+	for _, c := range catalog {
+		schema.Events = append(schema.Events, Definition{
+			Type:        c.payload.EventType(),
+			Version:     c.payload.EventVersion(),
+			Description: c.description,
+			Fields:      fields(reflect.TypeOf(c.payload)),
+		})
+	}
+
+	return schema
+}
+
+func fields(t reflect.Type) []Field {
+	fs := make([]Field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		typ, nullable := typeName(f.Type)
+		fs = append(fs, Field{
+			Name:        name,
+			Type:        typ,
+			Nullable:    nullable,
+			Description: f.Tag.Get("doc"),
+		})
+	}
+	return fs
+}
+
+// typeName returns the JSON type of t and whether it may be null.
+func typeName(t reflect.Type) (string, bool) {
+	if t.Kind() == reflect.Ptr {
+		name, _ := typeName(t.Elem())
+		return name, true
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return "time", false
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "string", false
+	case reflect.Bool:
+		return "boolean", false
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer", false
+	case reflect.Float32, reflect.Float64:
+		return "number", false
+	case reflect.Slice, reflect.Array:
+		return "array", true
+	}
+	return "object", false
+}
@@ -0,0 +1,37 @@
+package events
+
+// Subscribed answers a subscribe message.
+type Subscribed struct {
+	Machines []string `json:"machines" doc:"machines subscribed so far on the socket"`
+	Lots     []string `json:"lots" doc:"lots subscribed so far on the socket"`
+	Epoch    int64    `json:"epoch" doc:"epoch of the events"`
+	Seq      uint64   `json:"seq" doc:"seq of the last event sent before this message or covered by the snapshot"`
+	Replayed int      `json:"replayed" doc:"number of missed events sent again before this message"`
+	Snapshot bool     `json:"snapshot" doc:"whether the missed events are no longer kept and a snapshot follows"`
+}
+
+// Snapshot is the state of the subscribed machines and lots, sent in place
+// of the missed events when they are no longer kept. It covers the events
+// up to seq: a client drops the events with a lower or equal seq and
+// applies, after the snapshot, the later ones even if received before it.
+type Snapshot struct {
+	Epoch    int64             `json:"epoch" doc:"epoch of the events"`
+	Seq      uint64            `json:"seq" doc:"seq of the last event covered"`
+	Machines []MachineSnapshot `json:"machines" doc:"state of the subscribed machines"`
+	Lots     []LotSnapshot     `json:"lots" doc:"works of the subscribed lots, when found"`
+}
+
+// MachineSnapshot is the state of a machine.
+type MachineSnapshot struct {
+	Machine       string      `json:"machine"`
+	Connected     bool        `json:"connected"`
+	Works         interface{} `json:"works"`
+	NextPageToken string      `json:"next_page_token"`
+}
+
+// LotSnapshot is the work producing a lot.
+type LotSnapshot struct {
+	CdLotto string      `json:"cd_lotto"`
+	Machine string      `json:"machine"`
+	Work    interface{} `json:"work"`
+}
@@ -0,0 +1,504 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devsamuele/service-kit/ws"
+)
+
+// listenerBuffer is the number of events a listener may fall behind before
+// it is closed.
+const listenerBuffer = 256
+
+// socketBuffer is the number of messages a websocket client may fall behind
+// before it is dropped.
+const socketBuffer = 256
+
+// SubscribeError is the message answering a subscription that failed, also
+// sent to a client dropped for falling behind.
+const SubscribeError = "subscribe_error"
+
+// ErrStreamClosed is returned by Listen once the stream is closed.
+var ErrStreamClosed = errors.New("stream closed")
+
+// Stream numbers the events of every machine and keeps the last of them, so
+// that a client reconnecting after a gap gets the events it missed. The
+// numbering restarts with the backend, under a new epoch. The events go to
+// the websocket clients joined, see Join, and to the listeners, see Listen.
+type Stream struct {
+	epoch int64
+
+	mu        sync.Mutex
+	seq       uint64
+	records   []record
+	next      int
+	sockets   map[*ws.Socket]*subscriber
+	listeners map[*Listener]struct{}
+	closed    bool
+}
+
+// subscriber is a websocket client registered to the stream. Its messages
+// are queued under the lock and sent by its own goroutine, see deliver, so
+// that a slow or gone client never holds the stream. A client dropped for
+// falling behind is replaced by a new subscriber when it subscribes again,
+// whose goroutine starts once the one of the dropped subscriber returned.
+type subscriber struct {
+	sub     Subscription
+	queue   chan outgoing
+	dropped bool
+
+	// stop is closed when the subscriber is dropped or the socket leaves,
+	// gone when the socket leaves; gone is shared by the subscribers of a
+	// socket. done is closed once deliver returned.
+	stop chan struct{}
+	gone chan struct{}
+	done chan struct{}
+}
+
+type outgoing struct {
+	event string
+	msg   json.RawMessage
+}
+
+type record struct {
+	seq     uint64
+	machine string
+	lot     string
+	event   string
+	msg     json.RawMessage
+}
+
+// NewStream returns a stream keeping the last size events.
+func NewStream(size int) *Stream {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Stream{
+		epoch:     time.Now().UnixMilli(),
+		records:   make([]record, 0, size),
+		sockets:   make(map[*ws.Socket]*subscriber),
+		listeners: make(map[*Listener]struct{}),
+	}
+}
+
+// Position returns the epoch of the stream and the sequence of its last
+// event.
+func (s *Stream) Position() (int64, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.epoch, s.seq
+}
+
+// emit numbers env, keeps it and queues it once for every socket subscribed
+// to its machine or its lot, and for the listeners. Events are queued under
+// the lock, so the clients get them in sequence, and sent outside of it.
+func (s *Stream) emit(env Envelope) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	env.Epoch = s.epoch
+	env.Seq = s.seq + 1
+
+	b, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	s.seq = env.Seq
+
+	r := record{
+		seq:     env.Seq,
+		machine: env.Machine,
+		lot:     lotOf(env.Data),
+		event:   env.Type,
+		msg:     b,
+	}
+	if len(s.records) < cap(s.records) {
+		s.records = append(s.records, r)
+	} else {
+		s.records[s.next] = r
+		s.next = (s.next + 1) % len(s.records)
+	}
+
+	for l := range s.listeners {
+		if !l.sub.matches(r.machine, r.lot) {
+			continue
+		}
+		select {
+		case l.ch <- s.message(r):
+		default:
+			s.drop(l)
+		}
+	}
+
+	for _, sb := range s.sockets {
+		if !sb.dropped && sb.sub.matches(r.machine, r.lot) {
+			s.enqueue(sb, outgoing{event: r.event, msg: r.msg})
+		}
+	}
+
+	return nil
+}
+
+// broadcast queues msg under event for every socket registered, whatever its
+// subscriptions, outside of the numbering of the stream. A socket whose
+// queue is full misses msg but is not dropped for it.
+func (s *Stream) broadcast(event string, msg json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sb := range s.sockets {
+		if !sb.dropped {
+			sb.offer(outgoing{event: event, msg: msg})
+		}
+	}
+}
+
+// Register registers socket, once connected, to get the messages broadcast
+// to every client. It gets the events of the stream once joined, see Join.
+func (s *Stream) Register(socket *ws.Socket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subscriber(socket)
+}
+
+// Join adds sub to the subscriptions of socket. When sub resumes from a
+// position of this stream still kept, the events sent since, to the machines
+// and lots of sub, are queued for socket before any later one and their
+// number is returned. Otherwise resumed is false and the client needs a
+// snapshot; the returned sequence is the last event the snapshot must cover.
+// The missed events are not queued either when they would not fit in the
+// queue of socket.
+func (s *Stream) Join(socket *ws.Socket, sub Subscription) (replayed int, resumed bool, seq uint64, err error) {
+	if err := sub.Validate(); err != nil {
+		return 0, false, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sb := s.subscriber(socket)
+	sb.sub = sb.sub.add(sub)
+
+	missed, resumed := s.resume(sub)
+	if len(missed) > cap(sb.queue)-len(sb.queue) {
+		return 0, false, s.seq, nil
+	}
+
+	for _, r := range missed {
+		s.enqueue(sb, outgoing{event: r.event, msg: r.msg})
+	}
+
+	return len(missed), resumed, s.seq, nil
+}
+
+// Send queues a message for socket, after the events queued so far.
+func (s *Stream) Send(socket *ws.Socket, event string, msg json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.enqueue(s.subscriber(socket), outgoing{event: event, msg: msg})
+}
+
+// Leave unregisters socket, once disconnected, and stops its goroutine.
+func (s *Stream) Leave(socket *ws.Socket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sb, ok := s.sockets[socket]
+	if !ok {
+		return
+	}
+	delete(s.sockets, socket)
+
+	if !sb.dropped {
+		close(sb.stop)
+	}
+	close(sb.gone)
+}
+
+// subscriber returns the subscriber of socket, registering it the first
+// time and replacing it once dropped.
+func (s *Stream) subscriber(socket *ws.Socket) *subscriber {
+	prev, ok := s.sockets[socket]
+	if ok && !prev.dropped {
+		return prev
+	}
+
+	sb := &subscriber{
+		queue: make(chan outgoing, socketBuffer),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	var after chan struct{}
+	if ok {
+		sb.gone = prev.gone
+		after = prev.done
+	} else {
+		sb.gone = make(chan struct{})
+	}
+
+	s.sockets[socket] = sb
+	go sb.deliver(socket, after)
+	return sb
+}
+
+// enqueue queues m, dropping the subscriber when its queue is full: its
+// client is told so and gets nothing more until it subscribes again.
+func (s *Stream) enqueue(sb *subscriber, m outgoing) {
+	if !sb.offer(m) {
+		sb.dropped = true
+		close(sb.stop)
+	}
+}
+
+// offer queues m unless the queue is full.
+func (sb *subscriber) offer(m outgoing) bool {
+	select {
+	case sb.queue <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliver sends the messages queued, once the goroutine of the subscriber
+// replaced, if any, returned, and until the subscriber is stopped. The
+// client of a subscriber dropped is then told to subscribe again.
+func (sb *subscriber) deliver(socket *ws.Socket, after chan struct{}) {
+	defer close(sb.done)
+
+	if after != nil {
+		select {
+		case <-after:
+		case <-sb.gone:
+			return
+		}
+	}
+
+	for {
+		// A stopped subscriber sends nothing more, even with messages
+		// still queued.
+		select {
+		case <-sb.stop:
+			sb.finish(socket)
+			return
+		default:
+		}
+
+		select {
+		case <-sb.stop:
+			sb.finish(socket)
+			return
+		case m := <-sb.queue:
+			if !emit(socket, m, sb.gone) {
+				return
+			}
+		}
+	}
+}
+
+// finish tells the client of a dropped subscriber that it lost events.
+func (sb *subscriber) finish(socket *ws.Socket) {
+	if sb.dropped {
+		emit(socket, outgoing{event: SubscribeError, msg: json.RawMessage(`{"error":"events lost, subscribe again from the last event received"}`)}, sb.gone)
+	}
+}
+
+// emit sends m to socket and reports whether it was sent before the socket
+// left. An emit to a socket whose connection is closed never returns, so it
+// runs on its own: once the socket leaves the message is given up, with the
+// goroutine of its emit, but the subscriber is not held.
+func emit(socket *ws.Socket, m outgoing, gone chan struct{}) bool {
+	sent := make(chan struct{})
+	go func() {
+		_ = socket.Emit(m.event, m.msg)
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+		return true
+	case <-gone:
+		return false
+	}
+}
+
+// Message is an event as received by a listener.
+type Message struct {
+	ID    string
+	Event string
+	Data  json.RawMessage
+}
+
+// Listener receives on C the events of the machines and lots of its
+// subscription. C is closed when the listener falls behind by more than
+// listenerBuffer events or the stream is closed.
+type Listener struct {
+	C <-chan Message
+
+	// Missed are the events sent since the position sub resumed from, to
+	// be delivered before C. When they are no longer kept Resumed is false
+	// and the client needs a snapshot covering the events up to Seq.
+	Missed  []Message
+	Resumed bool
+	Epoch   int64
+	Seq     uint64
+
+	sub    Subscription
+	ch     chan Message
+	stream *Stream
+}
+
+// Listen registers a listener of the events of sub. It must be closed once
+// done with.
+func (s *Stream) Listen(sub Subscription) (*Listener, error) {
+	if err := sub.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrStreamClosed
+	}
+
+	ch := make(chan Message, listenerBuffer)
+	l := Listener{
+		C:      ch,
+		Epoch:  s.epoch,
+		Seq:    s.seq,
+		sub:    sub,
+		ch:     ch,
+		stream: s,
+	}
+
+	missed, resumed := s.resume(sub)
+	l.Resumed = resumed
+	l.Missed = make([]Message, 0, len(missed))
+	for _, r := range missed {
+		l.Missed = append(l.Missed, s.message(r))
+	}
+
+	s.listeners[&l] = struct{}{}
+	return &l, nil
+}
+
+// Close unregisters the listener.
+func (l *Listener) Close() {
+	l.stream.mu.Lock()
+	defer l.stream.mu.Unlock()
+
+	l.stream.drop(l)
+}
+
+// Close closes every listener and refuses new ones. The websocket clients
+// are not affected.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for l := range s.listeners {
+		s.drop(l)
+	}
+}
+
+// drop closes and unregisters l, if still registered.
+func (s *Stream) drop(l *Listener) {
+	if _, ok := s.listeners[l]; !ok {
+		return
+	}
+	delete(s.listeners, l)
+	close(l.ch)
+}
+
+func (s *Stream) message(r record) Message {
+	return Message{ID: FormatID(s.epoch, r.seq), Event: r.event, Data: r.msg}
+}
+
+// FormatID returns the id of the event seq of epoch, as used by the
+// Server-Sent Events.
+func FormatID(epoch int64, seq uint64) string {
+	return fmt.Sprintf("%d:%d", epoch, seq)
+}
+
+// ParseID parses an id returned by FormatID.
+func ParseID(id string) (int64, uint64, error) {
+	parts := strings.Split(id, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid event id %q", id)
+	}
+
+	epoch, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid event id %q", id)
+	}
+
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid event id %q", id)
+	}
+
+	return epoch, seq, nil
+}
+
+// resume returns the events of sub sent since the position it resumes from,
+// or false if some of them are no longer kept.
+func (s *Stream) resume(sub Subscription) ([]record, bool) {
+	if sub.LastSeq == nil {
+		return nil, true
+	}
+
+	all, ok := s.since(sub.Epoch, *sub.LastSeq)
+	if !ok {
+		return nil, false
+	}
+
+	missed := make([]record, 0, len(all))
+	for _, r := range all {
+		if sub.matches(r.machine, r.lot) {
+			missed = append(missed, r)
+		}
+	}
+	return missed, true
+}
+
+// since returns the events after seq, in order, and false if some of them
+// are no longer kept or seq is not of this stream.
+func (s *Stream) since(epoch int64, seq uint64) ([]record, bool) {
+	if epoch != s.epoch || seq > s.seq {
+		return nil, false
+	}
+	if seq == s.seq {
+		return nil, true
+	}
+
+	// The oldest event kept is at next once the buffer is full.
+	oldest := s.records[0].seq
+	if len(s.records) == cap(s.records) {
+		oldest = s.records[s.next].seq
+	}
+	if seq+1 < oldest {
+		return nil, false
+	}
+
+	missed := make([]record, 0, s.seq-seq)
+	for i := 0; i < len(s.records); i++ {
+		r := s.records[(s.next+i)%len(s.records)]
+		if r.seq > seq {
+			missed = append(missed, r)
+		}
+	}
+	return missed, true
+}
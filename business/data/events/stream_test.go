@@ -0,0 +1,193 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/devsamuele/service-kit/ws"
+)
+
+func TestStreamSince(t *testing.T) {
+	s := NewStream(3)
+	for i := 0; i < 5; i++ {
+		if err := s.emit(Envelope{Type: TypeWorkCreated, Machine: "spindryer", Data: WorkCreated{WorkID: i}}); err != nil {
+			t.Fatalf("emit: %v", err)
+		}
+	}
+
+	// Events 3, 4 and 5 are kept.
+	tests := []struct {
+		epoch int64
+		seq   uint64
+		want  []uint64
+		ok    bool
+	}{
+		{s.epoch, 5, nil, true},
+		{s.epoch, 4, []uint64{5}, true},
+		{s.epoch, 2, []uint64{3, 4, 5}, true},
+		{s.epoch, 1, nil, false},
+		{s.epoch, 6, nil, false},
+		{s.epoch - 1, 4, nil, false},
+	}
+
+	for _, tt := range tests {
+		missed, ok := s.since(tt.epoch, tt.seq)
+		if ok != tt.ok {
+			t.Errorf("since(%d, %d): ok = %v, want %v", tt.epoch, tt.seq, ok, tt.ok)
+			continue
+		}
+
+		got := make([]uint64, 0, len(missed))
+		for _, r := range missed {
+			got = append(got, r.seq)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("since(%d, %d) = %v, want %v", tt.epoch, tt.seq, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("since(%d, %d) = %v, want %v", tt.epoch, tt.seq, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestStreamSinceNotFull(t *testing.T) {
+	s := NewStream(10)
+	for i := 0; i < 3; i++ {
+		if err := s.emit(Envelope{Type: TypeWorkCreated, Machine: "pasteurizer", Data: WorkCreated{WorkID: i}}); err != nil {
+			t.Fatalf("emit: %v", err)
+		}
+	}
+
+	missed, ok := s.since(s.epoch, 0)
+	if !ok || len(missed) != 3 {
+		t.Fatalf("since(0) = %d events, %v, want 3, true", len(missed), ok)
+	}
+	for i, r := range missed {
+		if r.seq != uint64(i+1) {
+			t.Errorf("event %d has seq %d, want %d", i, r.seq, i+1)
+		}
+	}
+}
+
+func TestStreamDropsSlowSocket(t *testing.T) {
+	s := NewStream(10)
+
+	// The socket never takes a message: its queue fills up.
+	socket := &ws.Socket{}
+	if _, _, _, err := s.Join(socket, Subscription{Machines: []string{"spindryer"}}); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < socketBuffer+2; i++ {
+			if err := s.emit(Envelope{Type: TypeWorkCreated, Machine: "spindryer", Data: WorkCreated{WorkID: i}}); err != nil {
+				t.Errorf("emit: %v", err)
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("emit blocked on a slow socket")
+	}
+
+	s.mu.Lock()
+	dropped := s.sockets[socket].dropped
+	s.mu.Unlock()
+	if !dropped {
+		t.Error("slow socket not dropped")
+	}
+}
+
+func TestStreamDroppedSocketExits(t *testing.T) {
+	s := NewStream(10)
+
+	// The socket never takes a message, as one whose connection is closed.
+	socket := &ws.Socket{}
+	sub := Subscription{Machines: []string{"spindryer"}}
+	if _, _, _, err := s.Join(socket, sub); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	for i := 0; i < socketBuffer+2; i++ {
+		if err := s.emit(Envelope{Type: TypeWorkCreated, Machine: "spindryer", Data: WorkCreated{WorkID: i}}); err != nil {
+			t.Fatalf("emit: %v", err)
+		}
+	}
+
+	s.mu.Lock()
+	dropped := s.sockets[socket]
+	s.mu.Unlock()
+
+	// Subscribing again replaces the subscriber dropped, whose goroutine
+	// still runs: the new one waits for it.
+	if _, _, _, err := s.Join(socket, sub); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+
+	s.mu.Lock()
+	joined := s.sockets[socket]
+	s.mu.Unlock()
+	if joined == dropped || joined.dropped {
+		t.Fatal("socket not joined again")
+	}
+
+	s.Leave(socket)
+
+	for _, sb := range []*subscriber{dropped, joined} {
+		select {
+		case <-sb.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("goroutine of the socket still running after Leave")
+		}
+	}
+}
+
+func TestStreamBroadcastKeepsSlowSocket(t *testing.T) {
+	s := NewStream(10)
+
+	socket := &ws.Socket{}
+	s.Register(socket)
+	for i := 0; i < socketBuffer+2; i++ {
+		s.broadcast("spindryer-status-change", nil)
+	}
+
+	s.mu.Lock()
+	sb := s.sockets[socket]
+	s.mu.Unlock()
+	if sb == nil || sb.dropped {
+		t.Error("socket dropped by a broadcast")
+	}
+
+	s.Leave(socket)
+	select {
+	case <-sb.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("goroutine of the socket still running after Leave")
+	}
+}
+
+func TestStreamJoinReplayTooLong(t *testing.T) {
+	s := NewStream(socketBuffer + 10)
+	for i := 0; i < socketBuffer+5; i++ {
+		if err := s.emit(Envelope{Type: TypeWorkCreated, Machine: "spindryer", Data: WorkCreated{WorkID: i}}); err != nil {
+			t.Fatalf("emit: %v", err)
+		}
+	}
+
+	last := uint64(0)
+	replayed, resumed, seq, err := s.Join(&ws.Socket{}, Subscription{Machines: []string{"spindryer"}, Epoch: s.epoch, LastSeq: &last})
+	if err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	if replayed != 0 || resumed || seq != socketBuffer+5 {
+		t.Errorf("Join = %d, %v, %d, want 0, false, %d", replayed, resumed, seq, socketBuffer+5)
+	}
+}
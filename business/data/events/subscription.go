@@ -0,0 +1,100 @@
+package events
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/lotcode"
+)
+
+// Machines lists the machines whose events can be subscribed.
+var Machines = []string{lotcode.MachineSpindryer, lotcode.MachinePasteurizer}
+
+// Subscription is sent by a client, as the subscribe message, to receive the
+// events of whole machines or of single lots. Subscriptions add up over the
+// life of the socket, and an event is sent once to a socket whatever the
+// number of its subscriptions it matches.
+//
+// A client reconnecting sends the epoch and the seq of the last event it
+// got, to be sent the events it missed.
+type Subscription struct {
+	Machines []string `json:"machines" doc:"machines whose events are sent: spindryer or pasteurizer"`
+	Lots     []string `json:"lots" doc:"lots whose work events are sent"`
+	Epoch    int64    `json:"epoch" doc:"epoch of the last event received, when reconnecting"`
+	LastSeq  *uint64  `json:"last_seq" doc:"seq of the last event received, when reconnecting"`
+}
+
+// Validate checks the machines and the lots of the subscription.
+func (s Subscription) Validate() error {
+	for _, m := range s.Machines {
+		if !knownMachine(m) {
+			return fmt.Errorf("unknown machine %q", m)
+		}
+	}
+
+	for _, l := range s.Lots {
+		if strings.TrimSpace(l) == "" {
+			return fmt.Errorf("empty lot")
+		}
+	}
+
+	if len(s.Machines)+len(s.Lots) == 0 {
+		return fmt.Errorf("no machine or lot to subscribe")
+	}
+
+	return nil
+}
+
+// add returns the machines and the lots of both s and other.
+func (s Subscription) add(other Subscription) Subscription {
+	machines := make([]string, 0, len(s.Machines)+len(other.Machines))
+	machines = append(append(machines, s.Machines...), other.Machines...)
+
+	lots := make([]string, 0, len(s.Lots)+len(other.Lots))
+	lots = append(append(lots, s.Lots...), other.Lots...)
+
+	return Subscription{Machines: machines, Lots: lots}
+}
+
+// matches tells whether an event of machine about lot is subscribed.
+func (s Subscription) matches(machine, lot string) bool {
+	for _, m := range s.Machines {
+		if m == machine {
+			return true
+		}
+	}
+
+	if lot == "" {
+		return false
+	}
+	for _, l := range s.Lots {
+		if strings.TrimSpace(l) == lot {
+			return true
+		}
+	}
+	return false
+}
+
+func knownMachine(machine string) bool {
+	for _, m := range Machines {
+		if m == machine {
+			return true
+		}
+	}
+	return false
+}
+
+// lotOf returns the lot an event is about, if any.
+func lotOf(data Payload) string {
+	switch d := data.(type) {
+	case WorkCreated:
+		return d.CdLotto
+	case WorkUpdated:
+		return d.CdLotto
+	case QuantityChanged:
+		return d.CdLotto
+	case StatusChanged:
+		return d.CdLotto
+	}
+	return ""
+}
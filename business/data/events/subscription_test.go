@@ -0,0 +1,53 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestSubscriptionValidate(t *testing.T) {
+	tests := []struct {
+		sub   Subscription
+		valid bool
+	}{
+		{Subscription{Machines: []string{"spindryer"}}, true},
+		{Subscription{Lots: []string{"24065C001"}}, true},
+		{Subscription{Machines: []string{"pasteurizer"}, Lots: []string{"24065C001"}}, true},
+		{Subscription{}, false},
+		{Subscription{Machines: []string{"oven"}}, false},
+		{Subscription{Lots: []string{" "}}, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.sub.Validate()
+		if tt.valid && err != nil {
+			t.Errorf("%+v: unexpected error: %v", tt.sub, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%+v: expected an error", tt.sub)
+		}
+	}
+}
+
+func TestSubscriptionAdd(t *testing.T) {
+	var sub Subscription
+	sub = sub.add(Subscription{Machines: []string{"spindryer"}})
+	sub = sub.add(Subscription{Lots: []string{"24065P001"}})
+
+	tests := []struct {
+		machine string
+		lot     string
+		want    bool
+	}{
+		{"spindryer", "", true},
+		{"spindryer", "24065C001", true},
+		{"pasteurizer", "24065P001", true},
+		{"pasteurizer", "24065P002", false},
+		{"pasteurizer", "", false},
+	}
+
+	for _, tt := range tests {
+		if got := sub.matches(tt.machine, tt.lot); got != tt.want {
+			t.Errorf("matches(%q, %q) = %v, want %v", tt.machine, tt.lot, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package genealogy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/devsamuele/service-kit/web"
+)
+
+// Machines a node of the genealogy can come from.
+const (
+	MachineSpindryer   = "spindryer"
+	MachinePasteurizer = "pasteurizer"
+)
+
+// NewParent declares a spindryer work, and the kilograms of its basil, used
+// by a pasteurizer work.
+type NewParent struct {
+	WorkID   *int `json:"work_id"`
+	Quantity *int `json:"quantity"`
+}
+
+// ValidateParents checks the parents declared by a new pasteurizer work.
+func ValidateParents(parents []NewParent) error {
+	seen := make(map[int]bool)
+	for i, p := range parents {
+		location := fmt.Sprintf("parents[%d]", i)
+
+		if p.WorkID == nil {
+			return web.NewError("work_id is required", web.ErrReasonRequired, "argument", location+".work_id")
+		}
+
+		if seen[*p.WorkID] {
+			return web.NewError(fmt.Sprintf("work %d is declared more than once", *p.WorkID), web.ErrReasonInvalidArgument, "argument", location+".work_id")
+		}
+		seen[*p.WorkID] = true
+
+		if p.Quantity == nil {
+			return web.NewError("quantity is required", web.ErrReasonRequired, "argument", location+".quantity")
+		}
+
+		if *p.Quantity <= 0 {
+			return web.NewError("quantity must be positive", web.ErrReasonInvalidArgument, "argument", location+".quantity")
+		}
+	}
+	return nil
+}
+
+// Link is a spindryer work used by a pasteurizer work.
+type Link struct {
+	ID           int       `json:"id" db:"id"`
+	ParentWorkID int       `json:"parent_work_id" db:"parent_work_id"`
+	ChildWorkID  int       `json:"child_work_id" db:"child_work_id"`
+	Quantity     int       `json:"quantity" db:"quantity"`
+	Created      time.Time `json:"created" db:"created"`
+}
+
+// Node is a work of the genealogy of a lot. Quantity is the quantity of the
+// link with the node it was reached from, nil for the works of the lot
+// itself. Links holds the next works in the direction of the walk.
+type Node struct {
+	Machine  string    `json:"machine"`
+	WorkID   int       `json:"work_id"`
+	CdLotto  string    `json:"cd_lotto"`
+	CdAr     string    `json:"cd_ar"`
+	Status   string    `json:"status"`
+	Date     time.Time `json:"date"`
+	Quantity *int      `json:"quantity"`
+	Links    []Node    `json:"links"`
+}
@@ -0,0 +1,118 @@
+package genealogy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/devsamuele/service-kit/web"
+)
+
+// Service walks the genealogy of the lots, from the centrifuged basil of the
+// spindryer to the packages of the pasteurizer.
+type Service struct {
+	store Storer
+	log   *log.Logger
+}
+
+func NewService(store Storer, log *log.Logger) Service {
+	return Service{store: store, log: log}
+}
+
+// Upstream returns the works that produced the lot, each with the spindryer
+// works it was made from.
+func (s Service) Upstream(ctx context.Context, cdLotto, cdAr string) ([]Node, error) {
+	nodes, err := s.lotWorks(ctx, cdLotto, cdAr)
+	if err != nil {
+		return make([]Node, 0), err
+	}
+
+	for i, n := range nodes {
+		if n.Machine != MachinePasteurizer {
+			continue
+		}
+
+		parents, err := s.store.QueryParents(ctx, n.WorkID)
+		if err != nil {
+			return make([]Node, 0), err
+		}
+		nodes[i].Links = parents
+	}
+
+	return nodes, nil
+}
+
+// Downstream returns the works that produced the lot, each with the
+// pasteurizer works that used it.
+func (s Service) Downstream(ctx context.Context, cdLotto, cdAr string) ([]Node, error) {
+	nodes, err := s.lotWorks(ctx, cdLotto, cdAr)
+	if err != nil {
+		return make([]Node, 0), err
+	}
+
+	for i, n := range nodes {
+		if n.Machine != MachineSpindryer {
+			continue
+		}
+
+		children, err := s.store.QueryChildren(ctx, n.WorkID)
+		if err != nil {
+			return make([]Node, 0), err
+		}
+		nodes[i].Links = children
+	}
+
+	return nodes, nil
+}
+
+func (s Service) lotWorks(ctx context.Context, cdLotto, cdAr string) ([]Node, error) {
+	nodes, err := s.store.QueryLotWorks(ctx, cdLotto, cdAr)
+	if err != nil {
+		return make([]Node, 0), err
+	}
+
+	if len(nodes) == 0 {
+		return make([]Node, 0), web.NewError(fmt.Sprintf("no work produced lot %s", cdLotto), web.ErrReasonNotFound, "parameter", "cd_lotto")
+	}
+
+	return nodes, nil
+}
+
+// LinkParents records in tx the spindryer works used by the pasteurizer
+// work, each for no more than the basil it has left. The parents must have
+// been validated with ValidateParents.
+func LinkParents(ctx context.Context, tx *sql.Tx, store Storer, childWorkID int, parents []NewParent, now time.Time) error {
+	for i, p := range parents {
+		found, err := store.CheckParent(ctx, tx, *p.WorkID)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return web.NewError(fmt.Sprintf("spindryer work %d does not exist, is archived or is not completed", *p.WorkID), web.ErrReasonInvalidArgument, "argument", fmt.Sprintf("parents[%d].work_id", i))
+		}
+
+		// The basil of a spindryer work is its cycles, in kilograms.
+		available, err := store.QueryAvailable(ctx, tx, *p.WorkID)
+		if err != nil {
+			return err
+		}
+
+		if *p.Quantity > available {
+			return web.NewError(fmt.Sprintf("spindryer work %d has %d kg left, %d requested", *p.WorkID, available, *p.Quantity), web.ErrReasonInvalidArgument, "argument", fmt.Sprintf("parents[%d].quantity", i))
+		}
+
+		if _, err := store.InsertLink(ctx, tx, Link{
+			ParentWorkID: *p.WorkID,
+			ChildWorkID:  childWorkID,
+			Quantity:     *p.Quantity,
+			Created:      now.UTC(),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
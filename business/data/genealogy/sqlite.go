@@ -0,0 +1,87 @@
+package genealogy
+
+import (
+	"context"
+	"database/sql"
+	"log"
+)
+
+// SQLiteStore implements Storer on the development database.
+type SQLiteStore struct {
+	db  *sql.DB
+	log *log.Logger
+}
+
+func NewSQLiteStore(db *sql.DB, log *log.Logger) SQLiteStore {
+	return SQLiteStore{db: db, log: log}
+}
+
+func (s SQLiteStore) CheckParent(ctx context.Context, tx *sql.Tx, workID int) (bool, error) {
+	row := tx.QueryRowContext(ctx, `select count(*) from xCentrifuga where id = ?1 and status = 'done' and deleted_at is null`, workID)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (s SQLiteStore) QueryAvailable(ctx context.Context, tx *sql.Tx, workID int) (int, error) {
+	row := tx.QueryRowContext(ctx, `select w.cycles - coalesce((select sum(g.quantity) from xGenealogia g where g.parent_work_id = w.id), 0)
+	from xCentrifuga w where w.id = ?1`, workID)
+
+	var available int
+	if err := row.Scan(&available); err != nil {
+		return 0, err
+	}
+
+	return available, nil
+}
+
+func (s SQLiteStore) InsertLink(ctx context.Context, tx *sql.Tx, l Link) (int, error) {
+	row := tx.QueryRowContext(ctx, `insert into xGenealogia (parent_work_id, child_work_id, quantity, created)
+	values(?1,?2,?3,?4) returning id`, l.ParentWorkID, l.ChildWorkID, l.Quantity, l.Created)
+
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s SQLiteStore) QueryLotWorks(ctx context.Context, cdLotto, cdAr string) ([]Node, error) {
+	return s.queryNodes(ctx, `select 'spindryer', id, cd_lotto, cd_ar, status, date, null from xCentrifuga where cd_lotto = ?1 and (?2 = '' or cd_ar = ?2) and deleted_at is null
+	union all select 'pasteurizer', id, cd_lotto, cd_ar, status, date, null from xPastorizzatore where cd_lotto = ?1 and (?2 = '' or cd_ar = ?2) and deleted_at is null
+	order by date`, cdLotto, cdAr)
+}
+
+func (s SQLiteStore) QueryParents(ctx context.Context, workID int) ([]Node, error) {
+	return s.queryNodes(ctx, `select 'spindryer', w.id, w.cd_lotto, w.cd_ar, w.status, w.date, g.quantity
+	from xGenealogia g join xCentrifuga w on w.id = g.parent_work_id where g.child_work_id = ?1 and w.deleted_at is null order by w.date`, workID)
+}
+
+func (s SQLiteStore) QueryChildren(ctx context.Context, workID int) ([]Node, error) {
+	return s.queryNodes(ctx, `select 'pasteurizer', w.id, w.cd_lotto, w.cd_ar, w.status, w.date, g.quantity
+	from xGenealogia g join xPastorizzatore w on w.id = g.child_work_id where g.parent_work_id = ?1 and w.deleted_at is null order by w.date`, workID)
+}
+
+func (s SQLiteStore) queryNodes(ctx context.Context, query string, args ...interface{}) ([]Node, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return make([]Node, 0), err
+	}
+	defer rows.Close()
+
+	nodes := make([]Node, 0)
+	for rows.Next() {
+		n := Node{Links: make([]Node, 0)}
+		if err := rows.Scan(&n.Machine, &n.WorkID, &n.CdLotto, &n.CdAr, &n.Status, &n.Date, &n.Quantity); err != nil {
+			return make([]Node, 0), err
+		}
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,111 @@
+package genealogy
+
+import (
+	"context"
+	"database/sql"
+	"log"
+)
+
+// Storer is the genealogy data. Store implements it on the Arca database,
+// SQLiteStore on the development one.
+type Storer interface {
+	CheckParent(ctx context.Context, tx *sql.Tx, workID int) (bool, error)
+	QueryAvailable(ctx context.Context, tx *sql.Tx, workID int) (int, error)
+	InsertLink(ctx context.Context, tx *sql.Tx, l Link) (int, error)
+	QueryLotWorks(ctx context.Context, cdLotto, cdAr string) ([]Node, error)
+	QueryParents(ctx context.Context, workID int) ([]Node, error)
+	QueryChildren(ctx context.Context, workID int) ([]Node, error)
+}
+
+type Store struct {
+	db  *sql.DB
+	log *log.Logger
+}
+
+func NewStore(db *sql.DB, log *log.Logger) Store {
+	return Store{db: db, log: log}
+}
+
+// CheckParent reports whether workID is a completed spindryer work, not
+// archived.
+func (s Store) CheckParent(ctx context.Context, tx *sql.Tx, workID int) (bool, error) {
+	row := tx.QueryRowContext(ctx, `select count(*) from xCentrifuga where id = @p1 and status = 'done' and deleted_at is null`, workID)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// QueryAvailable returns the kilograms of basil of the spindryer work not yet
+// allocated to a pasteurizer work, archived ones included since they can be
+// restored. The links of the work stay locked until tx ends, so that two
+// transactions never allocate the same basil.
+func (s Store) QueryAvailable(ctx context.Context, tx *sql.Tx, workID int) (int, error) {
+	row := tx.QueryRowContext(ctx, `select w.cycles - isnull((select sum(g.quantity) from xGenealogia g with (updlock, holdlock) where g.parent_work_id = w.id), 0) 
+	from xCentrifuga w with (updlock) where w.id = @p1`, workID)
+
+	var available int
+	if err := row.Scan(&available); err != nil {
+		return 0, err
+	}
+
+	return available, nil
+}
+
+func (s Store) InsertLink(ctx context.Context, tx *sql.Tx, l Link) (int, error) {
+	row := tx.QueryRowContext(ctx, `insert into xGenealogia (parent_work_id, child_work_id, quantity, created) 
+	values(@p1,@p2,@p3,@p4); select ID = convert(bigint, SCOPE_IDENTITY())`, l.ParentWorkID, l.ChildWorkID, l.Quantity, l.Created)
+	if err := row.Err(); err != nil {
+		return 0, err
+	}
+
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// QueryLotWorks returns the works of both machines that produced the lot,
+// of any article when cdAr is empty. Archived works are left out, as are
+// they from the parents and the children.
+func (s Store) QueryLotWorks(ctx context.Context, cdLotto, cdAr string) ([]Node, error) {
+	return s.queryNodes(ctx, `select 'spindryer', id, cd_lotto, cd_ar, status, date, null from xCentrifuga where cd_lotto = @p1 and (@p2 = '' or cd_ar = @p2) and deleted_at is null 
+	union all select 'pasteurizer', id, cd_lotto, cd_ar, status, date, null from xPastorizzatore where cd_lotto = @p1 and (@p2 = '' or cd_ar = @p2) and deleted_at is null 
+	order by date`, cdLotto, cdAr)
+}
+
+// QueryParents returns the spindryer works used by the pasteurizer work.
+func (s Store) QueryParents(ctx context.Context, workID int) ([]Node, error) {
+	return s.queryNodes(ctx, `select 'spindryer', w.id, w.cd_lotto, w.cd_ar, w.status, w.date, g.quantity 
+	from xGenealogia g join xCentrifuga w on w.id = g.parent_work_id where g.child_work_id = @p1 and w.deleted_at is null order by w.date`, workID)
+}
+
+// QueryChildren returns the pasteurizer works that used the spindryer work.
+func (s Store) QueryChildren(ctx context.Context, workID int) ([]Node, error) {
+	return s.queryNodes(ctx, `select 'pasteurizer', w.id, w.cd_lotto, w.cd_ar, w.status, w.date, g.quantity 
+	from xGenealogia g join xPastorizzatore w on w.id = g.child_work_id where g.parent_work_id = @p1 and w.deleted_at is null order by w.date`, workID)
+}
+
+func (s Store) queryNodes(ctx context.Context, query string, args ...interface{}) ([]Node, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return make([]Node, 0), err
+	}
+	defer rows.Close()
+
+	nodes := make([]Node, 0)
+	for rows.Next() {
+		n := Node{Links: make([]Node, 0)}
+		if err := rows.Scan(&n.Machine, &n.WorkID, &n.CdLotto, &n.CdAr, &n.Status, &n.Date, &n.Quantity); err != nil {
+			return make([]Node, 0), err
+		}
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
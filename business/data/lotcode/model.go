@@ -0,0 +1,38 @@
+package lotcode
+
+import "time"
+
+// Machine codes used to select the lot numbering of a machine.
+const (
+	MachineSpindryer   = "spindryer"
+	MachinePasteurizer = "pasteurizer"
+)
+
+// Config is the lot numbering of a machine: the code that replaces the
+// {machine} token and the pattern used when the article has none of its own.
+type Config struct {
+	Code    string
+	Pattern string
+}
+
+type NewLot struct {
+	Machine *string `json:"machine"`
+	CdAr    *string `json:"cd_ar"`
+}
+
+type Lot struct {
+	CdLotto       string     `json:"cd_lotto"`
+	CdAr          string     `json:"cd_ar"`
+	Pattern       string     `json:"pattern"`
+	ReservedUntil *time.Time `json:"reserved_until"`
+}
+
+// Reservation keeps a code handed out by NextLot out of the numbering until
+// the work using it is inserted or ExpiresAt.
+type Reservation struct {
+	Machine   string
+	CdAr      string
+	CdLotto   string
+	ExpiresAt time.Time
+	Created   time.Time
+}
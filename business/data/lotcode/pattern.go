@@ -0,0 +1,161 @@
+package lotcode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSeqWidth is the width of a {seq} token without an explicit one.
+const defaultSeqWidth = 3
+
+// A Pattern describes how lot codes are built. Literal text is copied as is
+// and the following tokens are replaced:
+//
+//	{yy}       two digit year
+//	{yyyy}     four digit year
+//	{mm}       two digit month
+//	{dd}       two digit day of the month
+//	{julian}   three digit day of the year
+//	{machine}  code of the machine
+//	{seq:N}    progressive number padded to N digits, 3 when omitted
+//
+// A pattern must contain exactly one {seq} token.
+type Pattern struct {
+	raw   string
+	parts []part
+}
+
+type part struct {
+	token   string
+	literal string
+	width   int
+}
+
+func ParsePattern(s string) (Pattern, error) {
+	p := Pattern{raw: s}
+	seqs := 0
+
+	for rest := s; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open == -1 {
+			p.parts = append(p.parts, part{literal: rest})
+			break
+		}
+		if open > 0 {
+			p.parts = append(p.parts, part{literal: rest[:open]})
+		}
+
+		end := strings.IndexByte(rest[open:], '}')
+		if end == -1 {
+			return Pattern{}, fmt.Errorf("pattern %q: unclosed token", s)
+		}
+		token := rest[open+1 : open+end]
+		rest = rest[open+end+1:]
+
+		name, arg, hasArg := strings.Cut(token, ":")
+		switch name {
+		case "yy", "yyyy", "mm", "dd", "julian", "machine":
+			if hasArg {
+				return Pattern{}, fmt.Errorf("pattern %q: token {%s} takes no width", s, name)
+			}
+			p.parts = append(p.parts, part{token: name})
+		case "seq":
+			width := defaultSeqWidth
+			if hasArg {
+				w, err := strconv.Atoi(arg)
+				if err != nil || w < 1 || w > 9 {
+					return Pattern{}, fmt.Errorf("pattern %q: invalid seq width %q", s, arg)
+				}
+				width = w
+			}
+			p.parts = append(p.parts, part{token: name, width: width})
+			seqs++
+		default:
+			return Pattern{}, fmt.Errorf("pattern %q: unknown token {%s}", s, token)
+		}
+	}
+
+	if seqs != 1 {
+		return Pattern{}, fmt.Errorf("pattern %q: exactly one {seq} token is required", s)
+	}
+
+	return p, nil
+}
+
+func (p Pattern) String() string {
+	return p.raw
+}
+
+// Render builds the lot code of the given day, machine and sequence number.
+func (p Pattern) Render(t time.Time, machine string, seq int) string {
+	var b strings.Builder
+	for _, pt := range p.parts {
+		if pt.token == "seq" {
+			fmt.Fprintf(&b, "%0*d", pt.width, seq)
+			continue
+		}
+		b.WriteString(p.render(pt, t, machine))
+	}
+	return b.String()
+}
+
+// Like returns a SQL like expression matching every code the pattern can
+// produce on the given day and machine, together with the position and width
+// of the sequence number in those codes.
+func (p Pattern) Like(t time.Time, machine string) (like string, seqStart int, seqWidth int) {
+	var b strings.Builder
+	var length int
+	for _, pt := range p.parts {
+		if pt.token == "seq" {
+			seqStart, seqWidth = length, pt.width
+			b.WriteString(strings.Repeat("_", pt.width))
+			length += pt.width
+			continue
+		}
+		s := p.render(pt, t, machine)
+		b.WriteString(escapeLike(s))
+		length += len(s)
+	}
+	return b.String(), seqStart, seqWidth
+}
+
+// MaxSeq is the greatest sequence number the pattern can represent.
+func (p Pattern) MaxSeq() int {
+	for _, pt := range p.parts {
+		if pt.token == "seq" {
+			max := 1
+			for i := 0; i < pt.width; i++ {
+				max *= 10
+			}
+			return max - 1
+		}
+	}
+	return 0
+}
+
+func (p Pattern) render(pt part, t time.Time, machine string) string {
+	switch pt.token {
+	case "yy":
+		return fmt.Sprintf("%02d", t.Year()%100)
+	case "yyyy":
+		return fmt.Sprintf("%04d", t.Year())
+	case "mm":
+		return fmt.Sprintf("%02d", int(t.Month()))
+	case "dd":
+		return fmt.Sprintf("%02d", t.Day())
+	case "julian":
+		return fmt.Sprintf("%03d", t.YearDay())
+	case "machine":
+		return machine
+	}
+	return pt.literal
+}
+
+// escapeLike escapes the characters with a special meaning in a like
+// expression, using the brackets syntax of SQL Server.
+func escapeLike(s string) string {
+	r := strings.NewReplacer("[", "[[]", "%", "[%]", "_", "[_]")
+	return r.Replace(s)
+}
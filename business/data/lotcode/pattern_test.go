@@ -0,0 +1,134 @@
+package lotcode
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParsePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		valid   bool
+	}{
+		{"{yy}{julian}{machine}{seq:3}", true},
+		{"L-{yyyy}{mm}{dd}-{seq}", true},
+		{"{seq:9}", true},
+		{"", false},
+		{"{yy}{julian}", false},
+		{"{seq}{seq}", false},
+		{"{seq:0}", false},
+		{"{seq:10}", false},
+		{"{seq:x}", false},
+		{"{yy:2}{seq}", false},
+		{"{week}{seq}", false},
+		{"{yy{seq}", false},
+		{"{seq", false},
+	}
+
+	for _, tt := range tests {
+		_, err := ParsePattern(tt.pattern)
+		if tt.valid && err != nil {
+			t.Errorf("ParsePattern(%q): unexpected error: %v", tt.pattern, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("ParsePattern(%q): expected an error", tt.pattern)
+		}
+	}
+}
+
+func TestPatternRender(t *testing.T) {
+	day := time.Date(2024, time.February, 3, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		pattern string
+		seq     int
+		want    string
+	}{
+		{"{yy}{julian}{machine}{seq:3}", 7, "24034C007"},
+		{"L-{yyyy}{mm}{dd}-{seq}", 12, "L-20240203-012"},
+		{"{machine}{seq:5}", 123456, "C123456"},
+	}
+
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", tt.pattern, err)
+		}
+		if got := p.Render(day, "C", tt.seq); got != tt.want {
+			t.Errorf("%q.Render(%d) = %q, want %q", tt.pattern, tt.seq, got, tt.want)
+		}
+	}
+}
+
+func TestPatternLike(t *testing.T) {
+	day := time.Date(2024, time.February, 3, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		pattern string
+		machine string
+		like    string
+		start   int
+		width   int
+	}{
+		{"{yy}{julian}{machine}{seq:3}", "C", "24034C___", 6, 3},
+		{"{seq:4}-{yy}", "P", "____-24", 0, 4},
+		{"A_B%[{seq}", "C", "A[_]B[%][[]___", 5, 3},
+	}
+
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", tt.pattern, err)
+		}
+
+		like, start, width := p.Like(day, tt.machine)
+		if like != tt.like || start != tt.start || width != tt.width {
+			t.Errorf("%q.Like() = %q, %d, %d, want %q, %d, %d", tt.pattern, like, start, width, tt.like, tt.start, tt.width)
+		}
+
+		// The sequence number sits where Like says it does.
+		code := p.Render(day, tt.machine, 1)
+		if got, want := code[start:start+width], fmt.Sprintf("%0*d", width, 1); got != want {
+			t.Errorf("%q: sequence of %q at %d is %q", tt.pattern, code, start, got)
+		}
+	}
+}
+
+func TestPatternMaxSeq(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    int
+	}{
+		{"{seq}", 999},
+		{"{seq:1}", 9},
+		{"{yy}{seq:5}", 99999},
+	}
+
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", tt.pattern, err)
+		}
+		if got := p.MaxSeq(); got != tt.want {
+			t.Errorf("%q.MaxSeq() = %d, want %d", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestLikeToGlob(t *testing.T) {
+	tests := []struct {
+		like string
+		want string
+	}{
+		{"24034C___", "24034C???"},
+		{"A[_]B[%][[]___", "A_B%[[]???"},
+		{"a*b%", "a[*]b*"},
+	}
+
+	for _, tt := range tests {
+		if got := likeToGlob(tt.like); got != tt.want {
+			t.Errorf("likeToGlob(%q) = %q, want %q", tt.like, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package lotcode
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/arca"
+	"github.com/devsamuele/service-kit/web"
+)
+
+// reservation is how long a code handed out by NextLot stays reserved for
+// the work to be inserted with it.
+const reservation = 30 * time.Minute
+
+// Service hands out the next free lot code of a machine and article.
+type Service struct {
+	store    Storer
+	arca     arca.Storer
+	machines map[string]Config
+	log      *log.Logger
+}
+
+func NewService(store Storer, arcaStore arca.Storer, machines map[string]Config, log *log.Logger) (*Service, error) {
+	for machine, cfg := range machines {
+		if _, err := ParsePattern(cfg.Pattern); err != nil {
+			return nil, fmt.Errorf("%s lot pattern: %w", machine, err)
+		}
+	}
+
+	return &Service{
+		store:    store,
+		arca:     arcaStore,
+		machines: machines,
+		log:      log,
+	}, nil
+}
+
+// NextLot reserves and returns the next free lot code of the article on the
+// machine. The code is left out of the numbering until the work inserted
+// with it releases it or the reservation expires.
+func (s *Service) NextLot(ctx context.Context, nl NewLot, now time.Time) (Lot, error) {
+	if nl.Machine == nil || *nl.Machine == "" {
+		return Lot{}, web.NewError("machine is required", web.ErrReasonRequired, "argument", "machine")
+	}
+
+	if _, ok := s.machines[*nl.Machine]; !ok {
+		return Lot{}, web.NewError(fmt.Sprintf("unknown machine %s", *nl.Machine), web.ErrReasonInvalidArgument, "argument", "machine")
+	}
+
+	if nl.CdAr == nil || *nl.CdAr == "" {
+		return Lot{}, web.NewError("cd_ar is required", web.ErrReasonRequired, "argument", "cd_ar")
+	}
+
+	if err := arca.ValidateArticle(ctx, s.arca, *nl.CdAr); err != nil {
+		return Lot{}, err
+	}
+
+	tx, err := s.store.BeginTx(ctx)
+	if err != nil {
+		return Lot{}, err
+	}
+
+	defer tx.Rollback()
+
+	if err := s.store.DeleteExpiredReservations(ctx, tx, now); err != nil {
+		return Lot{}, err
+	}
+
+	lot, err := s.next(ctx, tx, *nl.Machine, *nl.CdAr, now)
+	if err != nil {
+		return Lot{}, err
+	}
+
+	until := now.Add(reservation).UTC()
+	r := Reservation{Machine: *nl.Machine, CdAr: lot.CdAr, CdLotto: lot.CdLotto, ExpiresAt: until, Created: now.UTC()}
+	if err := s.store.InsertReservation(ctx, tx, r); err != nil {
+		return Lot{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Lot{}, err
+	}
+	lot.ReservedUntil = &until
+
+	return lot, nil
+}
+
+// Release frees the reservation of the code, if any, once a work uses it.
+func (s *Service) Release(ctx context.Context, tx *sql.Tx, cdLotto string) error {
+	return s.store.DeleteReservation(ctx, tx, cdLotto)
+}
+
+// Next returns the first free lot code of the article on the machine. The
+// codes sharing its pattern stay locked until tx ends, so the caller must
+// use the code in tx.
+func (s *Service) Next(ctx context.Context, tx *sql.Tx, machine, cdAr string, now time.Time) (string, error) {
+	lot, err := s.next(ctx, tx, machine, cdAr, now)
+	if err != nil {
+		return "", err
+	}
+	return lot.CdLotto, nil
+}
+
+func (s *Service) next(ctx context.Context, tx *sql.Tx, machine, cdAr string, now time.Time) (Lot, error) {
+	cfg, ok := s.machines[machine]
+	if !ok {
+		return Lot{}, fmt.Errorf("no lot numbering configured for %s", machine)
+	}
+
+	raw, err := s.store.QueryPattern(ctx, tx, machine, cdAr)
+	if err != nil {
+		return Lot{}, err
+	}
+	if raw == "" {
+		raw = cfg.Pattern
+	}
+
+	pattern, err := ParsePattern(raw)
+	if err != nil {
+		return Lot{}, err
+	}
+
+	like, start, width := pattern.Like(now, cfg.Code)
+	codes, err := s.store.QueryCodes(ctx, tx, like, now)
+	if err != nil {
+		return Lot{}, err
+	}
+
+	var last int
+	for _, code := range codes {
+		code = strings.TrimSpace(code)
+		if len(code) < start+width {
+			continue
+		}
+		seq, err := strconv.Atoi(code[start : start+width])
+		if err != nil {
+			continue
+		}
+		if seq > last {
+			last = seq
+		}
+	}
+
+	if last >= pattern.MaxSeq() {
+		return Lot{}, web.NewError(fmt.Sprintf("lot codes of pattern %s are exhausted", pattern), web.ErrReasonConflict, "argument", "cd_ar")
+	}
+
+	code := pattern.Render(now, cfg.Code, last+1)
+	if len(code) > arca.MaxLotLength {
+		return Lot{}, fmt.Errorf("lot code %s longer than %d characters", code, arca.MaxLotLength)
+	}
+
+	return Lot{
+		CdLotto: code,
+		CdAr:    cdAr,
+		Pattern: pattern.String(),
+	}, nil
+}
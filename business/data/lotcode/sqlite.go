@@ -0,0 +1,124 @@
+package lotcode
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// SQLiteStore implements Storer on the development database.
+type SQLiteStore struct {
+	db  *sql.DB
+	log *log.Logger
+}
+
+func NewSQLiteStore(db *sql.DB, log *log.Logger) SQLiteStore {
+	return SQLiteStore{db: db, log: log}
+}
+
+func (s SQLiteStore) BeginTx(ctx context.Context) (*sql.Tx, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (s SQLiteStore) QueryPattern(ctx context.Context, tx *sql.Tx, machine, cdAr string) (string, error) {
+	row := tx.QueryRowContext(ctx, `select pattern from xLottoSchema where machine = ?1 and cd_ar = ?2 limit 1`, machine, cdAr)
+
+	var pattern string
+	if err := row.Scan(&pattern); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return pattern, nil
+}
+
+// QueryCodes needs no lock hint: the transactions of the development
+// database already hold the write lock of the whole file.
+func (s SQLiteStore) QueryCodes(ctx context.Context, tx *sql.Tx, like string, now time.Time) ([]string, error) {
+	glob := likeToGlob(like)
+	rows, err := tx.QueryContext(ctx, `select Cd_ARLotto from ARLotto where Cd_ARLotto glob ?1
+	union select cd_lotto from xCentrifuga where cd_lotto glob ?1
+	union select cd_lotto from xPastorizzatore where cd_lotto glob ?1
+	union select cd_lotto from xLottoPrenotazione where cd_lotto glob ?1 and expires_at > ?2`, glob, now.UTC())
+	if err != nil {
+		return make([]string, 0), err
+	}
+	defer rows.Close()
+
+	codes := make([]string, 0)
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return make([]string, 0), err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+func (s SQLiteStore) InsertReservation(ctx context.Context, tx *sql.Tx, r Reservation) error {
+	_, err := tx.ExecContext(ctx, `insert into xLottoPrenotazione (machine, cd_ar, cd_lotto, expires_at, created) values(?1,?2,?3,?4,?5)`,
+		r.Machine, r.CdAr, r.CdLotto, r.ExpiresAt.UTC(), r.Created.UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s SQLiteStore) DeleteReservation(ctx context.Context, tx *sql.Tx, cdLotto string) error {
+	_, err := tx.ExecContext(ctx, `delete from xLottoPrenotazione where cd_lotto = ?1`, cdLotto)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s SQLiteStore) DeleteExpiredReservations(ctx context.Context, tx *sql.Tx, now time.Time) error {
+	_, err := tx.ExecContext(ctx, `delete from xLottoPrenotazione where expires_at <= ?1`, now.UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// likeToGlob turns a like expression of Pattern.Like into the equivalent
+// SQLite glob, since the like of SQLite knows nothing of the brackets syntax.
+func likeToGlob(like string) string {
+	var b strings.Builder
+	for i := 0; i < len(like); i++ {
+		c := like[i]
+		switch {
+		case c == '[' && i+2 < len(like) && like[i+2] == ']':
+			c = like[i+1]
+			i += 2
+		case c == '_':
+			b.WriteByte('?')
+			continue
+		case c == '%':
+			b.WriteByte('*')
+			continue
+		}
+
+		if c == '*' || c == '?' || c == '[' {
+			b.WriteByte('[')
+			b.WriteByte(c)
+			b.WriteByte(']')
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,109 @@
+package lotcode
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// Storer is the lot codes data. Store implements it on the Arca database,
+// SQLiteStore on the development one.
+type Storer interface {
+	BeginTx(ctx context.Context) (*sql.Tx, error)
+	QueryPattern(ctx context.Context, tx *sql.Tx, machine, cdAr string) (string, error)
+	QueryCodes(ctx context.Context, tx *sql.Tx, like string, now time.Time) ([]string, error)
+	InsertReservation(ctx context.Context, tx *sql.Tx, r Reservation) error
+	DeleteReservation(ctx context.Context, tx *sql.Tx, cdLotto string) error
+	DeleteExpiredReservations(ctx context.Context, tx *sql.Tx, now time.Time) error
+}
+
+type Store struct {
+	db  *sql.DB
+	log *log.Logger
+}
+
+func NewStore(db *sql.DB, log *log.Logger) Store {
+	return Store{db: db, log: log}
+}
+
+func (s Store) BeginTx(ctx context.Context) (*sql.Tx, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// QueryPattern returns the pattern configured for the article on the
+// machine, or an empty string when the article has none.
+func (s Store) QueryPattern(ctx context.Context, tx *sql.Tx, machine, cdAr string) (string, error) {
+	row := tx.QueryRowContext(ctx, `select top(1) pattern from xLottoSchema where machine = @p1 and cd_ar = @p2`, machine, cdAr)
+
+	var pattern string
+	if err := row.Scan(&pattern); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return pattern, nil
+}
+
+// QueryCodes returns the lot codes matching like that are already used in
+// Arca or by a machine, or reserved until after now. The range is locked
+// until the end of tx so that two transactions never hand out the same code.
+func (s Store) QueryCodes(ctx context.Context, tx *sql.Tx, like string, now time.Time) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `select Cd_ARLotto from ARLotto with (updlock, holdlock) where Cd_ARLotto like @p1 
+	union select cd_lotto from xCentrifuga with (updlock, holdlock) where cd_lotto like @p1 
+	union select cd_lotto from xPastorizzatore with (updlock, holdlock) where cd_lotto like @p1 
+	union select cd_lotto from xLottoPrenotazione with (updlock, holdlock) where cd_lotto like @p1 and expires_at > @p2`, like, now.UTC())
+	if err != nil {
+		return make([]string, 0), err
+	}
+	defer rows.Close()
+
+	codes := make([]string, 0)
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return make([]string, 0), err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// InsertReservation reserves the code of r until r.ExpiresAt.
+func (s Store) InsertReservation(ctx context.Context, tx *sql.Tx, r Reservation) error {
+	_, err := tx.ExecContext(ctx, `insert into xLottoPrenotazione (machine, cd_ar, cd_lotto, expires_at, created) values(@p1,@p2,@p3,@p4,@p5)`,
+		r.Machine, r.CdAr, r.CdLotto, r.ExpiresAt.UTC(), r.Created.UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteReservation releases the code, once used by a work.
+func (s Store) DeleteReservation(ctx context.Context, tx *sql.Tx, cdLotto string) error {
+	_, err := tx.ExecContext(ctx, `delete from xLottoPrenotazione where cd_lotto = @p1`, cdLotto)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpiredReservations removes the reservations expired by now.
+func (s Store) DeleteExpiredReservations(ctx context.Context, tx *sql.Tx, now time.Time) error {
+	_, err := tx.ExecContext(ctx, `delete from xLottoPrenotazione where expires_at <= @p1`, now.UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
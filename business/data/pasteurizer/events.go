@@ -0,0 +1,22 @@
+package pasteurizer
+
+import (
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/events"
+)
+
+func workCreated(w Work) events.WorkCreated {
+	return events.WorkCreated{WorkID: w.ID, CdLotto: w.CdLotto, Work: w}
+}
+
+func workUpdated(w Work, change string) events.WorkUpdated {
+	return events.WorkUpdated{WorkID: w.ID, CdLotto: w.CdLotto, Change: change, Work: w}
+}
+
+func statusChanged(w Work, previous string) events.StatusChanged {
+	return events.StatusChanged{WorkID: w.ID, CdLotto: w.CdLotto, Previous: previous, Status: w.Status, Work: w}
+}
+
+// quantityChanged reports a quantity of the work, basil_amount or packages.
+func quantityChanged(w Work, quantity string, previous, value int, source string) events.QuantityChanged {
+	return events.QuantityChanged{WorkID: w.ID, CdLotto: w.CdLotto, Quantity: quantity, Previous: float64(previous), Value: float64(value), Source: source}
+}
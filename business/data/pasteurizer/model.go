@@ -2,7 +2,13 @@ package pasteurizer
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/arca"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/genealogy"
+	"github.com/devsamuele/service-kit/web"
 )
 
 const (
@@ -12,34 +18,167 @@ const (
 	PROCESSING_STATUS_DONE  = "done"
 )
 
+// Work is a lot processed by the machine. Its times are kept in UTC: the
+// datetime columns store no offset and are read back as UTC.
 type Work struct {
-	ID              int       `json:"id" db:"id"`
-	CdLotto         string    `json:"cd_lotto" db:"cd_lotto"`
-	CdAr            string    `json:"cd_ar" db:"cd_ar"`
-	BasilAmount     int       `json:"basil_amount" db:"basil_amount"`
-	Packages        int       `json:"packages" db:"packages"`
-	Date            time.Time `json:"date" db:"date"`
-	DocumentCreated bool      `json:"document_created" db:"document_created"`
-	Status          string    `json:"status" db:"status"`
-	Created         time.Time `json:"created" db:"created"`
+	ID              int        `json:"id" db:"id"`
+	CdLotto         string     `json:"cd_lotto" db:"cd_lotto"`
+	CdAr            string     `json:"cd_ar" db:"cd_ar"`
+	BasilAmount     int        `json:"basil_amount" db:"basil_amount"`
+	Packages        int        `json:"packages" db:"packages"`
+	PlcBasilAmount  *int       `json:"plc_basil_amount" db:"plc_basil_amount"`
+	PlcPackages     *int       `json:"plc_packages" db:"plc_packages"`
+	StartedAt       *time.Time `json:"started_at" db:"started_at"`
+	EndedAt         *time.Time `json:"ended_at" db:"ended_at"`
+	PausedAt        *time.Time `json:"paused_at" db:"paused_at"`
+	ActiveSeconds   int        `json:"active_seconds" db:"active_seconds"`
+	PausedSeconds   int        `json:"paused_seconds" db:"paused_seconds"`
+	Date            time.Time  `json:"date" db:"date"`
+	DocumentCreated bool       `json:"document_created" db:"document_created"`
+	DocumentNumber  *int       `json:"document_number" db:"document_number"`
+	DocumentDate    *time.Time `json:"document_date" db:"document_date"`
+	OrderID         *int       `json:"order_id" db:"order_id"`
+	Status          string     `json:"status" db:"status"`
+	Created         time.Time  `json:"created" db:"created"`
+	DeletedAt       *time.Time `json:"deleted_at" db:"deleted_at"`
+	DeletedBy       *string    `json:"deleted_by" db:"deleted_by"`
+	DeleteReason    *string    `json:"delete_reason" db:"delete_reason"`
+	Version         []byte     `json:"-" db:"version"`
+}
+
+// Pause marks a work in progress as paused at t. The PLC exposes no pause
+// signal, so a work is considered paused while the OPC UA session to the
+// machine is down.
+func (w *Work) Pause(t time.Time) {
+	if w.Status != PROCESSING_STATUS_WORK || w.PausedAt != nil {
+		return
+	}
+	t = t.UTC()
+	w.PausedAt = &t
+}
+
+// Resume adds the time elapsed since the last Pause to the paused duration.
+func (w *Work) Resume(t time.Time) {
+	if w.PausedAt == nil {
+		return
+	}
+	if d := t.Sub(*w.PausedAt); d > 0 {
+		w.PausedSeconds += int(d.Seconds())
+	}
+	w.PausedAt = nil
 }
 
+// End closes the work at t and computes its active duration.
+func (w *Work) End(t time.Time) {
+	t = t.UTC()
+	w.Resume(t)
+	w.EndedAt = &t
+	if w.StartedAt == nil {
+		return
+	}
+	active := int(t.Sub(*w.StartedAt).Seconds()) - w.PausedSeconds
+	if active < 0 {
+		active = 0
+	}
+	w.ActiveSeconds = active
+}
+
+// NewWork is the lot to send to the machine. The lot code is generated when
+// cd_lotto is omitted or empty. When order_id is set the work produces that
+// production order line and its article is taken from the order. Parents are
+// the spindryer works whose basil goes into the lot.
 type NewWork struct {
-	CdLotto *string `json:"cd_lotto"`
-	CdAr    *string `json:"cd_ar"`
+	CdLotto *string               `json:"cd_lotto"`
+	CdAr    *string               `json:"cd_ar"`
+	OrderID *int                  `json:"order_id"`
+	Parents []genealogy.NewParent `json:"parents"`
 }
 
 func (nw NewWork) Validate() error {
-	if nw.CdLotto == nil {
-		return fmt.Errorf("cd_lotto is required")
+	if nw.CdLotto != nil && len(*nw.CdLotto) > arca.MaxLotLength {
+		return web.NewError(fmt.Sprintf("cd_lotto must be at most %d characters", arca.MaxLotLength), web.ErrReasonInvalidArgument, "argument", "cd_lotto")
+	}
+
+	if nw.OrderID == nil && (nw.CdAr == nil || strings.TrimSpace(*nw.CdAr) == "") {
+		return web.NewError("cd_ar is required", web.ErrReasonRequired, "argument", "cd_ar")
+	}
+
+	if nw.CdAr != nil && len(*nw.CdAr) > arca.MaxArticleLength {
+		return web.NewError(fmt.Sprintf("cd_ar must be at most %d characters", arca.MaxArticleLength), web.ErrReasonInvalidArgument, "argument", "cd_ar")
+	}
+
+	return genealogy.ValidateParents(nw.Parents)
+}
+
+// CorrectWork holds the quantities an operator wants to fix on a completed work.
+// Nil fields are left untouched.
+type CorrectWork struct {
+	BasilAmount *int    `json:"basil_amount"`
+	Packages    *int    `json:"packages"`
+	Reason      *string `json:"reason"`
+}
+
+func (cw CorrectWork) Validate() error {
+	if cw.Reason == nil || *cw.Reason == "" {
+		return web.NewError("reason is required", web.ErrReasonRequired, "argument", "reason")
+	}
+
+	if cw.BasilAmount == nil && cw.Packages == nil {
+		return web.NewError("basil_amount or packages is required", web.ErrReasonRequired, "argument", "basil_amount")
+	}
+
+	if cw.BasilAmount != nil && *cw.BasilAmount < 0 {
+		return web.NewError("basil_amount must not be negative", web.ErrReasonInvalidArgument, "argument", "basil_amount")
+	}
+
+	if cw.Packages != nil && *cw.Packages < 0 {
+		return web.NewError("packages must not be negative", web.ErrReasonInvalidArgument, "argument", "packages")
+	}
+	return nil
+}
+
+// maxDeleteReasonLength is the size of the delete_reason column.
+const maxDeleteReasonLength = 1000
+
+// DeleteWork holds the reason an operator archives a work for.
+type DeleteWork struct {
+	Reason *string `json:"reason"`
+}
+
+func (dw DeleteWork) Validate() error {
+	if dw.Reason == nil || strings.TrimSpace(*dw.Reason) == "" {
+		return web.NewError("reason is required", web.ErrReasonRequired, "argument", "reason")
 	}
 
-	if nw.CdAr == nil {
-		return fmt.Errorf("cd_ar is required")
+	if len(*dw.Reason) > maxDeleteReasonLength {
+		return web.NewError(fmt.Sprintf("reason must be at most %d characters", maxDeleteReasonLength), web.ErrReasonInvalidArgument, "argument", "reason")
 	}
 	return nil
 }
 
+// Correction is the audit record of a single quantity change made by a user.
+type Correction struct {
+	ID       int       `json:"id" db:"id"`
+	WorkID   int       `json:"work_id" db:"work_id"`
+	Field    string    `json:"field" db:"field"`
+	PlcValue int       `json:"plc_value" db:"plc_value"`
+	OldValue int       `json:"old_value" db:"old_value"`
+	NewValue int       `json:"new_value" db:"new_value"`
+	User     string    `json:"user" db:"user"`
+	Reason   string    `json:"reason" db:"reason"`
+	Created  time.Time `json:"created" db:"created"`
+}
+
+// CycleTime aggregates the durations of the completed works of an article.
+type CycleTime struct {
+	CdAr             string `json:"cd_ar"`
+	Works            int    `json:"works"`
+	AvgActiveSeconds int    `json:"avg_active_seconds"`
+	MinActiveSeconds int    `json:"min_active_seconds"`
+	MaxActiveSeconds int    `json:"max_active_seconds"`
+	AvgPausedSeconds int    `json:"avg_paused_seconds"`
+}
+
 type ID struct {
 	ID int `json:"id"`
 }
@@ -47,3 +186,91 @@ type ID struct {
 type OpcuaConnection struct {
 	Connected bool `json:"connected"`
 }
+
+// DocumentLink is the Arca document linked to a work through the xId column
+// of DoTes. Number and Date are nil when no document is linked any more.
+type DocumentLink struct {
+	WorkID int
+	Number *int
+	Date   *time.Time
+}
+
+// WorkQuery holds the query parameters of the work list, as received. From
+// and to are formatted as 2006-01-02 and inclusive, sort is a comma
+// separated list of fields each prefixed by - for the descending order, and
+// page_token is the token returned with the previous page.
+type WorkQuery struct {
+	From            string
+	To              string
+	CdLotto         string
+	CdAr            string
+	Status          string
+	DocumentCreated string
+	Sort            string
+	Limit           string
+	PageToken       string
+}
+
+// WorkFilter restricts the works returned by QueryWork. Nil fields are not
+// applied. Deleted selects the archived works in place of the live ones.
+// From and To are the bounds of the local days of the query, in UTC like the
+// dates of the works.
+type WorkFilter struct {
+	Deleted         bool
+	From            *time.Time
+	To              *time.Time
+	CdLotto         *string
+	CdAr            *string
+	Status          *string
+	DocumentCreated *bool
+}
+
+// Filter validates the filters of the query.
+func (q WorkQuery) Filter() (WorkFilter, error) {
+	var f WorkFilter
+
+	if q.From != "" {
+		t, err := time.ParseInLocation("2006-01-02", q.From, time.Local)
+		if err != nil {
+			return WorkFilter{}, web.NewError("invalid from date", web.ErrReasonInvalidParameter, "parameter", "from")
+		}
+		t = t.UTC()
+		f.From = &t
+	}
+
+	if q.To != "" {
+		t, err := time.ParseInLocation("2006-01-02", q.To, time.Local)
+		if err != nil {
+			return WorkFilter{}, web.NewError("invalid to date", web.ErrReasonInvalidParameter, "parameter", "to")
+		}
+		t = t.AddDate(0, 0, 1).UTC()
+		f.To = &t
+	}
+
+	if q.CdLotto != "" {
+		f.CdLotto = &q.CdLotto
+	}
+
+	if q.CdAr != "" {
+		f.CdAr = &q.CdAr
+	}
+
+	if q.Status != "" {
+		switch q.Status {
+		case PROCESSING_STATUS_SENT, PROCESSING_STATUS_WORK, PROCESSING_STATUS_ERROR, PROCESSING_STATUS_DONE:
+		default:
+			return WorkFilter{}, web.NewError("invalid status", web.ErrReasonInvalidParameter, "parameter", "status")
+		}
+		f.Status = &q.Status
+	}
+
+	if q.DocumentCreated != "" {
+		b, err := strconv.ParseBool(q.DocumentCreated)
+		if err != nil {
+			return WorkFilter{}, web.NewError("document_created must be a boolean", web.ErrReasonInvalidParameter, "parameter", "document_created")
+		}
+		f.DocumentCreated = &b
+	}
+
+	return f, nil
+}
@@ -2,300 +2,545 @@ package pasteurizer
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"time"
 
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/arca"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/events"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/genealogy"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/sample"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/stock"
 	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/opcuaconn"
-	"github.com/devsamuele/service-kit/ws"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/outbox"
 	"github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/ua"
 )
 
+// The simulator exposes the same tags under ns=8.
+const (
+	nodeOrderConf     = "ns=2;s=Siemens S7-1200/S7-1500.Tags.Send.Conferma_Nuovo_Lotto"
+	nodeEndWork       = "ns=2;s=Siemens S7-1200/S7-1500.Tags.Send.Fine_Produzione"
+	nodeBasilAmount   = "ns=2;s=Siemens S7-1200/S7-1500.Tags.Send.Quantità_Basilico_Lavorato"
+	nodeBasilPackages = "ns=2;s=Siemens S7-1200/S7-1500.Tags.Send.Numero_Di_Imballi"
+	nodeLotNumber     = "ns=2;s=Siemens S7-1200/S7-1500.Tags.Receive.Numero_Lotto"
+	nodeNewLotBit     = "ns=2;s=Siemens S7-1200/S7-1500.Tags.Receive.Bit_Nuovo_Lotto"
+)
+
+// eventQueueSize bounds the notifications waiting for the event loop.
+const eventQueueSize = 256
+
+// replayInterval is how often the notifications left in the outbox are
+// applied again while the database is unreachable.
+const replayInterval = 5 * time.Second
+
+// maxAttempts is how many times in a row a notification is applied while the
+// database is reachable before it is moved to the dead-letter file.
+const maxAttempts = 5
+
+// event is a tag notification queued for the event loop, with the values of
+// the other tags it is applied against. An event without a node asks the
+// loop to reload the active work from the database.
+type event struct {
+	node      string
+	value     interface{}
+	timestamp time.Time
+	related   map[string]interface{}
+}
+
+// OpcuaService processes the pasteurizer tag notifications one at a time, in
+// the order they are received, against an in-memory copy of the active work.
 type OpcuaService struct {
-	ctx   context.Context
-	c     *opcua.Client
-	log   *log.Logger
-	store Store
-	io    *ws.EventEmitter
+	ctx       context.Context
+	c         *opcua.Client
+	log       *log.Logger
+	store     Storer
+	arca      arca.Storer
+	lot       arca.LotConfig
+	orderUnit string
+	publisher events.Publisher
+	genealogy genealogy.Storer
+	outbox    *outbox.Journal
+	stock     stock.Service
+	samples   *sample.Recorder
+	events    chan event
+	work      *Work
+
+	// loaded and reconciled track the startup of the loop, which is retried
+	// until the database is reachable.
+	loaded     bool
+	reconciled bool
+
+	// backlog tracks the notification_backlog alarm, raised while the
+	// outbox cannot be applied.
+	backlog bool
 }
 
-func NewOpcuaService(ctx context.Context, log *log.Logger, c *opcua.Client, store Store, io *ws.EventEmitter) *OpcuaService {
+func NewOpcuaService(ctx context.Context, log *log.Logger, c *opcua.Client, store Storer, arcaStore arca.Storer, lot arca.LotConfig, orderUnit string, genealogyStore genealogy.Storer, journal *outbox.Journal, stockService stock.Service, samples *sample.Recorder, publisher events.Publisher) *OpcuaService {
 	return &OpcuaService{
-		ctx:   ctx,
-		c:     c,
-		log:   log,
-		store: store,
-		io:    io,
+		ctx:       ctx,
+		c:         c,
+		log:       log,
+		store:     store,
+		arca:      arcaStore,
+		lot:       lot,
+		orderUnit: orderUnit,
+		publisher: publisher,
+		genealogy: genealogyStore,
+		outbox:    journal,
+		stock:     stockService,
+		samples:   samples,
+		events:    make(chan event, eventQueueSize),
 	}
 }
 
 func (o *OpcuaService) Run() {
-	// go o.WatchOrderConf("ns=8;s=Siemens S7-1200/S7-1500.Tags.Send.Conferma_Nuovo_Lotto", 1)
-	// go o.WatchEndWork("ns=8;s=Siemens S7-1200/S7-1500.Tags.Send.Fine_Produzione", 2)
-	// go o.WatchBasilAmount("ns=8;s=Siemens S7-1200/S7-1500.Tags.Send.Quantità_Basilico_Lavorato", 3)
-	// go o.WatchBasilPackages("ns=8;s=Siemens S7-1200/S7-1500.Tags.Send.Numero_Di_Imballi", 4)
-	go o.WatchOrderConf("ns=2;s=Siemens S7-1200/S7-1500.Tags.Send.Conferma_Nuovo_Lotto", 1)
-	go o.WatchEndWork("ns=2;s=Siemens S7-1200/S7-1500.Tags.Send.Fine_Produzione", 1)
-	go o.WatchBasilAmount("ns=2;s=Siemens S7-1200/S7-1500.Tags.Send.Quantità_Basilico_Lavorato", 1)
-	go o.WatchBasilPackages("ns=2;s=Siemens S7-1200/S7-1500.Tags.Send.Numero_Di_Imballi", 1)
+	go o.loop()
+
+	go o.watch(nodeOrderConf, 1)
+	go o.watch(nodeEndWork, 1)
+	go o.watch(nodeBasilAmount, 1)
+	go o.watch(nodeBasilPackages, 1)
 }
 
-func (o *OpcuaService) WatchOrderConf(nodeID string, clientHandle uint32) {
+// Refresh asks the event loop to reload the active work after it was changed
+// outside of the loop, e.g. through the REST API.
+func (o *OpcuaService) Refresh() {
+	o.enqueue(event{})
+}
 
-	opcuaconn.Subscribe(o.ctx, o.c, nodeID, clientHandle, func(data interface{}) {
-		bit, _ := data.(bool)
-		if bit {
-			found := true
-			oldWork, err := o.store.QueryActiveWork(o.ctx)
-			if err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					found = false
-				} else {
-					return
-				}
-			}
+func (o *OpcuaService) watch(nodeID string, clientHandle uint32) {
+	opcuaconn.Subscribe(o.ctx, o.c, nodeID, clientHandle, func(data interface{}, status ua.StatusCode, sourceTimestamp time.Time) {
+		o.samples.Record(sample.MachinePasteurizer, nodeID, data, uint32(status), sourceTimestamp)
+		o.enqueue(event{node: nodeID, value: data, timestamp: sourceTimestamp})
+	})
+}
 
-			if found && oldWork.Status == PROCESSING_STATUS_SENT {
-				log.Println("PASTEURIZER SUBSCRIPTION - START WORK")
-				work := oldWork
-				work.Status = PROCESSING_STATUS_WORK
+func (o *OpcuaService) enqueue(ev event) {
+	select {
+	case o.events <- ev:
+	case <-o.ctx.Done():
+	}
+}
 
-				tx, err := o.store.BeginTx(o.ctx)
-				if err != nil {
+// loop loads the active work, applies the notifications left in the outbox,
+// reconciles the work with the live PLC state and then handles the queued
+// events until the connection is closed. Every notification is written to
+// the outbox before it is applied and removed only once it is stored in the
+// database, so the notifications received while the database is unreachable
+// are applied, in order, when it is back.
+func (o *OpcuaService) loop() {
+	ticker := time.NewTicker(replayInterval)
+	defer ticker.Stop()
+
+	o.replay()
+
+	for {
+		select {
+		case <-o.ctx.Done():
+			return
+		case <-ticker.C:
+			o.replay()
+		case ev := <-o.events:
+			if ev.node == "" {
+				if err := o.reload(); err != nil {
 					o.log.Println(err)
-					return
 				}
-				defer tx.Rollback()
+				continue
+			}
 
-				err = o.store.UpdateWork(o.ctx, tx, work)
-				if err != nil {
+			if _, err := o.outbox.Append(ev.node, ev.value, ev.timestamp, ev.related); err != nil {
+				o.log.Println("pasteurizer outbox:", err)
+				if err := o.handle(ev); err != nil {
 					o.log.Println(err)
-					return
-				}
-
-				b, err := json.Marshal(&work)
-				if err != nil {
-					o.log.Println(err)
-					return
 				}
+				continue
+			}
+			o.replay()
+		}
+	}
+}
 
-				if err := o.io.Broadcast("pasteurizer-status-change", b); err != nil {
-					o.log.Println(err)
-					return
-				}
+// replay applies the pending notifications of the outbox in order and
+// acknowledges them one by one. It stops at the first failure, leaving the
+// rest for the next attempt, and reconciles the work with the PLC once the
+// outbox has been drained for the first time. A notification that keeps
+// failing while the database is reachable cannot be applied at all: after
+// maxAttempts it is moved to the dead-letter file, so that it does not hold
+// back the ones after it.
+func (o *OpcuaService) replay() {
+	if !o.loaded {
+		if err := o.reload(); err != nil {
+			o.log.Println(err)
+			return
+		}
+		o.loaded = true
+	}
 
-				if err := tx.Commit(); err != nil {
-					o.log.Println(err)
+	for _, rec := range o.outbox.Pending() {
+		ev := event{node: rec.Node, value: rec.Value.V, timestamp: rec.Timestamp, related: relatedValues(rec)}
+		if err := o.handle(ev); err != nil {
+			o.log.Printf("pasteurizer outbox: notification %d not applied: %v", rec.Seq, err)
+			if o.store.Ping(o.ctx) == nil && o.outbox.Failed(rec.Seq) >= maxAttempts {
+				if err := o.outbox.Discard(rec, err, time.Now()); err != nil {
+					o.log.Println("pasteurizer outbox:", err)
 					return
 				}
+				o.log.Printf("pasteurizer outbox: notification %d moved to %s", rec.Seq, o.outbox.DeadPath())
+				o.publisher.Publish(events.Alarm{Code: events.AlarmNotificationDiscarded, Severity: events.SeverityError, Active: true, Message: fmt.Sprintf("pasteurizer notification %d discarded after %d attempts: %v", rec.Seq, maxAttempts, err)})
+				continue
+			}
+			if !o.backlog {
+				o.backlog = true
+				o.publisher.Publish(events.Alarm{Code: events.AlarmNotificationBacklog, Severity: events.SeverityWarning, Active: true, Message: "pasteurizer notifications are not being stored: " + err.Error()})
 			}
+			return
 		}
-	})
-}
 
-func (o *OpcuaService) WatchBasilAmount(nodeID string, clientHandle uint32) {
+		if err := o.outbox.Ack(rec.Seq); err != nil {
+			o.log.Println("pasteurizer outbox:", err)
+			return
+		}
+	}
 
-	opcuaconn.Subscribe(o.ctx, o.c, nodeID, clientHandle, func(data interface{}) {
+	if o.backlog {
+		o.backlog = false
+		o.publisher.Publish(events.Alarm{Code: events.AlarmNotificationBacklog, Severity: events.SeverityWarning, Active: false, Message: "pasteurizer notifications stored"})
+	}
 
-		found := true
-		oldWork, err := o.store.QueryActiveWork(o.ctx)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				found = false
-			} else {
-				return
-			}
+	if !o.reconciled {
+		if err := o.apply(o.reconcile); err != nil {
+			o.log.Println("pasteurizer reconcile:", err)
+			return
 		}
+		o.reconciled = true
+	}
+}
 
-		if found && oldWork.Status == PROCESSING_STATUS_WORK {
-			log.Println("SPINDRYER SUBSCRIPTION - START UPDATE BASIL AMOUNT")
+// relatedValues returns the related values of the record as an event takes them.
+func relatedValues(rec outbox.Record) map[string]interface{} {
+	if len(rec.Related) == 0 {
+		return nil
+	}
 
-			currentBasilAmount, _ := data.(int64)
+	related := make(map[string]interface{}, len(rec.Related))
+	for n, v := range rec.Related {
+		related[n] = v.V
+	}
+	return related
+}
 
-			work := oldWork
+func (o *OpcuaService) handle(ev event) error {
+	switch ev.node {
+	case nodeOrderConf:
+		return o.onOrderConf(ev)
+	case nodeBasilAmount:
+		return o.onBasilAmount(ev)
+	case nodeBasilPackages:
+		return o.onBasilPackages(ev)
+	case nodeEndWork:
+		return o.onEndWork(ev)
+	}
+	return nil
+}
 
-			tx, err := o.store.BeginTx(o.ctx)
-			if err != nil {
-				o.log.Println(err)
-				return
-			}
-			defer tx.Rollback()
+// reconcile compares the active work with the live PLC state and applies the
+// transitions and quantity updates missed while the service was not
+// subscribed, e.g. because the backend was restarted during a lot. The
+// confirmation and end bits stay high until the PLC clears them, so a bit
+// is only taken for the work when the PLC set it after the lot was sent to
+// the machine, or after the work started; a bit set earlier belongs to the
+// previous lot. A server not reporting when the bits changed gives its own
+// timestamp or the time of the read, see opcuaconn.ReadValue, and the bits
+// are then taken as they are. The work is then published as recovered, so
+// that the operators know that its times come from the PLC state read at
+// restart.
+func (o *OpcuaService) reconcile(work Work) (Work, error) {
+	orderConf, confirmed, err := opcuaconn.ReadValue(o.ctx, o.c, nodeOrderConf)
+	if err != nil {
+		return Work{}, err
+	}
 
-			work.BasilAmount = int(currentBasilAmount)
-			log.Println("current basil amount:", currentBasilAmount)
-			// TODO: TEMPORARY
-			if currentBasilAmount == 0 {
-				work.BasilAmount = 400
-			}
+	endWork, ended, err := opcuaconn.ReadValue(o.ctx, o.c, nodeEndWork)
+	if err != nil {
+		return Work{}, err
+	}
 
-			// var packages uint16
-			// newPackages, err := opcuaconn.Read(o.ctx, o.c, "ns=2;s=Siemens S7-1200/S7-1500.Tags.Send.Numero_Di_Imballi")
-			// if err != nil {
-			// 	o.log.Println(err)
-			// }
+	basilAmount, err := opcuaconn.Read(o.ctx, o.c, nodeBasilAmount)
+	if err != nil {
+		return Work{}, err
+	}
+
+	basilPackages, err := opcuaconn.Read(o.ctx, o.c, nodeBasilPackages)
+	if err != nil {
+		return Work{}, err
+	}
 
-			// packages, _ = newPackages.(uint16)
-			// log.Println("basil packages:", packages)
-			// work.Packages = int(packages)
-			// // TODO: TEMPORARY
-			// work.Packages = 2
+	recovered := false
 
-			err = o.store.UpdateWork(o.ctx, tx, work)
+	if bit, _ := orderConf.(bool); bit && work.Status == PROCESSING_STATUS_SENT {
+		if confirmed.After(work.Created) {
+			work, err = o.startWork(work, confirmed)
 			if err != nil {
-				o.log.Println(err)
-				return
+				return Work{}, err
 			}
+			recovered = true
+			o.log.Printf("PASTEURIZER RECOVERED - START WORK %d", work.ID)
+		} else {
+			o.log.Printf("PASTEURIZER RECONCILE - work %d: order confirmation of %v predates the lot, ignored", work.ID, confirmed)
+		}
+	}
 
-			if err := tx.Commit(); err != nil {
-				o.log.Println(err)
-				return
-			}
-			log.Println("SPINDRYER SUBSCRIPTION - END UPDATE BASIL AMOUNT")
+	if work.Status != PROCESSING_STATUS_WORK {
+		return work, nil
+	}
+
+	if amount, ok := basilAmount.(int64); ok && int(amount) != work.BasilAmount {
+		work, err = o.updateBasilAmount(work, amount, events.SourceReconcile)
+		if err != nil {
+			return Work{}, err
+		}
+		recovered = true
+		o.log.Printf("PASTEURIZER RECOVERED - UPDATE BASIL AMOUNT %d: %d", work.ID, work.BasilAmount)
+	}
+
+	if packages, ok := basilPackages.(uint16); ok && int(packages) != work.Packages {
+		work, err = o.updatePackages(work, packages, events.SourceReconcile)
+		if err != nil {
+			return Work{}, err
+		}
+		recovered = true
+		o.log.Printf("PASTEURIZER RECOVERED - UPDATE BASIL PACKAGE %d: %d", work.ID, work.Packages)
+	}
+
+	if bit, _ := endWork.(bool); bit && work.StartedAt != nil && ended.After(*work.StartedAt) {
+		work, err = o.endWork(work, ended)
+		if err != nil {
+			return Work{}, err
+		}
+		recovered = true
+		o.log.Printf("PASTEURIZER RECOVERED - END WORK %d", work.ID)
+	}
+
+	if recovered {
+		o.publisher.Publish(workUpdated(work, events.ChangeRecovered))
+	}
+
+	return work, nil
+}
+
+func (o *OpcuaService) onOrderConf(ev event) error {
+	if bit, _ := ev.value.(bool); !bit {
+		return nil
+	}
+
+	return o.apply(func(work Work) (Work, error) {
+		if work.Status != PROCESSING_STATUS_SENT {
+			return work, nil
+		}
+
+		log.Println("PASTEURIZER SUBSCRIPTION - START WORK")
+		return o.startWork(work, ev.timestamp)
+	})
+}
+
+func (o *OpcuaService) onBasilAmount(ev event) error {
+	currentBasilAmount, _ := ev.value.(int64)
+
+	return o.apply(func(work Work) (Work, error) {
+		if work.Status != PROCESSING_STATUS_WORK {
+			return work, nil
 		}
+
+		log.Println("PASTEURIZER SUBSCRIPTION - START UPDATE BASIL AMOUNT")
+		log.Println("current basil amount:", currentBasilAmount)
+		work, err := o.updateBasilAmount(work, currentBasilAmount, events.SourcePLC)
+		if err != nil {
+			return Work{}, err
+		}
+		log.Println("PASTEURIZER SUBSCRIPTION - END UPDATE BASIL AMOUNT")
+		return work, nil
 	})
 }
 
-func (o *OpcuaService) WatchBasilPackages(nodeID string, clientHandle uint32) {
+func (o *OpcuaService) onBasilPackages(ev event) error {
+	currentBasilPackages, _ := ev.value.(uint16)
 
-	opcuaconn.Subscribe(o.ctx, o.c, nodeID, clientHandle, func(data interface{}) {
+	return o.apply(func(work Work) (Work, error) {
+		if work.Status != PROCESSING_STATUS_WORK {
+			return work, nil
+		}
 
-		found := true
-		oldWork, err := o.store.QueryActiveWork(o.ctx)
+		log.Println("PASTEURIZER SUBSCRIPTION - START UPDATE BASIL PACKAGE")
+		log.Println("basil packages:", currentBasilPackages)
+		work, err := o.updatePackages(work, currentBasilPackages, events.SourcePLC)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				found = false
-			} else {
-				return
-			}
+			return Work{}, err
 		}
+		log.Println("PASTEURIZER SUBSCRIPTION - END UPDATE BASIL PACKAGE")
+		return work, nil
+	})
+}
 
-		if found && oldWork.Status == PROCESSING_STATUS_WORK {
-			log.Println("SPINDRYER SUBSCRIPTION - START UPDATE BASIL PACKAGE")
+func (o *OpcuaService) onEndWork(ev event) error {
+	if bit, _ := ev.value.(bool); !bit {
+		return nil
+	}
 
-			currentBasilPackages, _ := data.(uint16)
+	return o.apply(func(work Work) (Work, error) {
+		if work.Status != PROCESSING_STATUS_WORK {
+			return work, nil
+		}
 
-			work := oldWork
+		work, err := o.endWork(work, ev.timestamp)
+		if err != nil {
+			return Work{}, err
+		}
+		log.Println("PASTEURIZER SUBSCRIPTION - END WORK")
+		return work, nil
+	})
+}
 
-			tx, err := o.store.BeginTx(o.ctx)
-			if err != nil {
-				o.log.Println(err)
-				return
+// apply runs fn on the in-memory active work, if there is one, and keeps the
+// result. Without an in-memory work the active one is reloaded first: a work
+// inserted through the REST API reaches the loop with the refresh queued
+// after its commit, and the PLC may confirm the lot before that. When fn
+// loses an optimistic concurrency check the work is reloaded from the
+// database and fn runs again.
+func (o *OpcuaService) apply(fn func(work Work) (Work, error)) error {
+	return retryOnConflict(func() error {
+		if o.work == nil {
+			if err := o.reload(); err != nil {
+				return err
 			}
-			defer tx.Rollback()
-
-			// work.BasilAmount = int(currentBasilAmount)
-			// log.Println("current basil amount:", currentBasilAmount)
-			// // TODO: TEMPORARY
-			// if currentBasilAmount == 0 {
-			// 	work.BasilAmount = 400
-			// }
-
-			// var packages uint16
-			// newPackages, err := opcuaconn.Read(o.ctx, o.c, "ns=2;s=Siemens S7-1200/S7-1500.Tags.Send.Numero_Di_Imballi")
-			// if err != nil {
-			// 	o.log.Println(err)
-			// }
-
-			// packages, _ = newPackages.(uint16)
-			work.Packages = int(currentBasilPackages)
-			log.Println("basil packages:", currentBasilPackages)
-			if work.Packages == 0 {
-				work.Packages = 2
-			}
-
-			err = o.store.UpdateWork(o.ctx, tx, work)
-			if err != nil {
-				o.log.Println(err)
-				return
+			if o.work == nil {
+				return nil
 			}
+		}
 
-			if err := tx.Commit(); err != nil {
-				o.log.Println(err)
-				return
+		work, err := fn(*o.work)
+		if err != nil {
+			if errors.Is(err, ErrConflict) {
+				if err := o.reload(); err != nil {
+					return err
+				}
 			}
-			log.Println("SPINDRYER SUBSCRIPTION - END UPDATE BASIL PACKAGE")
+			return err
 		}
+
+		o.setWork(work)
+		return nil
 	})
 }
 
-func (o *OpcuaService) WatchEndWork(nodeID string, clientHandle uint32) {
+// reload replaces the in-memory active work with the one stored in the database.
+func (o *OpcuaService) reload() error {
+	work, err := o.store.QueryActiveWork(o.ctx)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			o.work = nil
+			return nil
+		}
+		return err
+	}
 
-	opcuaconn.Subscribe(o.ctx, o.c, nodeID, clientHandle, func(data interface{}) {
-		bit, _ := data.(bool)
-		if bit {
-			found := true
-			oldWork, err := o.store.QueryActiveWork(o.ctx)
-			if err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					found = false
-				} else {
-					return
-				}
-				// o.log.Println(err)
-			}
+	o.setWork(work)
+	return nil
+}
 
-			if found && oldWork.Status == PROCESSING_STATUS_WORK {
-				work := oldWork
-				work.Status = PROCESSING_STATUS_DONE
+func (o *OpcuaService) setWork(work Work) {
+	if work.Status == PROCESSING_STATUS_DONE {
+		o.work = nil
+		return
+	}
+	o.work = &work
+}
 
-				tx, err := o.store.BeginTx(o.ctx)
-				if err != nil {
-					o.log.Println(err)
-					return
-				}
-				defer tx.Rollback()
-
-				// var basilAmount int64
-				// newBasilAmount, err := opcuaconn.Read(o.ctx, o.c, "ns=2;s=Siemens S7-1200/S7-1500.Tags.Send.Quantità_Basilico_Lavorato")
-				// if err != nil {
-				// 	o.log.Println(err)
-				// }
-
-				// // TODO um
-				// basilAmount, _ = newBasilAmount.(int64)
-				// log.Println("basil amount:", basilAmount)
-				// work.BasilAmount = int(basilAmount)
-				// // TODO: TEMPORARY
-				// work.BasilAmount = 400
-
-				// var packages uint16
-				// newPackages, err := opcuaconn.Read(o.ctx, o.c, "ns=2;s=Siemens S7-1200/S7-1500.Tags.Send.Numero_Di_Imballi")
-				// if err != nil {
-				// 	o.log.Println(err)
-				// }
-
-				// packages, _ = newPackages.(uint16)
-				// log.Println("basil packages:", packages)
-				// work.Packages = int(packages)
-				// // TODO: TEMPORARY
-				// work.Packages = 2
-
-				err = o.store.UpdateWork(o.ctx, tx, work)
-				if err != nil {
-					o.log.Println(err)
-					return
-				}
+// startWork moves a sent work to work once the PLC has confirmed the lot.
+func (o *OpcuaService) startWork(work Work, now time.Time) (Work, error) {
+	work.Status = PROCESSING_STATUS_WORK
+	started := now.UTC()
+	work.StartedAt = &started
 
-				b, err := json.Marshal(&work)
-				if err != nil {
-					o.log.Println(err)
-					return
-				}
+	work, err := saveWork(o.ctx, o.store, work, o.store.UpdateWorkStart)
+	if err != nil {
+		return Work{}, err
+	}
 
-				if err := o.io.Broadcast("pasteurizer-status-change", b); err != nil {
-					o.log.Println(err)
-					return
-				}
+	o.publisher.Publish(statusChanged(work, PROCESSING_STATUS_SENT))
+	o.publisher.Legacy("pasteurizer-status-change", work)
+	return work, nil
+}
 
-				if err := tx.Commit(); err != nil {
-					o.log.Println(err)
-					return
-				}
-				log.Println("PASTEURIZER SUBSCRIPTION - END WORK")
+func (o *OpcuaService) updateBasilAmount(work Work, basilAmount int64, source string) (Work, error) {
+	previous := work.BasilAmount
+	work.BasilAmount = int(basilAmount)
 
-			}
+	work, err := saveWork(o.ctx, o.store, work, o.store.UpdateBasilAmount)
+	if err != nil {
+		return Work{}, err
+	}
+
+	o.publisher.Publish(quantityChanged(work, "basil_amount", previous, work.BasilAmount, source))
+	return work, nil
+}
+
+func (o *OpcuaService) updatePackages(work Work, packages uint16, source string) (Work, error) {
+	previous := work.Packages
+	work.Packages = int(packages)
+
+	work, err := saveWork(o.ctx, o.store, work, o.store.UpdatePackages)
+	if err != nil {
+		return Work{}, err
+	}
 
+	o.publisher.Publish(quantityChanged(work, "packages", previous, work.Packages, source))
+	return work, nil
+}
+
+func (o *OpcuaService) endWork(work Work, now time.Time) (Work, error) {
+	work.Status = PROCESSING_STATUS_DONE
+	work.End(now)
+
+	tx, err := o.store.BeginTx(o.ctx)
+	if err != nil {
+		return Work{}, err
+	}
+
+	defer tx.Rollback()
+
+	version, err := o.store.UpdateWorkEnd(o.ctx, tx, work)
+	if err != nil {
+		return Work{}, err
+	}
+
+	// The lot is produced when the work ends.
+	if err := o.arca.UpdateLotDates(o.ctx, tx, o.lot, arca.NewLot{CdAr: work.CdAr, CdLotto: work.CdLotto, ProductionDate: now}, now); err != nil {
+		return Work{}, err
+	}
+
+	if work.OrderID != nil {
+		if err := o.arca.AddOrderProgress(o.ctx, tx, *work.OrderID, float64(work.BasilAmount), o.orderUnit, now); err != nil {
+			return Work{}, err
 		}
-	})
+	}
+
+	consumed, err := consumedMovements(o.ctx, o.genealogy, work)
+	if err != nil {
+		return Work{}, err
+	}
+
+	if err := o.stock.Post(o.ctx, tx, work.ID, producedMovement(work), consumed, now); err != nil {
+		return Work{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Work{}, err
+	}
+	work.Version = version
+
+	o.publisher.Publish(statusChanged(work, PROCESSING_STATUS_WORK))
+	o.publisher.Legacy("pasteurizer-status-change", work)
+	return work, nil
 }
@@ -2,51 +2,88 @@ package pasteurizer
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/arca"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/events"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/genealogy"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/lotcode"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/sample"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/stock"
 	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/opcuaconn"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/outbox"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/page"
 	"github.com/devsamuele/service-kit/web"
-	"github.com/devsamuele/service-kit/ws"
 	"github.com/gopcua/opcua"
-	"github.com/gopcua/opcua/ua"
 )
 
 type Service struct {
-	store    Store
-	client   *opcua.Client
-	io       *ws.EventEmitter
-	log      *log.Logger
-	shutdown chan os.Signal
+	store     Storer
+	arca      arca.Storer
+	document  arca.DocumentConfig
+	lot       arca.LotConfig
+	lots      *lotcode.Service
+	genealogy genealogy.Storer
+	outbox    *outbox.Journal
+	stock     stock.Service
+	samples   *sample.Recorder
+	opcuaCfg  opcuaconn.Config
+	client    *opcua.Client
+	opcua     *OpcuaService
+	events    events.Publisher
+	log       *log.Logger
+	shutdown  chan os.Signal
+
+	// closing tells a disconnection asked through OpcuaDisconnect from a
+	// lost connection.
+	closing bool
 }
 
-func NewService(store Store, shutdown chan os.Signal, log *log.Logger, io *ws.EventEmitter) *Service {
+func NewService(store Storer, arcaStore arca.Storer, document arca.DocumentConfig, lot arca.LotConfig, lots *lotcode.Service, genealogyStore genealogy.Storer, journal *outbox.Journal, stockService stock.Service, samples *sample.Recorder, opcuaCfg opcuaconn.Config, shutdown chan os.Signal, log *log.Logger, stream *events.Stream) *Service {
 	return &Service{
-		store:    store,
-		io:       io,
-		log:      log,
-		shutdown: shutdown,
+		store:     store,
+		arca:      arcaStore,
+		document:  document,
+		lot:       lot,
+		lots:      lots,
+		genealogy: genealogyStore,
+		outbox:    journal,
+		stock:     stockService,
+		samples:   samples,
+		opcuaCfg:  opcuaCfg,
+		events:    events.NewPublisher(stream, lotcode.MachinePasteurizer, log),
+		log:       log,
+		shutdown:  shutdown,
 	}
 }
 
-// Pasteurizer "opc.tcp://192.168.1.181:4840"
-
 func (s *Service) OpcuaConnect(ctx context.Context) error {
-	// pasteurizerClient := opcua.NewClient("opc.tcp://192.168.1.181:4840", opcua.SecurityMode(ua.MessageSecurityModeNone), opcua.DialTimeout(time.Second*10))
-	pasteurizerClient := opcua.NewClient("opc.tcp://192.168.1.181:4840", opcua.SecurityMode(ua.MessageSecurityModeNone), opcua.DialTimeout(time.Second*10))
+	pasteurizerClient := opcuaconn.NewClient(s.opcuaCfg)
 	if err := pasteurizerClient.Connect(ctx); err != nil {
 		return web.NewError("pasteurizer not connected", web.ErrReasonInternalError, "", "")
 	}
 
+	if err := s.resumeActiveWork(ctx, time.Now()); err != nil {
+		s.log.Println(err)
+	}
+
+	s.events.Publish(events.ConnectionChanged{Connected: true, Endpoint: s.opcuaCfg.Endpoint})
+	s.events.Publish(events.Alarm{Code: events.AlarmConnectionLost, Severity: events.SeverityError, Active: false, Message: "pasteurizer connected"})
+
 	_ctx, cancel := context.WithCancel(context.Background())
 
 	s.client = pasteurizerClient
-	opcuaService := NewOpcuaService(_ctx, s.log, s.client, s.store, s.io)
+	s.closing = false
+	opcuaService := NewOpcuaService(_ctx, s.log, s.client, s.store, s.arca, s.lot, s.document.OrderUnit, s.genealogy, s.outbox, s.stock, s.samples, s.events)
 	opcuaService.Run()
+	s.opcua = opcuaService
 
 	go func() {
 		defer func() {
@@ -57,8 +94,14 @@ func (s *Service) OpcuaConnect(ctx context.Context) error {
 			if s.client.State() != opcua.Connected {
 				s.client.CloseWithContext(_ctx)
 
-				if err := s.io.Broadcast("pasteurizer-client-closed", nil); err != nil {
-					log.Println(err)
+				if err := s.pauseActiveWork(_ctx, time.Now()); err != nil {
+					s.log.Println(err)
+				}
+
+				s.events.Publish(events.ConnectionChanged{Connected: false, Endpoint: s.opcuaCfg.Endpoint})
+				s.events.Legacy("pasteurizer-client-closed", nil)
+				if !s.closing {
+					s.events.Publish(events.Alarm{Code: events.AlarmConnectionLost, Severity: events.SeverityError, Active: true, Message: "pasteurizer connection lost"})
 				}
 				return
 			}
@@ -70,6 +113,7 @@ func (s *Service) OpcuaConnect(ctx context.Context) error {
 
 func (s *Service) OpcuaDisconnect(ctx context.Context) error {
 	if s.client != nil {
+		s.closing = true
 		if err := s.client.CloseWithContext(ctx); err != nil {
 			log.Println("closing pasteurizer:", err)
 		}
@@ -78,12 +122,108 @@ func (s *Service) OpcuaDisconnect(ctx context.Context) error {
 	return nil
 }
 
-func (s Service) QueryWork(ctx context.Context) ([]Work, error) {
-	works, err := s.store.QueryWork(ctx)
+const (
+	defaultWorkLimit = 50
+	maxWorkLimit     = 500
+)
+
+// workSortFields are the fields the works can be sorted by.
+var workSortFields = []page.Field{
+	{Name: "id", Column: "id", Kind: page.Int},
+	{Name: "date", Column: "date", Kind: page.Time},
+	{Name: "created", Column: "created", Kind: page.Time},
+	{Name: "cd_lotto", Column: "cd_lotto", Kind: page.String},
+	{Name: "cd_ar", Column: "cd_ar", Kind: page.String},
+	{Name: "status", Column: "status", Kind: page.String},
+}
+
+// archiveSortFields are the fields the archived works can be sorted by.
+var archiveSortFields = append([]page.Field{{Name: "deleted_at", Column: "deleted_at", Kind: page.Time}}, workSortFields...)
+
+// QueryWork returns a page of the works matching the query, the most recent
+// first unless sorted otherwise, and the token of the next page, empty on
+// the last one. Archived works are left out.
+func (s Service) QueryWork(ctx context.Context, q WorkQuery) ([]Work, string, error) {
+	return s.queryWork(ctx, q, false, workSortFields, "-date")
+}
+
+// QueryArchive returns a page of the archived works matching the query, the
+// last deleted first unless sorted otherwise, as QueryWork.
+func (s Service) QueryArchive(ctx context.Context, q WorkQuery) ([]Work, string, error) {
+	return s.queryWork(ctx, q, true, archiveSortFields, "-deleted_at")
+}
+
+func (s Service) queryWork(ctx context.Context, q WorkQuery, deleted bool, fields []page.Field, def string) ([]Work, string, error) {
+	f, err := q.Filter()
+	if err != nil {
+		return make([]Work, 0), "", err
+	}
+	f.Deleted = deleted
+
+	orders, err := page.ParseSort(q.Sort, fields, def)
+	if err != nil {
+		return make([]Work, 0), "", web.NewError(err.Error(), web.ErrReasonInvalidParameter, "parameter", "sort")
+	}
+
+	limit := defaultWorkLimit
+	if q.Limit != "" {
+		limit, err = strconv.Atoi(q.Limit)
+		if err != nil || limit < 1 || limit > maxWorkLimit {
+			return make([]Work, 0), "", web.NewError(fmt.Sprintf("limit must be between 1 and %d", maxWorkLimit), web.ErrReasonInvalidParameter, "parameter", "limit")
+		}
+	}
+
+	var after []interface{}
+	if q.PageToken != "" {
+		after, err = page.ParseToken(q.PageToken, orders)
+		if err != nil {
+			return make([]Work, 0), "", web.NewError(err.Error(), web.ErrReasonInvalidParameter, "parameter", "page_token")
+		}
+	}
+
+	// One more work than the page tells whether a next page exists.
+	works, err := s.store.QueryWork(ctx, f, orders, after, limit+1)
 	if err != nil {
-		return make([]Work, 0), err
+		return make([]Work, 0), "", err
 	}
-	return works, nil
+
+	if len(works) <= limit {
+		return works, "", nil
+	}
+
+	works = works[:limit]
+	token, err := page.Token(orders, sortValues(works[limit-1], orders))
+	if err != nil {
+		return make([]Work, 0), "", err
+	}
+
+	return works, token, nil
+}
+
+// sortValues returns the values of the work for the orders.
+func sortValues(w Work, orders []page.Order) []interface{} {
+	values := make([]interface{}, len(orders))
+	for i, o := range orders {
+		switch o.Field.Name {
+		case "id":
+			values[i] = w.ID
+		case "date":
+			values[i] = w.Date
+		case "created":
+			values[i] = w.Created
+		case "cd_lotto":
+			values[i] = w.CdLotto
+		case "cd_ar":
+			values[i] = w.CdAr
+		case "status":
+			values[i] = w.Status
+		case "deleted_at":
+			if w.DeletedAt != nil {
+				values[i] = *w.DeletedAt
+			}
+		}
+	}
+	return values
 }
 
 func (s Service) InsertWork(ctx context.Context, nw NewWork, now time.Time) (Work, error) {
@@ -96,6 +236,22 @@ func (s Service) InsertWork(ctx context.Context, nw NewWork, now time.Time) (Wor
 		return Work{}, err
 	}
 
+	if nw.OrderID != nil {
+		order, err := arca.ValidateOrder(ctx, s.arca, s.document.OrderType, s.document.OrderUnit, *nw.OrderID)
+		if err != nil {
+			return Work{}, err
+		}
+
+		if nw.CdAr != nil && *nw.CdAr != "" && *nw.CdAr != order.CdAr {
+			return Work{}, web.NewError(fmt.Sprintf("cd_ar differs from the article %s of the order", order.CdAr), web.ErrReasonInvalidArgument, "argument", "cd_ar")
+		}
+		nw.CdAr = &order.CdAr
+	}
+
+	if err := arca.ValidateArticle(ctx, s.arca, *nw.CdAr); err != nil {
+		return Work{}, err
+	}
+
 	exist, err := s.store.ExistActiveWork(ctx)
 	if err != nil {
 		return Work{}, err
@@ -106,12 +262,12 @@ func (s Service) InsertWork(ctx context.Context, nw NewWork, now time.Time) (Wor
 	}
 
 	w := Work{
-		CdLotto:         *nw.CdLotto,
 		CdAr:            *nw.CdAr,
+		OrderID:         nw.OrderID,
 		DocumentCreated: false,
-		Date:            now,
+		Date:            now.UTC(),
 		Status:          PROCESSING_STATUS_SENT,
-		Created:         now,
+		Created:         now.UTC(),
 	}
 
 	tx, err := s.store.BeginTx(ctx)
@@ -121,12 +277,35 @@ func (s Service) InsertWork(ctx context.Context, nw NewWork, now time.Time) (Wor
 
 	defer tx.Rollback()
 
+	if nw.CdLotto != nil && strings.TrimSpace(*nw.CdLotto) != "" {
+		w.CdLotto = *nw.CdLotto
+
+		// The lot of an archived work stays taken: it was sent to the machine.
+		used, err := s.store.CheckLottoAndArInWork(ctx, tx, w.CdLotto, w.CdAr)
+		if err != nil {
+			return Work{}, err
+		}
+		if used {
+			return Work{}, web.NewError(fmt.Sprintf("lot %s already used by a work of the article, archived ones included", w.CdLotto), web.ErrReasonConflict, "argument", "cd_lotto")
+		}
+
+		// The code may have been reserved through POST /lots/next.
+		if err := s.lots.Release(ctx, tx, w.CdLotto); err != nil {
+			return Work{}, err
+		}
+	} else {
+		w.CdLotto, err = s.lots.Next(ctx, tx, lotcode.MachinePasteurizer, w.CdAr, now)
+		if err != nil {
+			return Work{}, err
+		}
+	}
+
 	found, err := s.store.CheckLottoAndAr(ctx, tx, w.CdLotto, w.CdAr)
 	if err != nil {
 		return Work{}, err
 	}
 	if !found {
-		err = s.store.CreateLottoArca(ctx, tx, w.CdLotto, w.CdAr, now)
+		err = s.arca.CreateLot(ctx, tx, s.lot, arca.NewLot{CdAr: w.CdAr, CdLotto: w.CdLotto, ProductionDate: now}, now)
 		if err != nil {
 			return Work{}, err
 		}
@@ -138,15 +317,19 @@ func (s Service) InsertWork(ctx context.Context, nw NewWork, now time.Time) (Wor
 	}
 	w.ID = id
 
+	if err := genealogy.LinkParents(ctx, tx, s.genealogy, w.ID, nw.Parents, now); err != nil {
+		return Work{}, err
+	}
+
 	// _, err = opcuaconn.Write(ctx, s.client, "ns=8;s=Siemens S7-1200/S7-1500.Tags.Receive.Numero_Lotto", w.CdLotto)
-	_, err = opcuaconn.Write(ctx, s.client, "ns=2;s=Siemens S7-1200/S7-1500.Tags.Receive.Numero_Lotto", w.CdLotto)
+	_, err = opcuaconn.Write(ctx, s.client, nodeLotNumber, w.CdLotto)
 	if err != nil {
 		return Work{}, err
 	}
 
 	var bit bool = true
 	// _, err = opcuaconn.Write(ctx, s.client, "ns=8;s=Siemens S7-1200/S7-1500.Tags.Receive.Bit_Nuovo_Lotto", bit)
-	_, err = opcuaconn.Write(ctx, s.client, "ns=2;s=Siemens S7-1200/S7-1500.Tags.Receive.Bit_Nuovo_Lotto", bit)
+	_, err = opcuaconn.Write(ctx, s.client, nodeNewLotBit, bit)
 	if err != nil {
 		return Work{}, err
 	}
@@ -154,10 +337,96 @@ func (s Service) InsertWork(ctx context.Context, nw NewWork, now time.Time) (Wor
 	if err := tx.Commit(); err != nil {
 		return Work{}, err
 	}
+	s.refresh()
+	s.events.Publish(workCreated(w))
 
 	return w, nil
 }
 
+// SyncDocuments marks document_created, with the document number and date,
+// the works linked to an Arca document through DoTes.xId_Pastorizzatore, whether the
+// document was created by the service or directly in Arca, and clears it on
+// the works whose document was deleted in Arca.
+func (s Service) SyncDocuments(ctx context.Context) error {
+	links, err := s.store.QueryUnsyncedDocuments(ctx)
+	if err != nil {
+		return err
+	}
+
+	works := make([]Work, 0)
+	for _, l := range links {
+		l := l
+		var w Work
+		err := retryOnConflict(func() error {
+			var err error
+			w, err = s.store.QueryWorkByID(ctx, l.WorkID)
+			if err != nil {
+				return err
+			}
+
+			w.DocumentCreated = l.Number != nil
+			w.DocumentNumber = l.Number
+			w.DocumentDate = l.Date
+			w, err = saveWork(ctx, s.store, w, s.updateDocument)
+			return err
+		})
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return err
+		}
+		works = append(works, w)
+	}
+
+	for _, w := range works {
+		change := events.ChangeDocumentCreated
+		if !w.DocumentCreated {
+			change = events.ChangeDocumentRemoved
+		}
+		s.events.Publish(workUpdated(w, change))
+	}
+	if len(works) > 0 {
+		s.events.Legacy("pasteurizer-created-documents", works)
+	}
+
+	return nil
+}
+
+// updateDocument saves the document of a synced work and moves its stock
+// accordingly: the document loads the produced lot, so the load posted for
+// the work is reversed, and it is posted again when the document is deleted.
+func (s Service) updateDocument(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	version, err := s.store.UpdateDocument(ctx, tx, w)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if w.DocumentCreated {
+		return version, s.stock.ReverseLoads(ctx, tx, w.ID, now)
+	}
+
+	if w.DeletedAt != nil || w.Status != PROCESSING_STATUS_DONE {
+		return version, nil
+	}
+
+	consumed, err := consumedMovements(ctx, s.genealogy, w)
+	if err != nil {
+		return nil, err
+	}
+	return version, s.stock.Repost(ctx, tx, w.ID, producedMovement(w), consumed, now)
+}
+
+// QueryOrders returns the open production order lines of the machine.
+func (s Service) QueryOrders(ctx context.Context) ([]arca.Order, error) {
+	orders, err := s.arca.QueryOpenOrders(ctx, s.document.OrderType, s.document.OrderUnit)
+	if err != nil {
+		return make([]arca.Order, 0), err
+	}
+	return orders, nil
+}
+
 func (s Service) GetOpcuaConnection(ctx context.Context) OpcuaConnection {
 
 	if s.client != nil && s.client.State() == opcua.Connected {
@@ -171,32 +440,109 @@ func (s Service) GetOpcuaConnection(ctx context.Context) OpcuaConnection {
 	}
 }
 
-func (s Service) SetCreatedDocument(ctx context.Context, ids []ID) error {
+// CreateDocuments creates in Arca the production document of each completed
+// work, links it to the work and marks the work document_created, all in a
+// single transaction.
+func (s Service) CreateDocuments(ctx context.Context, ids []ID, now time.Time) error {
+	if len(ids) == 0 {
+		return web.NewError("at least one id is required", web.ErrReasonRequired, "argument", "id")
+	}
 
+	tx, err := s.store.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	seen := make(map[int]bool)
 	works := make([]Work, 0)
 	for _, id := range ids {
+		if seen[id.ID] {
+			continue
+		}
+		seen[id.ID] = true
+
 		w, err := s.store.QueryWorkByID(ctx, id.ID)
 		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return web.NewError(fmt.Sprintf("work %d not found", id.ID), web.ErrReasonNotFound, "argument", "id")
+			}
+			return err
+		}
+
+		if w.DeletedAt != nil {
+			return web.NewError(fmt.Sprintf("work %d is archived", w.ID), web.ErrReasonConflict, "argument", "id")
+		}
+
+		if w.Status != PROCESSING_STATUS_DONE {
+			return web.NewError(fmt.Sprintf("work %d is not completed", w.ID), web.ErrReasonConflict, "argument", "id")
+		}
+
+		if w.DocumentCreated {
+			return web.NewError(fmt.Sprintf("document already created for work %d", w.ID), web.ErrReasonConflict, "argument", "id")
+		}
+
+		// The document quantity is the basil pasteurized, in kilograms.
+		doc, err := s.arca.CreateDocument(ctx, tx, s.document, arca.NewDocument{
+			CdAr:     w.CdAr,
+			CdLotto:  w.CdLotto,
+			Quantity: float64(w.BasilAmount),
+			Date:     now,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := s.arca.LinkDocument(ctx, tx, arca.LinkPasteurizer, doc.ID, w.ID); err != nil {
 			return err
 		}
+
+		// The document loads the produced lot: a load posted for the work
+		// before the documents did is reversed, not to count the lot twice.
+		if err := s.stock.ReverseLoads(ctx, tx, w.ID, now); err != nil {
+			return err
+		}
+
+		w.DocumentCreated = true
+		w.DocumentNumber = &doc.Number
+		w.DocumentDate = &doc.Date
+		version, err := s.store.UpdateDocument(ctx, tx, w)
+		if err != nil {
+			if errors.Is(err, ErrConflict) {
+				return web.NewError(fmt.Sprintf("work %d changed concurrently, retry", w.ID), web.ErrReasonConflict, "argument", "id")
+			}
+			return err
+		}
+		w.Version = version
+
 		works = append(works, w)
 	}
 
-	b, err := json.Marshal(&works)
-	if err != nil {
+	if err := tx.Commit(); err != nil {
 		return err
 	}
 
-	if err := s.io.Broadcast("pasteurizer-created-documents", b); err != nil {
-		return err
+	for _, w := range works {
+		s.events.Publish(workUpdated(w, events.ChangeDocumentCreated))
 	}
+	s.events.Legacy("pasteurizer-created-documents", works)
 
 	return nil
 }
 
-func (s Service) DeleteWork(ctx context.Context, id string) error {
+// DeleteWork archives a work whose document has not been created yet,
+// recording the user, the time and the reason. Its order progress and stock
+// movements are reversed and its lot is removed from Arca unless a document
+// uses it, as if the work never ran, but the work and its genealogy are kept
+// until the archive retention purges them.
+func (s Service) DeleteWork(ctx context.Context, id string, dw DeleteWork, user string, now time.Time) error {
 	_id, err := strconv.Atoi(id)
 	if err != nil {
+		return web.NewError("invalid id", web.ErrReasonInvalidParameter, "parameter", "id")
+	}
+
+	if err := dw.Validate(); err != nil {
 		return err
 	}
 
@@ -207,19 +553,48 @@ func (s Service) DeleteWork(ctx context.Context, id string) error {
 
 	defer tx.Rollback()
 
-	w, err := s.store.QueryWorkByID(ctx, _id)
+	w, err := s.store.QueryWorkByIDTx(ctx, tx, _id)
 	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return web.NewError("work not found", web.ErrReasonNotFound, "parameter", "id")
+		}
 		return err
 	}
 
+	if w.DeletedAt != nil {
+		return web.NewError("work already archived", web.ErrReasonConflict, "parameter", "id")
+	}
+
+	if w.DocumentCreated {
+		return web.NewError("unable to delete a work whose document has been created", web.ErrReasonConflict, "parameter", "id")
+	}
+
 	// if w.Status != "send" {
 	// 	return errors.New("unable to delete already sent work")
 	// }
 
-	err = s.store.DeleteWork(ctx, tx, _id)
+	if w.OrderID != nil && w.Status == PROCESSING_STATUS_DONE {
+		if err := s.arca.AddOrderProgress(ctx, tx, *w.OrderID, -float64(w.BasilAmount), s.document.OrderUnit, now); err != nil {
+			return err
+		}
+	}
+
+	if err := s.stock.Reverse(ctx, tx, w.ID, now); err != nil {
+		return err
+	}
+
+	deleted := now.UTC()
+	w.DeletedAt = &deleted
+	w.DeletedBy = &user
+	w.DeleteReason = dw.Reason
+	version, err := s.store.UpdateDeleted(ctx, tx, w)
 	if err != nil {
+		if errors.Is(err, ErrConflict) {
+			return web.NewError("work changed concurrently, retry", web.ErrReasonConflict, "", "")
+		}
 		return err
 	}
+	w.Version = version
 
 	found, err := s.store.CheckLottoAndArInDoc(ctx, tx, w.CdLotto, w.CdAr)
 	if err != nil {
@@ -248,7 +623,415 @@ func (s Service) DeleteWork(ctx context.Context, id string) error {
 	if err := tx.Commit(); err != nil {
 		return err
 	}
+	s.refresh()
+	s.events.Publish(workUpdated(w, events.ChangeArchived))
 
 	return nil
+}
+
+// RestoreWork brings back an archived completed work: its lot is created
+// again in Arca when missing and its order progress and stock movements are
+// posted again. Works archived before completion never produced their lot
+// and cannot be restored, nor can the works of an archived spindryer work
+// until that one is restored.
+func (s Service) RestoreWork(ctx context.Context, id string, now time.Time) (Work, error) {
+	_id, err := strconv.Atoi(id)
+	if err != nil {
+		return Work{}, web.NewError("invalid id", web.ErrReasonInvalidParameter, "parameter", "id")
+	}
+
+	tx, err := s.store.BeginTx(ctx)
+	if err != nil {
+		return Work{}, err
+	}
+
+	defer tx.Rollback()
+
+	w, err := s.store.QueryWorkByIDTx(ctx, tx, _id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return Work{}, web.NewError("work not found", web.ErrReasonNotFound, "parameter", "id")
+		}
+		return Work{}, err
+	}
+
+	if w.DeletedAt == nil {
+		return Work{}, web.NewError("work is not archived", web.ErrReasonConflict, "parameter", "id")
+	}
+
+	if w.Status != PROCESSING_STATUS_DONE {
+		return Work{}, web.NewError("only completed works can be restored", web.ErrReasonConflict, "parameter", "id")
+	}
+
+	archivedParents, err := s.store.HasArchivedParents(ctx, tx, w.ID)
+	if err != nil {
+		return Work{}, err
+	}
+
+	if archivedParents {
+		return Work{}, web.NewError("a spindryer work used by the work is archived, restore it first", web.ErrReasonConflict, "parameter", "id")
+	}
+
+	found, err := s.store.CheckLottoAndAr(ctx, tx, w.CdLotto, w.CdAr)
+	if err != nil {
+		return Work{}, err
+	}
+	if !found {
+		err = s.arca.CreateLot(ctx, tx, s.lot, arca.NewLot{CdAr: w.CdAr, CdLotto: w.CdLotto, ProductionDate: w.Date}, now)
+		if err != nil {
+			return Work{}, err
+		}
+	}
+
+	if w.OrderID != nil {
+		if err := s.arca.AddOrderProgress(ctx, tx, *w.OrderID, float64(w.BasilAmount), s.document.OrderUnit, now); err != nil {
+			return Work{}, err
+		}
+	}
+
+	consumed, err := consumedMovements(ctx, s.genealogy, w)
+	if err != nil {
+		return Work{}, err
+	}
+
+	if err := s.stock.Post(ctx, tx, w.ID, producedMovement(w), consumed, now); err != nil {
+		return Work{}, err
+	}
+
+	w.DeletedAt = nil
+	w.DeletedBy = nil
+	w.DeleteReason = nil
+	version, err := s.store.UpdateDeleted(ctx, tx, w)
+	if err != nil {
+		if errors.Is(err, ErrConflict) {
+			return Work{}, web.NewError("work changed concurrently, retry", web.ErrReasonConflict, "", "")
+		}
+		return Work{}, err
+	}
+	w.Version = version
+
+	if err := tx.Commit(); err != nil {
+		return Work{}, err
+	}
+	s.events.Publish(workUpdated(w, events.ChangeRestored))
+
+	return w, nil
+}
+
+// PurgeArchive removes for good the works archived before before, with
+// their genealogy, and returns how many were removed.
+func (s Service) PurgeArchive(ctx context.Context, before time.Time) (int, error) {
+	n, err := s.store.PurgeWorks(ctx, before)
+	if err != nil {
+		return 0, err
+	}
+
+	if n > 0 {
+		s.events.Publish(events.WorkPurged{Count: n, Before: before})
+	}
+
+	return n, nil
+}
+
+// CorrectWork overwrites the quantities of a completed work whose document
+// has not been created yet. The first correction of a field keeps the value
+// read from the PLC in the matching plc_ column, and every change is logged
+// with the user and the reason.
+func (s Service) CorrectWork(ctx context.Context, id string, cw CorrectWork, user string, now time.Time) (Work, error) {
+	_id, err := strconv.Atoi(id)
+	if err != nil {
+		return Work{}, web.NewError("invalid id", web.ErrReasonInvalidParameter, "parameter", "id")
+	}
+
+	if err := cw.Validate(); err != nil {
+		return Work{}, err
+	}
+
+	tx, err := s.store.BeginTx(ctx)
+	if err != nil {
+		return Work{}, err
+	}
+
+	defer tx.Rollback()
+
+	w, err := s.store.QueryWorkByIDTx(ctx, tx, _id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return Work{}, web.NewError("work not found", web.ErrReasonNotFound, "parameter", "id")
+		}
+		return Work{}, err
+	}
+
+	if w.DeletedAt != nil {
+		return Work{}, web.NewError("work is archived", web.ErrReasonConflict, "", "")
+	}
+
+	if w.DocumentCreated {
+		return Work{}, web.NewError("document already created for this work", web.ErrReasonConflict, "", "")
+	}
+
+	if w.Status != PROCESSING_STATUS_DONE {
+		return Work{}, web.NewError("only completed works can be corrected", web.ErrReasonConflict, "", "")
+	}
+
+	corrections := make([]Correction, 0)
+	if cw.BasilAmount != nil && *cw.BasilAmount != w.BasilAmount {
+		if w.OrderID != nil {
+			if err := s.arca.AddOrderProgress(ctx, tx, *w.OrderID, float64(*cw.BasilAmount-w.BasilAmount), s.document.OrderUnit, now); err != nil {
+				return Work{}, err
+			}
+		}
+		if w.PlcBasilAmount == nil {
+			plc := w.BasilAmount
+			w.PlcBasilAmount = &plc
+		}
+		corrections = append(corrections, Correction{
+			WorkID:   w.ID,
+			Field:    "basil_amount",
+			PlcValue: *w.PlcBasilAmount,
+			OldValue: w.BasilAmount,
+			NewValue: *cw.BasilAmount,
+		})
+		w.BasilAmount = *cw.BasilAmount
+	}
+
+	if cw.Packages != nil && *cw.Packages != w.Packages {
+		if w.PlcPackages == nil {
+			plc := w.Packages
+			w.PlcPackages = &plc
+		}
+		corrections = append(corrections, Correction{
+			WorkID:   w.ID,
+			Field:    "packages",
+			PlcValue: *w.PlcPackages,
+			OldValue: w.Packages,
+			NewValue: *cw.Packages,
+		})
+		w.Packages = *cw.Packages
+	}
+
+	if len(corrections) == 0 {
+		return w, nil
+	}
+
+	version, err := s.store.UpdateCorrection(ctx, tx, w)
+	if err != nil {
+		if errors.Is(err, ErrConflict) {
+			return Work{}, web.NewError("work changed concurrently, retry", web.ErrReasonConflict, "", "")
+		}
+		return Work{}, err
+	}
+	w.Version = version
+
+	consumed, err := consumedMovements(ctx, s.genealogy, w)
+	if err != nil {
+		return Work{}, err
+	}
+
+	if err := s.stock.Repost(ctx, tx, w.ID, producedMovement(w), consumed, now); err != nil {
+		return Work{}, err
+	}
+
+	for _, c := range corrections {
+		c.User = user
+		c.Reason = *cw.Reason
+		c.Created = now.UTC()
+		if _, err := s.store.InsertCorrection(ctx, tx, c); err != nil {
+			return Work{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Work{}, err
+	}
+
+	for _, c := range corrections {
+		s.events.Publish(quantityChanged(w, c.Field, c.OldValue, c.NewValue, events.SourceCorrection))
+	}
+	s.events.Publish(workUpdated(w, events.ChangeCorrected))
+	s.events.Legacy("pasteurizer-work-corrected", w)
+
+	return w, nil
+}
+
+func (s Service) QueryCorrections(ctx context.Context, id string) ([]Correction, error) {
+	_id, err := strconv.Atoi(id)
+	if err != nil {
+		return make([]Correction, 0), web.NewError("invalid id", web.ErrReasonInvalidParameter, "parameter", "id")
+	}
+
+	corrections, err := s.store.QueryCorrections(ctx, _id)
+	if err != nil {
+		return make([]Correction, 0), err
+	}
+	return corrections, nil
+}
+
+// refresh lets the event loop, if connected, know that the active work changed.
+func (s Service) refresh() {
+	if s.opcua != nil {
+		s.opcua.Refresh()
+	}
+}
+
+// pauseActiveWork pauses the work in progress when the machine connection is lost.
+func (s Service) pauseActiveWork(ctx context.Context, now time.Time) error {
+	return retryOnConflict(func() error {
+		w, err := s.store.QueryActiveWork(ctx)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		if w.Status != PROCESSING_STATUS_WORK || w.PausedAt != nil {
+			return nil
+		}
+		w.Pause(now)
+
+		w, err = saveWork(ctx, s.store, w, s.store.UpdatePause)
+		if err != nil {
+			return err
+		}
+
+		s.events.Publish(workUpdated(w, events.ChangePaused))
+		return nil
+	})
+}
+
+// resumeActiveWork resumes the paused work once the machine is connected again.
+func (s Service) resumeActiveWork(ctx context.Context, now time.Time) error {
+	return retryOnConflict(func() error {
+		w, err := s.store.QueryActiveWork(ctx)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		if w.PausedAt == nil {
+			return nil
+		}
+		w.Resume(now)
+
+		w, err = saveWork(ctx, s.store, w, s.store.UpdatePause)
+		if err != nil {
+			return err
+		}
+
+		s.events.Publish(workUpdated(w, events.ChangeResumed))
+		return nil
+	})
+}
+
+// maxConflictRetries bounds how many times a work update is re-applied on
+// fresh data after losing an optimistic concurrency check.
+const maxConflictRetries = 5
+
+// retryOnConflict runs fn again as long as it fails with ErrConflict. fn must
+// reload the work it updates on every call.
+func retryOnConflict(fn func() error) error {
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		if err = fn(); !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
+	return err
+}
+
+// saveWork writes the fields handled by update in a transaction of its own
+// and returns the work with its new row version.
+func saveWork(ctx context.Context, store Storer, w Work, update func(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error)) (Work, error) {
+	tx, err := store.BeginTx(ctx)
+	if err != nil {
+		return Work{}, err
+	}
+
+	defer tx.Rollback()
+
+	version, err := update(ctx, tx, w)
+	if err != nil {
+		return Work{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Work{}, err
+	}
+
+	w.Version = version
+	return w, nil
+}
+
+// QueryCycleTimes reports the cycle times per article of the works ended
+// between from and to, both formatted as 2006-01-02 and inclusive. The last
+// 30 days are reported when they are omitted.
+func (s Service) QueryCycleTimes(ctx context.Context, from, to string, now time.Time) ([]CycleTime, error) {
+	_to := now
+	if to != "" {
+		t, err := time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			return make([]CycleTime, 0), web.NewError("invalid to date", web.ErrReasonInvalidParameter, "parameter", "to")
+		}
+		_to = t.AddDate(0, 0, 1)
+	}
+
+	_from := _to.AddDate(0, 0, -30)
+	if from != "" {
+		t, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			return make([]CycleTime, 0), web.NewError("invalid from date", web.ErrReasonInvalidParameter, "parameter", "from")
+		}
+		_from = t
+	}
+
+	cycleTimes, err := s.store.QueryCycleTimes(ctx, _from, _to)
+	if err != nil {
+		return make([]CycleTime, 0), err
+	}
+	return cycleTimes, nil
+}
+
+// producedMovement is the load of the lot produced by the work.
+func producedMovement(w Work) stock.NewMovement {
+	return stock.NewMovement{
+		CdAr:     w.CdAr,
+		CdLotto:  w.CdLotto,
+		Quantity: float64(w.BasilAmount),
+	}
+}
+
+// consumedMovements are the unloads of the spindryer lots used by the work,
+// with the quantities declared in its genealogy.
+func consumedMovements(ctx context.Context, genealogyStore genealogy.Storer, w Work) ([]stock.NewMovement, error) {
+	parents, err := genealogyStore.QueryParents(ctx, w.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	consumed := make([]stock.NewMovement, 0, len(parents))
+	for _, p := range parents {
+		if p.Quantity == nil {
+			continue
+		}
+		consumed = append(consumed, stock.NewMovement{
+			CdAr:     p.CdAr,
+			CdLotto:  p.CdLotto,
+			Quantity: float64(*p.Quantity),
+		})
+	}
+
+	return consumed, nil
+}
+
+// QueryMovements returns the stock movements posted for the work.
+func (s Service) QueryMovements(ctx context.Context, id string) ([]stock.Movement, error) {
+	_id, err := strconv.Atoi(id)
+	if err != nil {
+		return make([]stock.Movement, 0), web.NewError("invalid id", web.ErrReasonInvalidParameter, "parameter", "id")
+	}
 
+	return s.stock.QueryMovements(ctx, _id)
 }
@@ -0,0 +1,304 @@
+package pasteurizer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/page"
+)
+
+// SQLiteStore implements Storer on the development database. The version of
+// a work is a random blob renewed on every update, in place of the
+// rowversion of SQL Server.
+type SQLiteStore struct {
+	db  *sql.DB
+	log *log.Logger
+}
+
+func NewSQLiteStore(db *sql.DB, log *log.Logger) SQLiteStore {
+	return SQLiteStore{db: db, log: log}
+}
+
+func (s SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s SQLiteStore) BeginTx(ctx context.Context) (*sql.Tx, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (s SQLiteStore) CheckLottoAndAr(ctx context.Context, tx *sql.Tx, cd_lotto, cd_ar string) (bool, error) {
+	row := tx.QueryRowContext(ctx, `select count(*) from ARLotto where Cd_ARLotto = ?1 and Cd_AR = ?2`, cd_lotto, cd_ar)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (s SQLiteStore) CheckLottoAndArInDoc(ctx context.Context, tx *sql.Tx, cd_lotto, cd_ar string) (bool, error) {
+	row := tx.QueryRowContext(ctx, `select count(*) from DoRig where Cd_ARLotto = ?1 and Cd_AR = ?2`, cd_lotto, cd_ar)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (s SQLiteStore) CheckLottoAndArInWork(ctx context.Context, tx *sql.Tx, cd_lotto, cd_ar string) (bool, error) {
+	row := tx.QueryRowContext(ctx, `select count(*) from xPastorizzatore where cd_lotto = ?1 and cd_ar = ?2`, cd_lotto, cd_ar)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (s SQLiteStore) QueryWork(ctx context.Context, f WorkFilter, orders []page.Order, after []interface{}, limit int) ([]Work, error) {
+	where, args := workConditions(f, orders, after, page.ParamSQLite)
+	rows, err := s.db.QueryContext(ctx, `select `+workColumns+` from xPastorizzatore`+where+page.OrderBy(orders)+` limit `+strconv.Itoa(limit), args...)
+	if err != nil {
+		return make([]Work, 0), err
+	}
+	defer rows.Close()
+
+	works := make([]Work, 0)
+	for rows.Next() {
+		w, err := scanWork(rows)
+		if err != nil {
+			return make([]Work, 0), err
+		}
+		works = append(works, w)
+	}
+
+	return works, nil
+}
+
+func (s SQLiteStore) QueryWorkByID(ctx context.Context, id int) (Work, error) {
+	return s.queryWork(ctx, `select `+workColumns+` from xPastorizzatore where id = ?1`, id)
+}
+
+func (s SQLiteStore) QueryWorkByIDTx(ctx context.Context, tx *sql.Tx, id int) (Work, error) {
+	w, err := scanWork(tx.QueryRowContext(ctx, `select `+workColumns+` from xPastorizzatore where id = ?1`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Work{}, ErrNotFound
+		}
+		return Work{}, err
+	}
+
+	return w, nil
+}
+
+func (s SQLiteStore) QueryActiveWork(ctx context.Context) (Work, error) {
+	return s.queryWork(ctx, `select `+workColumns+` from xPastorizzatore where status != 'done' and deleted_at is null limit 1`)
+}
+
+func (s SQLiteStore) queryWork(ctx context.Context, query string, args ...interface{}) (Work, error) {
+	w, err := scanWork(s.db.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Work{}, ErrNotFound
+		}
+		return Work{}, err
+	}
+
+	return w, nil
+}
+
+func (s SQLiteStore) ExistActiveWork(ctx context.Context) (bool, error) {
+	row := s.db.QueryRowContext(ctx, `select count(*) from xPastorizzatore where status != 'done' and deleted_at is null`)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (s SQLiteStore) DeleteLottoArca(ctx context.Context, tx *sql.Tx, cd_lotto, cd_ar string) error {
+	_, err := tx.ExecContext(ctx, `delete from ARLotto where Cd_ARLotto = ?1 and Cd_AR = ?2`, cd_lotto, cd_ar)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s SQLiteStore) InsertWork(ctx context.Context, tx *sql.Tx, w Work) (int, error) {
+	row := tx.QueryRowContext(ctx, `insert into xPastorizzatore (cd_lotto, cd_ar, basil_amount, packages, date, document_created, status, created, order_id)
+	values(?1,?2,?3,?4,?5,?6,?7,?8,?9) returning id`, w.CdLotto, w.CdAr, w.BasilAmount, w.Packages, w.Date, w.DocumentCreated, w.Status, w.Created, w.OrderID)
+
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// updateWork is the SQLite counterpart of Store.updateWork: ?1 and ?2 are
+// bound to the id and the version, the arguments of set start from ?3.
+func (s SQLiteStore) updateWork(ctx context.Context, tx *sql.Tx, w Work, set string, args ...interface{}) ([]byte, error) {
+	args = append([]interface{}{w.ID, w.Version}, args...)
+	row := tx.QueryRowContext(ctx, `update xPastorizzatore set `+set+`, version = randomblob(8) where id = ?1 and version = ?2 returning version`, args...)
+
+	var version []byte
+	if err := row.Scan(&version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrConflict
+		}
+		return nil, err
+	}
+
+	return version, nil
+}
+
+func (s SQLiteStore) UpdateWorkStart(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `status = ?3, started_at = ?4`, w.Status, w.StartedAt)
+}
+
+func (s SQLiteStore) UpdateBasilAmount(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `basil_amount = ?3`, w.BasilAmount)
+}
+
+func (s SQLiteStore) UpdatePackages(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `packages = ?3`, w.Packages)
+}
+
+func (s SQLiteStore) UpdateWorkEnd(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `status = ?3, ended_at = ?4, paused_at = ?5, active_seconds = ?6, paused_seconds = ?7`,
+		w.Status, w.EndedAt, w.PausedAt, w.ActiveSeconds, w.PausedSeconds)
+}
+
+func (s SQLiteStore) UpdateDocument(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `document_created = ?3, document_number = ?4, document_date = ?5`, w.DocumentCreated, w.DocumentNumber, w.DocumentDate)
+}
+
+func (s SQLiteStore) UpdatePause(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `paused_at = ?3, paused_seconds = ?4`, w.PausedAt, w.PausedSeconds)
+}
+
+func (s SQLiteStore) UpdateCorrection(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `basil_amount = ?3, packages = ?4, plc_basil_amount = ?5, plc_packages = ?6`,
+		w.BasilAmount, w.Packages, w.PlcBasilAmount, w.PlcPackages)
+}
+
+func (s SQLiteStore) UpdateDeleted(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `deleted_at = ?3, deleted_by = ?4, delete_reason = ?5`, w.DeletedAt, w.DeletedBy, w.DeleteReason)
+}
+
+func (s SQLiteStore) PurgeWorks(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, `delete from xPastorizzatore where deleted_at < ?1
+	and not exists (select 1 from xGenealogia g where g.child_work_id = xPastorizzatore.id)`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+func (s SQLiteStore) InsertCorrection(ctx context.Context, tx *sql.Tx, c Correction) (int, error) {
+	row := tx.QueryRowContext(ctx, `insert into xPastorizzatoreRettifica (work_id, field, plc_value, old_value, new_value, "user", reason, created)
+	values(?1,?2,?3,?4,?5,?6,?7,?8) returning id`, c.WorkID, c.Field, c.PlcValue, c.OldValue, c.NewValue, c.User, c.Reason, c.Created)
+
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s SQLiteStore) QueryCorrections(ctx context.Context, workID int) ([]Correction, error) {
+	rows, err := s.db.QueryContext(ctx, `select id, work_id, field, plc_value, old_value, new_value, "user", reason, created from xPastorizzatoreRettifica where work_id = ?1 order by created desc`, workID)
+	if err != nil {
+		return make([]Correction, 0), err
+	}
+	defer rows.Close()
+
+	corrections := make([]Correction, 0)
+	for rows.Next() {
+		var c Correction
+		if err := rows.Scan(&c.ID, &c.WorkID, &c.Field, &c.PlcValue, &c.OldValue, &c.NewValue, &c.User, &c.Reason, &c.Created); err != nil {
+			return make([]Correction, 0), err
+		}
+		corrections = append(corrections, c)
+	}
+
+	return corrections, nil
+}
+
+func (s SQLiteStore) QueryCycleTimes(ctx context.Context, from, to time.Time) ([]CycleTime, error) {
+	rows, err := s.db.QueryContext(ctx, `select cd_ar, count(*), cast(avg(active_seconds) as integer), min(active_seconds), max(active_seconds), cast(avg(paused_seconds) as integer)
+	from xPastorizzatore where status = 'done' and deleted_at is null and ended_at >= ?1 and ended_at < ?2 group by cd_ar order by cd_ar`, from, to)
+	if err != nil {
+		return make([]CycleTime, 0), err
+	}
+	defer rows.Close()
+
+	cycleTimes := make([]CycleTime, 0)
+	for rows.Next() {
+		var ct CycleTime
+		if err := rows.Scan(&ct.CdAr, &ct.Works, &ct.AvgActiveSeconds, &ct.MinActiveSeconds, &ct.MaxActiveSeconds, &ct.AvgPausedSeconds); err != nil {
+			return make([]CycleTime, 0), err
+		}
+		cycleTimes = append(cycleTimes, ct)
+	}
+
+	return cycleTimes, nil
+}
+
+func (s SQLiteStore) QueryUnsyncedDocuments(ctx context.Context) ([]DocumentLink, error) {
+	rows, err := s.db.QueryContext(ctx, `select w.id, d.NumeroDoc, d.DataDoc from xPastorizzatore w
+	left join DoTes d on d.Id_DoTes = (select min(Id_DoTes) from DoTes where xId_Pastorizzatore = w.id)
+	where (d.Id_DoTes is not null and (w.document_created = 0 or w.document_number is null or w.document_number != d.NumeroDoc or w.document_date is null or w.document_date != d.DataDoc))
+	or (d.Id_DoTes is null and w.document_created = 1)`)
+	if err != nil {
+		return make([]DocumentLink, 0), err
+	}
+	defer rows.Close()
+
+	links := make([]DocumentLink, 0)
+	for rows.Next() {
+		var l DocumentLink
+		if err := rows.Scan(&l.WorkID, &l.Number, &l.Date); err != nil {
+			return make([]DocumentLink, 0), err
+		}
+		links = append(links, l)
+	}
+
+	return links, nil
+}
+
+func (s SQLiteStore) HasArchivedParents(ctx context.Context, tx *sql.Tx, id int) (bool, error) {
+	row := tx.QueryRowContext(ctx, `select count(*) from xGenealogia g join xCentrifuga w on w.id = g.parent_work_id
+	where g.child_work_id = ?1 and w.deleted_at is not null`, id)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
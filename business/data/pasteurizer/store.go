@@ -5,18 +5,59 @@ import (
 	"database/sql"
 	"errors"
 	"log"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/page"
 )
 
 var (
 	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("work changed concurrently")
 )
 
+// Storer is the works data of the pasteurizer. Store implements it on the
+// Arca database, SQLiteStore on the development one.
+type Storer interface {
+	BeginTx(ctx context.Context) (*sql.Tx, error)
+	Ping(ctx context.Context) error
+	CheckLottoAndAr(ctx context.Context, tx *sql.Tx, cd_lotto, cd_ar string) (bool, error)
+	CheckLottoAndArInDoc(ctx context.Context, tx *sql.Tx, cd_lotto, cd_ar string) (bool, error)
+	CheckLottoAndArInWork(ctx context.Context, tx *sql.Tx, cd_lotto, cd_ar string) (bool, error)
+	DeleteLottoArca(ctx context.Context, tx *sql.Tx, cd_lotto, cd_ar string) error
+	QueryWork(ctx context.Context, f WorkFilter, orders []page.Order, after []interface{}, limit int) ([]Work, error)
+	QueryWorkByID(ctx context.Context, id int) (Work, error)
+	QueryWorkByIDTx(ctx context.Context, tx *sql.Tx, id int) (Work, error)
+	QueryActiveWork(ctx context.Context) (Work, error)
+	ExistActiveWork(ctx context.Context) (bool, error)
+	InsertWork(ctx context.Context, tx *sql.Tx, w Work) (int, error)
+	UpdateWorkStart(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error)
+	UpdateBasilAmount(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error)
+	UpdatePackages(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error)
+	UpdateWorkEnd(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error)
+	UpdateDocument(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error)
+	UpdatePause(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error)
+	UpdateCorrection(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error)
+	UpdateDeleted(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error)
+	PurgeWorks(ctx context.Context, before time.Time) (int, error)
+	InsertCorrection(ctx context.Context, tx *sql.Tx, c Correction) (int, error)
+	QueryCorrections(ctx context.Context, workID int) ([]Correction, error)
+	QueryCycleTimes(ctx context.Context, from, to time.Time) ([]CycleTime, error)
+	QueryUnsyncedDocuments(ctx context.Context) ([]DocumentLink, error)
+	HasArchivedParents(ctx context.Context, tx *sql.Tx, id int) (bool, error)
+}
+
 type Store struct {
 	db  *sql.DB
 	log *log.Logger
 }
 
+// Ping reports whether the database is reachable.
+func (s Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
 func (s Store) BeginTx(ctx context.Context) (*sql.Tx, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -29,6 +70,20 @@ func NewStore(db *sql.DB, log *log.Logger) Store {
 	return Store{db: db, log: log}
 }
 
+const workColumns = `id, cd_lotto, cd_ar, basil_amount, packages, plc_basil_amount, plc_packages, date, started_at, ended_at, paused_at, active_seconds, paused_seconds, document_created, document_number, document_date, order_id, status, created, deleted_at, deleted_by, delete_reason, version`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWork(row scanner) (Work, error) {
+	var w Work
+	if err := row.Scan(&w.ID, &w.CdLotto, &w.CdAr, &w.BasilAmount, &w.Packages, &w.PlcBasilAmount, &w.PlcPackages, &w.Date, &w.StartedAt, &w.EndedAt, &w.PausedAt, &w.ActiveSeconds, &w.PausedSeconds, &w.DocumentCreated, &w.DocumentNumber, &w.DocumentDate, &w.OrderID, &w.Status, &w.Created, &w.DeletedAt, &w.DeletedBy, &w.DeleteReason, &w.Version); err != nil {
+		return Work{}, err
+	}
+	return w, nil
+}
+
 func (s Store) CheckLottoAndAr(ctx context.Context, tx *sql.Tx, cd_lotto, cd_ar string) (bool, error) {
 	row := tx.QueryRowContext(ctx, `select count(*) from ARLotto where cd_ARLotto = @p1 and cd_AR = @p2`, cd_lotto, cd_ar)
 	if err := row.Err(); err != nil {
@@ -65,9 +120,8 @@ func (s Store) CheckLottoAndArInDoc(ctx context.Context, tx *sql.Tx, cd_lotto, c
 	return true, nil
 }
 
-func (s Store) CreateLottoArca(ctx context.Context, tx *sql.Tx, cd_lotto, cd_ar string, now time.Time) error {
-	_, err := tx.ExecContext(ctx, `insert into ARLotto (Cd_ARLotto, Cd_AR, Descrizione, UserIns, UserUpd, TimeIns, TimeUpd) 
-	values(@p1,@p2,@p3,@p4,@p5,@p6,@p7)`, cd_lotto, cd_ar, "", "opcua-service", "opcua-service", now, now)
+func (s Store) DeleteLottoArca(ctx context.Context, tx *sql.Tx, cd_lotto, cd_ar string) error {
+	_, err := tx.ExecContext(ctx, `delete from ARLotto where cd_ARLotto = @p1 and cd_ar = @p2`, cd_lotto, cd_ar)
 	if err != nil {
 		return err
 	}
@@ -75,18 +129,24 @@ func (s Store) CreateLottoArca(ctx context.Context, tx *sql.Tx, cd_lotto, cd_ar
 	return nil
 }
 
-func (s Store) DeleteLottoArca(ctx context.Context, tx *sql.Tx, cd_lotto, cd_ar string) error {
-	_, err := tx.ExecContext(ctx, `delete from ARLotto where cd_ARLotto = @p1 and cd_ar = @p2`, cd_lotto, cd_ar)
-	if err != nil {
-		return err
+// CheckLottoAndArInWork reports whether a work, archived ones included,
+// already holds the lot of the article.
+func (s Store) CheckLottoAndArInWork(ctx context.Context, tx *sql.Tx, cd_lotto, cd_ar string) (bool, error) {
+	row := tx.QueryRowContext(ctx, `select count(*) from xPastorizzatore where cd_lotto = @p1 and cd_ar = @p2`, cd_lotto, cd_ar)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return false, err
 	}
 
-	return nil
+	return count > 0, nil
 }
 
-func (s Store) QueryWork(ctx context.Context) ([]Work, error) {
-
-	rows, err := s.db.QueryContext(ctx, `select top(50) id, cd_lotto, cd_ar, basil_amount, packages, date, document_created, status, created from xPastorizzatore order by date desc`)
+// QueryWork returns the first limit works of the filter in the orders,
+// starting after the work with the sort values after when it is not nil.
+func (s Store) QueryWork(ctx context.Context, f WorkFilter, orders []page.Order, after []interface{}, limit int) ([]Work, error) {
+	where, args := workConditions(f, orders, after, page.ParamSQLServer)
+	rows, err := s.db.QueryContext(ctx, `select top(`+strconv.Itoa(limit)+`) `+workColumns+` from xPastorizzatore`+where+page.OrderBy(orders), page.ArgsSQLServer(args)...)
 	if err != nil {
 		return make([]Work, 0), err
 	}
@@ -94,8 +154,8 @@ func (s Store) QueryWork(ctx context.Context) ([]Work, error) {
 
 	works := make([]Work, 0)
 	for rows.Next() {
-		var w Work
-		if err := rows.Scan(&w.ID, &w.CdLotto, &w.CdAr, &w.BasilAmount, &w.Packages, &w.Date, &w.DocumentCreated, &w.Status, &w.Created); err != nil {
+		w, err := scanWork(rows)
+		if err != nil {
 			return make([]Work, 0), err
 		}
 		works = append(works, w)
@@ -104,14 +164,73 @@ func (s Store) QueryWork(ctx context.Context) ([]Work, error) {
 	return works, nil
 }
 
+// workConditions returns the where clause selecting the works of the filter
+// that follow after, when it is not nil, together with its arguments.
+func workConditions(f WorkFilter, orders []page.Order, after []interface{}, param page.Param) (string, []interface{}) {
+	conds := []string{"deleted_at is null"}
+	if f.Deleted {
+		conds[0] = "deleted_at is not null"
+	}
+
+	args := make([]interface{}, 0)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, cond+param(len(args)))
+	}
+
+	if f.From != nil {
+		add("date >= ", *f.From)
+	}
+	if f.To != nil {
+		add("date < ", *f.To)
+	}
+	if f.CdLotto != nil {
+		add("cd_lotto = ", *f.CdLotto)
+	}
+	if f.CdAr != nil {
+		add("cd_ar = ", *f.CdAr)
+	}
+	if f.Status != nil {
+		add("status = ", *f.Status)
+	}
+	if f.DocumentCreated != nil {
+		add("document_created = ", *f.DocumentCreated)
+	}
+
+	if after != nil {
+		conds = append(conds, page.After(orders, param, len(args)+1))
+		args = append(args, after...)
+	}
+
+	return " where " + strings.Join(conds, " and "), args
+}
+
 func (s Store) QueryWorkByID(ctx context.Context, id int) (Work, error) {
-	row := s.db.QueryRowContext(ctx, `select top(1) id, cd_lotto, cd_ar, basil_amount, packages, date, document_created, status, created from xPastorizzatore where id = @p1`, id)
+	row := s.db.QueryRowContext(ctx, `select top(1) `+workColumns+` from xPastorizzatore where id = @p1`, id)
 	if err := row.Err(); err != nil {
 		return Work{}, err
 	}
 
-	var w Work
-	if err := row.Scan(&w.ID, &w.CdLotto, &w.CdAr, &w.BasilAmount, &w.Packages, &w.Date, &w.DocumentCreated, &w.Status, &w.Created); err != nil {
+	w, err := scanWork(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Work{}, ErrNotFound
+		}
+		return Work{}, err
+	}
+
+	return w, nil
+}
+
+// QueryWorkByIDTx reads the work within tx, holding its row until tx ends.
+func (s Store) QueryWorkByIDTx(ctx context.Context, tx *sql.Tx, id int) (Work, error) {
+	row := tx.QueryRowContext(ctx, `select top(1) `+workColumns+` from xPastorizzatore with (updlock, rowlock) where id = @p1`, id)
+	if err := row.Err(); err != nil {
+		return Work{}, err
+	}
+
+	w, err := scanWork(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Work{}, ErrNotFound
 		}
@@ -122,13 +241,13 @@ func (s Store) QueryWorkByID(ctx context.Context, id int) (Work, error) {
 }
 
 func (s Store) QueryActiveWork(ctx context.Context) (Work, error) {
-	row := s.db.QueryRowContext(ctx, `select top(1) id, cd_lotto, cd_ar, basil_amount, packages, date, document_created, status, created from xPastorizzatore where status != 'done'`)
+	row := s.db.QueryRowContext(ctx, `select top(1) `+workColumns+` from xPastorizzatore where status != 'done' and deleted_at is null`)
 	if err := row.Err(); err != nil {
 		return Work{}, err
 	}
 
-	var w Work
-	if err := row.Scan(&w.ID, &w.CdLotto, &w.CdAr, &w.BasilAmount, &w.Packages, &w.Date, &w.DocumentCreated, &w.Status, &w.Created); err != nil {
+	w, err := scanWork(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Work{}, ErrNotFound
 		}
@@ -139,7 +258,7 @@ func (s Store) QueryActiveWork(ctx context.Context) (Work, error) {
 }
 
 func (s Store) ExistActiveWork(ctx context.Context) (bool, error) {
-	row := s.db.QueryRowContext(ctx, `select count(*) from xPastorizzatore where status != 'done'`)
+	row := s.db.QueryRowContext(ctx, `select count(*) from xPastorizzatore where status != 'done' and deleted_at is null`)
 	if err := row.Err(); err != nil {
 		return false, err
 	}
@@ -156,38 +275,186 @@ func (s Store) ExistActiveWork(ctx context.Context) (bool, error) {
 	return true, nil
 }
 
-func (s Store) DeleteWork(ctx context.Context, tx *sql.Tx, id int) error {
-	_, err := tx.ExecContext(ctx, `delete from xPastorizzatore where id = @p1`, id)
+func (s Store) InsertWork(ctx context.Context, tx *sql.Tx, w Work) (int, error) {
+	row := tx.QueryRowContext(ctx, `insert into xPastorizzatore (cd_lotto, cd_ar, basil_amount, packages, date, document_created, status, created, order_id) 
+	values(@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8,@p9); select ID = convert(bigint, SCOPE_IDENTITY())`, w.CdLotto, w.CdAr, w.BasilAmount, w.Packages, w.Date, w.DocumentCreated, w.Status, w.Created, w.OrderID)
+	if err := row.Err(); err != nil {
+		return 0, err
+	}
+
+	var id int
+	err := row.Scan(&id)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return id, nil
 }
 
-func (s Store) InsertWork(ctx context.Context, tx *sql.Tx, w Work) (int, error) {
-	row := tx.QueryRowContext(ctx, `insert into xPastorizzatore (cd_lotto, cd_ar, basil_amount, packages, date, document_created, status, created) 
-	values(@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8); select ID = convert(bigint, SCOPE_IDENTITY())`, w.CdLotto, w.CdAr, w.BasilAmount, w.Packages, w.Date, w.DocumentCreated, w.Status, w.Created)
+// updateWork applies set to the work only if its row version still matches
+// w.Version, so that concurrent writers never overwrite each other, and
+// returns the new version. @p1 and @p2 are bound to the id and the version.
+func (s Store) updateWork(ctx context.Context, tx *sql.Tx, w Work, set string, args ...interface{}) ([]byte, error) {
+	args = append([]interface{}{w.ID, w.Version}, args...)
+	row := tx.QueryRowContext(ctx, `update xPastorizzatore set `+set+` output inserted.version where id = @p1 and version = @p2`, args...)
+
+	var version []byte
+	if err := row.Scan(&version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrConflict
+		}
+		return nil, err
+	}
+
+	return version, nil
+}
+
+// UpdateWorkStart writes the fields set when the PLC confirms the lot.
+func (s Store) UpdateWorkStart(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `status = @p3, started_at = @p4`, w.Status, w.StartedAt)
+}
+
+func (s Store) UpdateBasilAmount(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `basil_amount = @p3`, w.BasilAmount)
+}
+
+func (s Store) UpdatePackages(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `packages = @p3`, w.Packages)
+}
+
+// UpdateWorkEnd writes the fields set when the work is completed.
+func (s Store) UpdateWorkEnd(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `status = @p3, ended_at = @p4, paused_at = @p5, active_seconds = @p6, paused_seconds = @p7`,
+		w.Status, w.EndedAt, w.PausedAt, w.ActiveSeconds, w.PausedSeconds)
+}
+
+// UpdateDocument writes the Arca document the work was turned into.
+func (s Store) UpdateDocument(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `document_created = @p3, document_number = @p4, document_date = @p5`, w.DocumentCreated, w.DocumentNumber, w.DocumentDate)
+}
+
+func (s Store) UpdatePause(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `paused_at = @p3, paused_seconds = @p4`, w.PausedAt, w.PausedSeconds)
+}
+
+func (s Store) UpdateCorrection(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `basil_amount = @p3, packages = @p4, plc_basil_amount = @p5, plc_packages = @p6`,
+		w.BasilAmount, w.Packages, w.PlcBasilAmount, w.PlcPackages)
+}
+
+// UpdateDeleted writes the deletion of the work, cleared on restore.
+func (s Store) UpdateDeleted(ctx context.Context, tx *sql.Tx, w Work) ([]byte, error) {
+	return s.updateWork(ctx, tx, w, `deleted_at = @p3, deleted_by = @p4, delete_reason = @p5`, w.DeletedAt, w.DeletedBy, w.DeleteReason)
+}
+
+// PurgeWorks removes for good the works archived before before and returns
+// how many were removed. A work made from spindryer works is kept with its
+// genealogy. The corrections of a work purged are kept, as its audit trail.
+func (s Store) PurgeWorks(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, `delete from xPastorizzatore where deleted_at < @p1
+	and not exists (select 1 from xGenealogia g where g.child_work_id = xPastorizzatore.id)`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+func (s Store) InsertCorrection(ctx context.Context, tx *sql.Tx, c Correction) (int, error) {
+	row := tx.QueryRowContext(ctx, `insert into xPastorizzatoreRettifica (work_id, field, plc_value, old_value, new_value, [user], reason, created) 
+	values(@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8); select ID = convert(bigint, SCOPE_IDENTITY())`, c.WorkID, c.Field, c.PlcValue, c.OldValue, c.NewValue, c.User, c.Reason, c.Created)
 	if err := row.Err(); err != nil {
 		return 0, err
 	}
 
 	var id int
-	err := row.Scan(&id)
-	if err != nil {
+	if err := row.Scan(&id); err != nil {
 		return 0, err
 	}
 
 	return id, nil
 }
 
-func (s Store) UpdateWork(ctx context.Context, tx *sql.Tx, w Work) error {
-	_, err := tx.ExecContext(ctx, `update xPastorizzatore 
-	set cd_lotto = @p1, cd_ar = @p2, basil_amount = @p3, packages = @p4, date = @p5, document_created = @p6, status = @p7, created = @p8 
-	where id = @p9`, w.CdLotto, w.CdAr, w.BasilAmount, w.Packages, w.Date, w.DocumentCreated, w.Status, w.Created, w.ID)
+func (s Store) QueryCorrections(ctx context.Context, workID int) ([]Correction, error) {
+	rows, err := s.db.QueryContext(ctx, `select id, work_id, field, plc_value, old_value, new_value, [user], reason, created from xPastorizzatoreRettifica where work_id = @p1 order by created desc`, workID)
 	if err != nil {
-		return err
+		return make([]Correction, 0), err
 	}
+	defer rows.Close()
 
-	return nil
+	corrections := make([]Correction, 0)
+	for rows.Next() {
+		var c Correction
+		if err := rows.Scan(&c.ID, &c.WorkID, &c.Field, &c.PlcValue, &c.OldValue, &c.NewValue, &c.User, &c.Reason, &c.Created); err != nil {
+			return make([]Correction, 0), err
+		}
+		corrections = append(corrections, c)
+	}
+
+	return corrections, nil
+}
+
+func (s Store) QueryCycleTimes(ctx context.Context, from, to time.Time) ([]CycleTime, error) {
+	rows, err := s.db.QueryContext(ctx, `select cd_ar, count(*), avg(active_seconds), min(active_seconds), max(active_seconds), avg(paused_seconds) 
+	from xPastorizzatore where status = 'done' and deleted_at is null and ended_at >= @p1 and ended_at < @p2 group by cd_ar order by cd_ar`, from, to)
+	if err != nil {
+		return make([]CycleTime, 0), err
+	}
+	defer rows.Close()
+
+	cycleTimes := make([]CycleTime, 0)
+	for rows.Next() {
+		var ct CycleTime
+		if err := rows.Scan(&ct.CdAr, &ct.Works, &ct.AvgActiveSeconds, &ct.MinActiveSeconds, &ct.MaxActiveSeconds, &ct.AvgPausedSeconds); err != nil {
+			return make([]CycleTime, 0), err
+		}
+		cycleTimes = append(cycleTimes, ct)
+	}
+
+	return cycleTimes, nil
+}
+
+// QueryUnsyncedDocuments returns the works whose document_created, number or
+// date differ from the Arca document linked to them, including the works
+// marked document_created whose document was deleted. When several documents
+// are linked to a work the first one created counts.
+func (s Store) QueryUnsyncedDocuments(ctx context.Context) ([]DocumentLink, error) {
+	rows, err := s.db.QueryContext(ctx, `select w.id, d.NumeroDoc, d.DataDoc from xPastorizzatore w 
+	left join DoTes d on d.Id_DoTes = (select min(Id_DoTes) from DoTes where xId_Pastorizzatore = w.id)
+	where (d.Id_DoTes is not null and (w.document_created = 0 or w.document_number is null or w.document_number != d.NumeroDoc or w.document_date is null or w.document_date != d.DataDoc))
+	or (d.Id_DoTes is null and w.document_created = 1)`)
+	if err != nil {
+		return make([]DocumentLink, 0), err
+	}
+	defer rows.Close()
+
+	links := make([]DocumentLink, 0)
+	for rows.Next() {
+		var l DocumentLink
+		if err := rows.Scan(&l.WorkID, &l.Number, &l.Date); err != nil {
+			return make([]DocumentLink, 0), err
+		}
+		links = append(links, l)
+	}
+
+	return links, nil
+}
+
+// HasArchivedParents reports whether a spindryer work declared as parent of
+// the work is archived.
+func (s Store) HasArchivedParents(ctx context.Context, tx *sql.Tx, id int) (bool, error) {
+	row := tx.QueryRowContext(ctx, `select count(*) from xGenealogia g join xCentrifuga w on w.id = g.parent_work_id 
+	where g.child_work_id = @p1 and w.deleted_at is not null`, id)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
 }
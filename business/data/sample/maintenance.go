@@ -0,0 +1,187 @@
+package sample
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// rollupLookback is how far back each run computes again the aggregates, so
+// that the samples stored late, after a failed batch of the Recorder, are
+// still rolled up. The hours are computed again from the start of the
+// previous hour, as the minutes they come from may have changed.
+var rollupLookback = map[Resolution]time.Duration{
+	ResolutionMinute: 5 * time.Minute,
+	ResolutionHour:   time.Hour,
+}
+
+// Maintainer rolls the samples up into the aggregates and purges the data
+// older than its retention, each interval unless it is paused.
+type Maintainer struct {
+	store Storer
+	cfg   MaintenanceConfig
+	log   *log.Logger
+
+	mu    sync.Mutex
+	stats MaintenanceStats
+}
+
+func NewMaintainer(store Storer, cfg MaintenanceConfig, log *log.Logger) *Maintainer {
+	return &Maintainer{store: store, cfg: cfg, log: log}
+}
+
+// Pause stops the runs after the current one, if any, until Resume.
+func (m *Maintainer) Pause() MaintenanceStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.stats.Paused {
+		m.log.Println("samples: maintenance paused")
+	}
+	m.stats.Paused = true
+
+	return m.stats
+}
+
+func (m *Maintainer) Resume() MaintenanceStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stats.Paused {
+		m.log.Println("samples: maintenance resumed")
+	}
+	m.stats.Paused = false
+
+	return m.stats
+}
+
+func (m *Maintainer) Stats() MaintenanceStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.stats
+}
+
+// Run runs the maintenance at once and then each interval until ctx is
+// cancelled.
+func (m *Maintainer) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if !m.Stats().Paused {
+			m.run(ctx, time.Now())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Maintainer) run(ctx context.Context, now time.Time) {
+	var last MaintenanceStats
+	err := m.maintain(ctx, now, &last)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stats.Runs++
+	m.stats.LastRun = &now
+	m.stats.LastDuration = time.Since(now).String()
+	m.stats.LastError = ""
+	m.stats.LastMinuteBuckets = last.LastMinuteBuckets
+	m.stats.LastHourBuckets = last.LastHourBuckets
+	m.stats.LastSamplesDeleted = last.LastSamplesDeleted
+	m.stats.LastMinutesDeleted = last.LastMinutesDeleted
+	m.stats.TotalSamplesDeleted += last.LastSamplesDeleted
+	m.stats.TotalMinutesDeleted += last.LastMinutesDeleted
+
+	if err != nil {
+		m.stats.LastError = err.Error()
+		m.log.Printf("samples: maintenance: %s", err)
+		return
+	}
+
+	m.log.Printf("samples: maintenance: rolled up %d minutes and %d hours, deleted %d samples and %d minutes in %s",
+		last.LastMinuteBuckets, last.LastHourBuckets, last.LastSamplesDeleted, last.LastMinutesDeleted, m.stats.LastDuration)
+}
+
+// maintain rolls up the minutes and then the hours, and purges the samples
+// and the minutes. Only the data already rolled up is purged, so the
+// aggregates never miss what the retention removed.
+func (m *Maintainer) maintain(ctx context.Context, now time.Time, last *MaintenanceStats) error {
+	var minutesEnd, hoursEnd time.Time
+	var err error
+
+	minutesEnd, last.LastMinuteBuckets, err = m.rollup(ctx, ResolutionMinute, now)
+	if err != nil {
+		return err
+	}
+
+	hoursEnd, last.LastHourBuckets, err = m.rollup(ctx, ResolutionHour, now)
+	if err != nil {
+		return err
+	}
+
+	if m.cfg.RawRetention > 0 {
+		before := minTime(now.Add(-m.cfg.RawRetention), minutesEnd)
+		last.LastSamplesDeleted, err = m.store.PurgeSamples(ctx, before)
+		if err != nil {
+			return fmt.Errorf("purging samples: %w", err)
+		}
+	}
+
+	if m.cfg.MinuteRetention > 0 {
+		before := minTime(now.Add(-m.cfg.MinuteRetention), hoursEnd)
+		last.LastMinutesDeleted, err = m.store.PurgeMinutes(ctx, before)
+		if err != nil {
+			return fmt.Errorf("purging minutes: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// rollup computes the complete buckets of the resolution not rolled up yet,
+// and those of the lookback, returning the end of the last one.
+func (m *Maintainer) rollup(ctx context.Context, r Resolution, now time.Time) (time.Time, int, error) {
+	end := now.Truncate(r.Step())
+
+	rr, err := m.store.QueryRollupRange(ctx, r)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("querying %s rollup range: %w", r, err)
+	}
+
+	var start time.Time
+	switch {
+	case rr.End != nil:
+		start = rr.End.Add(-rollupLookback[r])
+	case rr.First != nil:
+		start = rr.First.Truncate(r.Step())
+	default:
+		return end, 0, nil
+	}
+
+	if !start.Before(end) {
+		return end, 0, nil
+	}
+
+	n, err := m.store.Rollup(ctx, r, start, end)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("rolling up %s: %w", r, err)
+	}
+
+	return end, n, nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,176 @@
+package sample
+
+import (
+	"time"
+)
+
+// Machines the samples can come from, as the machine column of xCampioneTag.
+const (
+	MachineSpindryer   = "spindryer"
+	MachinePasteurizer = "pasteurizer"
+)
+
+// qualityUncertain is the lowest status code that is not of good quality:
+// the two high bits of an OPC UA status code carry its severity. Only the
+// samples below it are aggregated.
+const qualityUncertain = 0x40000000
+
+// maxPoints bounds the buckets of a series, so that a small step over a long
+// range is rejected instead of scanning the whole table.
+const maxPoints = 5000
+
+// defaultPoints is the number of buckets a series is divided into when the
+// step is not given.
+const defaultPoints = 300
+
+// Resolutions of the aggregates the samples are rolled up into.
+const (
+	ResolutionMinute Resolution = "1m"
+	ResolutionHour   Resolution = "1h"
+)
+
+// Resolution is the interval of an aggregate.
+type Resolution string
+
+// Step returns the duration of the interval.
+func (r Resolution) Step() time.Duration {
+	if r == ResolutionHour {
+		return time.Hour
+	}
+	return time.Minute
+}
+
+// Sample is a notification of a monitored tag. Value is nil when the tag is
+// not numeric; booleans are stored as 0 and 1.
+type Sample struct {
+	Machine string
+	Tag     string
+	Value   *float64
+	Quality uint32
+	Time    time.Time
+}
+
+// RecorderConfig tunes the batching of the samples.
+type RecorderConfig struct {
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxPending    int
+}
+
+// MaintenanceConfig schedules the rollup of the samples into the aggregates
+// and the purge of the raw samples and of the minute aggregates older than
+// their retention, 0 keeping them forever. The hour aggregates are kept
+// forever.
+type MaintenanceConfig struct {
+	Interval        time.Duration
+	RawRetention    time.Duration
+	MinuteRetention time.Duration
+}
+
+// MaintenanceStats reports the maintenance runs: the counts of the last run
+// and the totals since the start.
+type MaintenanceStats struct {
+	Paused              bool       `json:"paused"`
+	Runs                int        `json:"runs"`
+	LastRun             *time.Time `json:"last_run"`
+	LastDuration        string     `json:"last_duration"`
+	LastError           string     `json:"last_error"`
+	LastMinuteBuckets   int        `json:"last_minute_buckets"`
+	LastHourBuckets     int        `json:"last_hour_buckets"`
+	LastSamplesDeleted  int        `json:"last_samples_deleted"`
+	LastMinutesDeleted  int        `json:"last_minutes_deleted"`
+	TotalSamplesDeleted int        `json:"total_samples_deleted"`
+	TotalMinutesDeleted int        `json:"total_minutes_deleted"`
+}
+
+// Query selects the series of a machine. The range is either From and To,
+// as RFC 3339 times, or the duration of WorkID; Step is a Go duration.
+type Query struct {
+	Tag    string
+	From   string
+	To     string
+	WorkID string
+	Step   string
+}
+
+// Point aggregates the good quality samples of a bucket starting at Time.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Count int       `json:"count"`
+	Avg   *float64  `json:"avg"`
+	Min   *float64  `json:"min"`
+	Max   *float64  `json:"max"`
+}
+
+// Series is the downsampled history of a tag. Source is raw when the points
+// aggregate the samples, else the resolution of the aggregates they come
+// from.
+type Series struct {
+	Tag    string  `json:"tag"`
+	Step   string  `json:"step"`
+	Source string  `json:"source"`
+	Points []Point `json:"points"`
+}
+
+// Bucket is a bucket of a series as aggregated by the store: Index counts
+// the steps from the start of the range.
+type Bucket struct {
+	Tag   string
+	Index int64
+	Count int
+	Avg   *float64
+	Min   *float64
+	Max   *float64
+}
+
+// RollupRange is what is left to roll up into a resolution: First is the
+// earliest sample, or aggregate, of the source and End the end of the last
+// bucket rolled up. Either is nil when there is none.
+type RollupRange struct {
+	First *time.Time
+	End   *time.Time
+}
+
+// Range is the time span of a work.
+type Range struct {
+	From time.Time
+	To   *time.Time
+}
+
+// Value converts a tag value to the number stored for it, reporting false
+// when the value is not numeric.
+func Value(data interface{}) (float64, bool) {
+	switch v := data.(type) {
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,104 @@
+package sample
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Recorder buffers the tag notifications and stores them in batches, so
+// that recording a sample never blocks the OPC UA subscriptions.
+type Recorder struct {
+	store   Storer
+	cfg     RecorderConfig
+	log     *log.Logger
+	samples chan Sample
+}
+
+func NewRecorder(store Storer, cfg RecorderConfig, log *log.Logger) *Recorder {
+	return &Recorder{
+		store:   store,
+		cfg:     cfg,
+		log:     log,
+		samples: make(chan Sample, cfg.BatchSize*4),
+	}
+}
+
+// Record queues a notification of the tag. The sample is dropped when the
+// queue is full, i.e. when the database is slower than the PLC.
+func (r *Recorder) Record(machine, tag string, data interface{}, quality uint32, timestamp time.Time) {
+	if r == nil {
+		return
+	}
+
+	sm := Sample{Machine: machine, Tag: tag, Quality: quality, Time: timestamp}
+	if v, ok := Value(data); ok {
+		sm.Value = &v
+	}
+
+	select {
+	case r.samples <- sm:
+	default:
+		r.log.Printf("samples: queue full, dropping sample of %s %s", machine, tag)
+	}
+}
+
+// Run stores the queued samples every BatchSize samples or FlushInterval,
+// whichever comes first, until ctx is done, and then flushes what is left.
+// The samples of a failed batch are kept and retried at the next interval,
+// up to MaxPending; beyond it the oldest are dropped.
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	pending := make([]Sample, 0, r.cfg.BatchSize)
+	failing := false
+	for {
+		select {
+		case sm := <-r.samples:
+			pending = append(pending, sm)
+			if len(pending) >= r.cfg.BatchSize && !failing {
+				pending, failing = r.flush(ctx, pending)
+			}
+
+		case <-ticker.C:
+			pending, failing = r.flush(ctx, pending)
+
+		case <-ctx.Done():
+		drain:
+			for {
+				select {
+				case sm := <-r.samples:
+					pending = append(pending, sm)
+				default:
+					break drain
+				}
+			}
+
+			// ctx is done: the last batch gets a bounded context of its own.
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			r.flush(flushCtx, pending)
+			cancel()
+			return
+		}
+	}
+}
+
+// flush stores the pending samples and returns those left to store, and
+// whether storing them failed.
+func (r *Recorder) flush(ctx context.Context, pending []Sample) ([]Sample, bool) {
+	if len(pending) == 0 {
+		return pending, false
+	}
+
+	if err := r.store.InsertSamples(ctx, pending); err != nil {
+		r.log.Printf("samples: storing %d samples: %s", len(pending), err)
+		if over := len(pending) - r.cfg.MaxPending; over > 0 {
+			r.log.Printf("samples: dropping %d samples", over)
+			pending = append(pending[:0], pending[over:]...)
+		}
+		return pending, true
+	}
+
+	return pending[:0], false
+}
@@ -0,0 +1,169 @@
+package sample
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/devsamuele/service-kit/web"
+)
+
+// Service returns the history of the monitored tags.
+type Service struct {
+	store Storer
+	cfg   MaintenanceConfig
+	log   *log.Logger
+}
+
+// NewService constructs the Service. cfg is the one of the Maintainer, whose
+// retentions select where the series are read from.
+func NewService(store Storer, cfg MaintenanceConfig, log *log.Logger) Service {
+	return Service{store: store, cfg: cfg, log: log}
+}
+
+// Query returns the downsampled series of the machine, one per tag unless
+// the tag is given. The range defaults to the last 24 hours and the step to
+// a three hundredth of it, at least a second.
+//
+// A range starting before the raw samples were purged is read from the
+// aggregates, the minutes or, when these were purged too or the step is of
+// hours, the hours; the step is then rounded up to the resolution.
+func (s Service) Query(ctx context.Context, machine string, q Query, now time.Time) ([]Series, error) {
+	if _, ok := workTables[machine]; !ok {
+		return make([]Series, 0), web.NewError(fmt.Sprintf("unknown machine %s", machine), web.ErrReasonNotFound, "parameter", "machine")
+	}
+
+	from, to, err := s.queryRange(ctx, machine, q, now)
+	if err != nil {
+		return make([]Series, 0), err
+	}
+
+	if !to.After(from) {
+		return make([]Series, 0), web.NewError("to must be after from", web.ErrReasonInvalidParameter, "parameter", "to")
+	}
+
+	step := to.Sub(from) / defaultPoints
+	if q.Step != "" {
+		step, err = time.ParseDuration(q.Step)
+		if err != nil || step <= 0 {
+			return make([]Series, 0), web.NewError("invalid step", web.ErrReasonInvalidParameter, "parameter", "step")
+		}
+	}
+	step = step.Truncate(time.Millisecond)
+	if step < time.Second {
+		step = time.Second
+	}
+
+	source := s.source(from, step, now)
+	if source != "" {
+		from = from.Truncate(source.Step())
+		if rem := step % source.Step(); rem != 0 {
+			step += source.Step() - rem
+		}
+	}
+
+	if to.Sub(from)/step > maxPoints {
+		return make([]Series, 0), web.NewError(fmt.Sprintf("step %s is too small for the range, at most %d points are returned", step, maxPoints), web.ErrReasonInvalidParameter, "parameter", "step")
+	}
+
+	var buckets []Bucket
+	if source == "" {
+		buckets, err = s.store.QuerySeries(ctx, machine, q.Tag, from, to, step)
+	} else {
+		buckets, err = s.store.QueryRollupSeries(ctx, source, machine, q.Tag, from, to, step)
+	}
+	if err != nil {
+		return make([]Series, 0), err
+	}
+
+	sourceName := "raw"
+	if source != "" {
+		sourceName = string(source)
+	}
+
+	series := make([]Series, 0)
+	for _, b := range buckets {
+		if len(series) == 0 || series[len(series)-1].Tag != b.Tag {
+			series = append(series, Series{Tag: b.Tag, Step: step.String(), Source: sourceName, Points: make([]Point, 0)})
+		}
+
+		last := &series[len(series)-1]
+		last.Points = append(last.Points, Point{
+			Time:  from.Add(time.Duration(b.Index) * step),
+			Count: b.Count,
+			Avg:   b.Avg,
+			Min:   b.Min,
+			Max:   b.Max,
+		})
+	}
+
+	return series, nil
+}
+
+// source returns the resolution of the aggregates a series starting at from
+// is read from, empty for the raw samples.
+func (s Service) source(from time.Time, step time.Duration, now time.Time) Resolution {
+	if s.cfg.RawRetention <= 0 || !from.Before(now.Add(-s.cfg.RawRetention)) {
+		return ""
+	}
+
+	if step >= time.Hour || (s.cfg.MinuteRetention > 0 && from.Before(now.Add(-s.cfg.MinuteRetention))) {
+		return ResolutionHour
+	}
+
+	return ResolutionMinute
+}
+
+// queryRange returns the range of the query: the span of the work, up to now
+// while it is running, or from and to.
+func (s Service) queryRange(ctx context.Context, machine string, q Query, now time.Time) (time.Time, time.Time, error) {
+	if q.WorkID != "" {
+		if q.From != "" || q.To != "" {
+			return time.Time{}, time.Time{}, web.NewError("work_id cannot be combined with from and to", web.ErrReasonInvalidParameter, "parameter", "work_id")
+		}
+
+		id, err := strconv.Atoi(q.WorkID)
+		if err != nil {
+			return time.Time{}, time.Time{}, web.NewError("invalid work_id", web.ErrReasonInvalidParameter, "parameter", "work_id")
+		}
+
+		r, err := s.store.QueryWorkRange(ctx, machine, id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return time.Time{}, time.Time{}, web.NewError(fmt.Sprintf("work %d not found", id), web.ErrReasonNotFound, "parameter", "work_id")
+			}
+			return time.Time{}, time.Time{}, err
+		}
+
+		to := now
+		if r.To != nil {
+			// The last notifications of a work share its end time.
+			to = r.To.Add(time.Second)
+		}
+
+		return r.From, to, nil
+	}
+
+	to := now
+	if q.To != "" {
+		t, err := time.Parse(time.RFC3339, q.To)
+		if err != nil {
+			return time.Time{}, time.Time{}, web.NewError("invalid to time", web.ErrReasonInvalidParameter, "parameter", "to")
+		}
+		to = t
+	}
+
+	from := to.Add(-24 * time.Hour)
+	if q.From != "" {
+		t, err := time.Parse(time.RFC3339, q.From)
+		if err != nil {
+			return time.Time{}, time.Time{}, web.NewError("invalid from time", web.ErrReasonInvalidParameter, "parameter", "from")
+		}
+		from = t
+	}
+
+	return from, to, nil
+}
@@ -0,0 +1,76 @@
+package sample
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// SQLiteStore implements Storer on the development database.
+type SQLiteStore struct {
+	db  *sql.DB
+	log *log.Logger
+}
+
+func NewSQLiteStore(db *sql.DB, log *log.Logger) SQLiteStore {
+	return SQLiteStore{db: db, log: log}
+}
+
+func (s SQLiteStore) InsertSamples(ctx context.Context, samples []Sample) error {
+	return insertSamples(ctx, s.db, samples, func(n int) string { return fmt.Sprintf("?%d", n) })
+}
+
+func (s SQLiteStore) QueryWorkRange(ctx context.Context, machine string, workID int) (Range, error) {
+	return queryWorkRange(ctx, s.db, machine, workID, "?1")
+}
+
+func (s SQLiteStore) QuerySeries(ctx context.Context, machine, tag string, from, to time.Time, step time.Duration) ([]Bucket, error) {
+	return querySeries(ctx, s.db, `select tag, bucket, count(value), avg(value), min(value), max(value)
+	from (select tag, (ts - ?3) / ?5 as bucket, case when quality < ?6 then value end as value
+	from xCampioneTag where machine = ?1 and (?2 = '' or tag = ?2) and ts >= ?3 and ts < ?4) b
+	group by tag, bucket order by tag, bucket`, machine, tag, from.UnixMilli(), to.UnixMilli(), step.Milliseconds(), int64(qualityUncertain))
+}
+
+func (s SQLiteStore) QueryRollupSeries(ctx context.Context, r Resolution, machine, tag string, from, to time.Time, step time.Duration) ([]Bucket, error) {
+	return querySeries(ctx, s.db, fmt.Sprintf(`select tag, bucket, sum(value_count), sum(value_avg * value_count) / sum(value_count), min(value_min), max(value_max)
+	from (select tag, (bucket - ?3) / ?5 as bucket, value_count, value_avg, value_min, value_max
+	from %s where machine = ?1 and (?2 = '' or tag = ?2) and bucket >= ?3 and bucket < ?4) b
+	group by tag, bucket order by tag, bucket`, rollupTables[r]), machine, tag, from.UnixMilli(), to.UnixMilli(), step.Milliseconds())
+}
+
+func (s SQLiteStore) QueryRollupRange(ctx context.Context, r Resolution) (RollupRange, error) {
+	return queryRollupRange(ctx, s.db, r)
+}
+
+func (s SQLiteStore) Rollup(ctx context.Context, r Resolution, from, to time.Time) (int, error) {
+	insert := `insert into xCampioneTagMinuto (machine, tag, bucket, value_count, value_min, value_max, value_avg, value_last)
+	select g.machine, g.tag, g.bucket, g.value_count, g.value_min, g.value_max, g.value_avg,
+	(select s.value from xCampioneTag s where s.machine = g.machine and s.tag = g.tag and s.ts >= g.bucket and s.ts < g.bucket + ?3
+	and s.quality < ?4 and s.value is not null order by s.ts desc, s.id desc limit 1)
+	from (select machine, tag, ts / ?3 * ?3 as bucket, count(*) as value_count, min(value) as value_min, max(value) as value_max, avg(value) as value_avg
+	from xCampioneTag where ts >= ?1 and ts < ?2 and quality < ?4 and value is not null group by machine, tag, ts / ?3 * ?3) g`
+	args := []interface{}{from.UnixMilli(), to.UnixMilli(), r.Step().Milliseconds(), int64(qualityUncertain)}
+
+	if r == ResolutionHour {
+		insert = `insert into xCampioneTagOra (machine, tag, bucket, value_count, value_min, value_max, value_avg, value_last)
+		select g.machine, g.tag, g.bucket, g.value_count, g.value_min, g.value_max, g.value_avg,
+		(select m.value_last from xCampioneTagMinuto m where m.machine = g.machine and m.tag = g.tag and m.bucket >= g.bucket and m.bucket < g.bucket + ?3
+		order by m.bucket desc limit 1)
+		from (select machine, tag, bucket / ?3 * ?3 as bucket, sum(value_count) as value_count, min(value_min) as value_min, max(value_max) as value_max,
+		sum(value_avg * value_count) / sum(value_count) as value_avg
+		from xCampioneTagMinuto where bucket >= ?1 and bucket < ?2 group by machine, tag, bucket / ?3 * ?3) g`
+		args = args[:3]
+	}
+
+	return rollup(ctx, s.db, fmt.Sprintf(`delete from %s where bucket >= ?1 and bucket < ?2`, rollupTables[r]), insert, args...)
+}
+
+func (s SQLiteStore) PurgeSamples(ctx context.Context, before time.Time) (int, error) {
+	return purge(ctx, s.db, fmt.Sprintf(`delete from xCampioneTag where id in (select id from xCampioneTag where ts < ?1 limit %d)`, purgeChunk), before)
+}
+
+func (s SQLiteStore) PurgeMinutes(ctx context.Context, before time.Time) (int, error) {
+	return purge(ctx, s.db, fmt.Sprintf(`delete from xCampioneTagMinuto where rowid in (select rowid from xCampioneTagMinuto where bucket < ?1 limit %d)`, purgeChunk), before)
+}
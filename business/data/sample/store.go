@@ -0,0 +1,284 @@
+package sample
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when the work of a series does not exist.
+var ErrNotFound = errors.New("not found")
+
+// insertChunk is the number of rows of each insert statement, well below the
+// 2100 parameters SQL Server accepts.
+const insertChunk = 200
+
+// purgeChunk is the number of rows each delete statement of a purge
+// removes, so that a purge never holds the table for long.
+const purgeChunk = 10000
+
+// rollupTables maps a resolution to the table of its aggregates.
+var rollupTables = map[Resolution]string{
+	ResolutionMinute: "xCampioneTagMinuto",
+	ResolutionHour:   "xCampioneTagOra",
+}
+
+// workTables maps a machine to the table of its works.
+var workTables = map[string]string{
+	MachineSpindryer:   "xCentrifuga",
+	MachinePasteurizer: "xPastorizzatore",
+}
+
+// Storer is the tag history. Store implements it on the Arca database,
+// SQLiteStore on the development one.
+type Storer interface {
+	InsertSamples(ctx context.Context, samples []Sample) error
+	QueryWorkRange(ctx context.Context, machine string, workID int) (Range, error)
+	QuerySeries(ctx context.Context, machine, tag string, from, to time.Time, step time.Duration) ([]Bucket, error)
+	QueryRollupSeries(ctx context.Context, r Resolution, machine, tag string, from, to time.Time, step time.Duration) ([]Bucket, error)
+	QueryRollupRange(ctx context.Context, r Resolution) (RollupRange, error)
+	Rollup(ctx context.Context, r Resolution, from, to time.Time) (int, error)
+	PurgeSamples(ctx context.Context, before time.Time) (int, error)
+	PurgeMinutes(ctx context.Context, before time.Time) (int, error)
+}
+
+type Store struct {
+	db  *sql.DB
+	log *log.Logger
+}
+
+func NewStore(db *sql.DB, log *log.Logger) Store {
+	return Store{db: db, log: log}
+}
+
+// InsertSamples stores the samples in a single transaction, with a multi-row
+// insert per chunk.
+func (s Store) InsertSamples(ctx context.Context, samples []Sample) error {
+	return insertSamples(ctx, s.db, samples, func(n int) string { return fmt.Sprintf("@p%d", n) })
+}
+
+func (s Store) QueryWorkRange(ctx context.Context, machine string, workID int) (Range, error) {
+	return queryWorkRange(ctx, s.db, machine, workID, "@p1")
+}
+
+// QuerySeries aggregates the samples of the machine in [from, to) in buckets
+// of step, of every tag when tag is empty. Values of bad or uncertain quality
+// and non-numeric values are left out of the aggregates.
+func (s Store) QuerySeries(ctx context.Context, machine, tag string, from, to time.Time, step time.Duration) ([]Bucket, error) {
+	return querySeries(ctx, s.db, `select tag, bucket, count(value), avg(value), min(value), max(value) 
+	from (select tag, (ts - @p3) / @p5 as bucket, case when quality < @p6 then value end as value 
+	from xCampioneTag where machine = @p1 and (@p2 = '' or tag = @p2) and ts >= @p3 and ts < @p4) b 
+	group by tag, bucket order by tag, bucket`, machine, tag, from.UnixMilli(), to.UnixMilli(), step.Milliseconds(), int64(qualityUncertain))
+}
+
+// QueryRollupSeries aggregates the aggregates of the resolution in buckets
+// of step, which must be a multiple of the resolution.
+func (s Store) QueryRollupSeries(ctx context.Context, r Resolution, machine, tag string, from, to time.Time, step time.Duration) ([]Bucket, error) {
+	return querySeries(ctx, s.db, fmt.Sprintf(`select tag, bucket, sum(value_count), sum(value_avg * value_count) / sum(value_count), min(value_min), max(value_max) 
+	from (select tag, (bucket - @p3) / @p5 as bucket, value_count, value_avg, value_min, value_max 
+	from %s where machine = @p1 and (@p2 = '' or tag = @p2) and bucket >= @p3 and bucket < @p4) b 
+	group by tag, bucket order by tag, bucket`, rollupTables[r]), machine, tag, from.UnixMilli(), to.UnixMilli(), step.Milliseconds())
+}
+
+func (s Store) QueryRollupRange(ctx context.Context, r Resolution) (RollupRange, error) {
+	return queryRollupRange(ctx, s.db, r)
+}
+
+// Rollup computes again the aggregates of the resolution in [from, to), from
+// the samples for the minutes and from the minutes for the hours. value_last
+// is the value of the latest sample, or minute, of the bucket.
+func (s Store) Rollup(ctx context.Context, r Resolution, from, to time.Time) (int, error) {
+	insert := `insert into xCampioneTagMinuto (machine, tag, bucket, value_count, value_min, value_max, value_avg, value_last) 
+	select g.machine, g.tag, g.bucket, g.value_count, g.value_min, g.value_max, g.value_avg, 
+	(select top(1) s.value from xCampioneTag s where s.machine = g.machine and s.tag = g.tag and s.ts >= g.bucket and s.ts < g.bucket + @p3 
+	and s.quality < @p4 and s.value is not null order by s.ts desc, s.id desc) 
+	from (select machine, tag, ts / @p3 * @p3 as bucket, count(*) as value_count, min(value) as value_min, max(value) as value_max, avg(value) as value_avg 
+	from xCampioneTag where ts >= @p1 and ts < @p2 and quality < @p4 and value is not null group by machine, tag, ts / @p3 * @p3) g`
+	args := []interface{}{from.UnixMilli(), to.UnixMilli(), r.Step().Milliseconds(), int64(qualityUncertain)}
+
+	if r == ResolutionHour {
+		insert = `insert into xCampioneTagOra (machine, tag, bucket, value_count, value_min, value_max, value_avg, value_last) 
+		select g.machine, g.tag, g.bucket, g.value_count, g.value_min, g.value_max, g.value_avg, 
+		(select top(1) m.value_last from xCampioneTagMinuto m where m.machine = g.machine and m.tag = g.tag and m.bucket >= g.bucket and m.bucket < g.bucket + @p3 
+		order by m.bucket desc) 
+		from (select machine, tag, bucket / @p3 * @p3 as bucket, sum(value_count) as value_count, min(value_min) as value_min, max(value_max) as value_max, 
+		sum(value_avg * value_count) / sum(value_count) as value_avg 
+		from xCampioneTagMinuto where bucket >= @p1 and bucket < @p2 group by machine, tag, bucket / @p3 * @p3) g`
+		args = args[:3]
+	}
+
+	return rollup(ctx, s.db, fmt.Sprintf(`delete from %s where bucket >= @p1 and bucket < @p2`, rollupTables[r]), insert, args...)
+}
+
+// PurgeSamples deletes the samples older than before, in chunks.
+func (s Store) PurgeSamples(ctx context.Context, before time.Time) (int, error) {
+	return purge(ctx, s.db, fmt.Sprintf(`delete top(%d) from xCampioneTag where ts < @p1`, purgeChunk), before)
+}
+
+// PurgeMinutes deletes the minute aggregates older than before, in chunks.
+func (s Store) PurgeMinutes(ctx context.Context, before time.Time) (int, error) {
+	return purge(ctx, s.db, fmt.Sprintf(`delete top(%d) from xCampioneTagMinuto where bucket < @p1`, purgeChunk), before)
+}
+
+// insertSamples is shared by both stores, which differ only in the
+// placeholders.
+func insertSamples(ctx context.Context, db *sql.DB, samples []Sample, placeholder func(n int) string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(samples); start += insertChunk {
+		end := start + insertChunk
+		if end > len(samples) {
+			end = len(samples)
+		}
+
+		var query strings.Builder
+		query.WriteString("insert into xCampioneTag (machine, tag, value, quality, ts) values")
+		args := make([]interface{}, 0, (end-start)*5)
+		for i, sm := range samples[start:end] {
+			if i > 0 {
+				query.WriteString(",")
+			}
+			n := len(args)
+			fmt.Fprintf(&query, "(%s,%s,%s,%s,%s)", placeholder(n+1), placeholder(n+2), placeholder(n+3), placeholder(n+4), placeholder(n+5))
+			args = append(args, sm.Machine, sm.Tag, sm.Value, int64(sm.Quality), sm.Time.UnixMilli())
+		}
+
+		if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func queryWorkRange(ctx context.Context, db *sql.DB, machine string, workID int, placeholder string) (Range, error) {
+	table, ok := workTables[machine]
+	if !ok {
+		return Range{}, ErrNotFound
+	}
+
+	// The columns are read as they are, the SQLite driver only converting
+	// the ones declared as datetime, not an expression of them.
+	row := db.QueryRowContext(ctx, fmt.Sprintf(`select started_at, date, ended_at from %s where id = %s and deleted_at is null`, table, placeholder), workID)
+
+	var r Range
+	var startedAt *time.Time
+	if err := row.Scan(&startedAt, &r.From, &r.To); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Range{}, ErrNotFound
+		}
+		return Range{}, err
+	}
+
+	if startedAt != nil {
+		r.From = *startedAt
+	}
+
+	return r, nil
+}
+
+func querySeries(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]Bucket, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return make([]Bucket, 0), err
+	}
+	defer rows.Close()
+
+	buckets := make([]Bucket, 0)
+	for rows.Next() {
+		var b Bucket
+		if err := rows.Scan(&b.Tag, &b.Index, &b.Count, &b.Avg, &b.Min, &b.Max); err != nil {
+			return make([]Bucket, 0), err
+		}
+		buckets = append(buckets, b)
+	}
+
+	return buckets, rows.Err()
+}
+
+// queryRollupRange returns the span of the source of the resolution and the
+// end of its last aggregate. The statements are the same in both dialects.
+func queryRollupRange(ctx context.Context, db *sql.DB, r Resolution) (RollupRange, error) {
+	first := `select min(ts) from xCampioneTag`
+	if r == ResolutionHour {
+		first = `select min(bucket) from xCampioneTagMinuto`
+	}
+
+	var rr RollupRange
+
+	var firstMs sql.NullInt64
+	if err := db.QueryRowContext(ctx, first).Scan(&firstMs); err != nil {
+		return RollupRange{}, err
+	}
+	if firstMs.Valid {
+		t := time.UnixMilli(firstMs.Int64)
+		rr.First = &t
+	}
+
+	var lastMs sql.NullInt64
+	if err := db.QueryRowContext(ctx, fmt.Sprintf(`select max(bucket) from %s`, rollupTables[r])).Scan(&lastMs); err != nil {
+		return RollupRange{}, err
+	}
+	if lastMs.Valid {
+		t := time.UnixMilli(lastMs.Int64).Add(r.Step())
+		rr.End = &t
+	}
+
+	return rr, nil
+}
+
+// rollup replaces, in a transaction, the aggregates of a range with the
+// rows of insert, returning their count.
+func rollup(ctx context.Context, db *sql.DB, del, insert string, args ...interface{}) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, del, args[:2]...); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, insert, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), tx.Commit()
+}
+
+// purge runs the chunked delete until it removes less than a chunk,
+// returning the rows removed.
+func purge(ctx context.Context, db *sql.DB, query string, before time.Time) (int, error) {
+	total := 0
+	for {
+		res, err := db.ExecContext(ctx, query, before.UnixMilli())
+		if err != nil {
+			return total, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += int(n)
+
+		if n < purgeChunk {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// Package schema contains the versioned migrations of the MES tables and
+// applies them to the database. The migrations of sql/ target the Arca
+// database on SQL Server. The ones of sqlite/ build the offline development
+// database: its first script creates the tables as of version 10, together
+// with a fake copy of the Arca tables, and the later ones must follow the
+// versions of sql/ one by one.
+package schema
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/database"
+)
+
+//go:embed sql/*.sql sqlite/*.sql
+var files embed.FS
+
+// Migration is a script named <version>_<description>.sql. Its batches are
+// separated by GO lines, as in SQL Server Management Studio.
+type Migration struct {
+	Version     int
+	Description string
+	Script      string
+}
+
+// Applied is a migration recorded in the version table.
+type Applied struct {
+	Version     int       `json:"version" db:"version"`
+	Description string    `json:"description" db:"description"`
+	Applied     time.Time `json:"applied" db:"applied"`
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.sql$`)
+
+// dir returns the directory of the migrations of the driver.
+func dir(driver string) string {
+	if driver == database.DriverSQLite {
+		return "sqlite"
+	}
+	return "sql"
+}
+
+// Migrations returns the embedded migrations of the driver ordered by
+// version.
+func Migrations(driver string) ([]Migration, error) {
+	entries, err := files.ReadDir(dir(driver))
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	seen := make(map[int]string)
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration %s: name must be <version>_<description>.sql", e.Name())
+		}
+
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", e.Name(), err)
+		}
+
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, e.Name(), version)
+		}
+		seen[version] = e.Name()
+
+		script, err := files.ReadFile(path.Join(dir(driver), e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{
+			Version:     version,
+			Description: strings.ReplaceAll(m[2], "_", " "),
+			Script:      string(script),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Latest returns the version of the last embedded migration of the driver.
+func Latest(driver string) (int, error) {
+	migrations, err := Migrations(driver)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+const createVersionTable = `IF OBJECT_ID(N'[dbo].[xSchemaVersion]', N'U') IS NULL
+CREATE TABLE [dbo].[xSchemaVersion]
+(
+	[version] [int] NOT NULL,
+	[description] [varchar](255) NOT NULL,
+	[applied] [datetime] NOT NULL,
+	CONSTRAINT [PK_xSchemaVersion] PRIMARY KEY CLUSTERED ([version] ASC)
+)`
+
+const createVersionTableSQLite = `create table if not exists xSchemaVersion
+(
+	version integer not null primary key,
+	description text not null,
+	applied datetime not null
+)`
+
+func createVersionTableOf(driver string) string {
+	if driver == database.DriverSQLite {
+		return createVersionTableSQLite
+	}
+	return createVersionTable
+}
+
+// Current returns the last version applied to the database, zero when no
+// migration has been applied yet.
+func Current(ctx context.Context, db *sql.DB, driver string) (int, error) {
+	if _, err := db.ExecContext(ctx, createVersionTableOf(driver)); err != nil {
+		return 0, err
+	}
+
+	row := db.QueryRowContext(ctx, `select coalesce(max(version), 0) from xSchemaVersion`)
+
+	var version int
+	if err := row.Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// Migrate applies, in order, the migrations not applied yet and returns
+// them. Every migration runs in a transaction of its own together with its
+// record in the version table, and an application lock keeps two instances
+// from migrating at the same time. SQLite needs no lock: its transactions
+// already hold the write lock of the whole file.
+func Migrate(ctx context.Context, db *sql.DB, driver string, now time.Time) ([]Migration, error) {
+	migrations, err := Migrations(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.ExecContext(ctx, createVersionTableOf(driver)); err != nil {
+		return nil, err
+	}
+
+	applied := make([]Migration, 0)
+	for _, m := range migrations {
+		ok, err := apply(ctx, db, driver, m, now)
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d %s: %w", m.Version, m.Description, err)
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+
+	return applied, nil
+}
+
+func apply(ctx context.Context, db *sql.DB, driver string, m Migration, now time.Time) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	if driver != database.DriverSQLite {
+		if _, err := tx.ExecContext(ctx, `exec sp_getapplock @Resource = 'xSchemaVersion', @LockMode = 'Exclusive', @LockOwner = 'Transaction'`); err != nil {
+			return false, err
+		}
+	}
+
+	row := tx.QueryRowContext(ctx, `select count(*) from xSchemaVersion where version = @p1`, m.Version)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	if count > 0 {
+		return false, nil
+	}
+
+	for _, batch := range Batches(m.Script) {
+		if _, err := tx.ExecContext(ctx, batch); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `insert into xSchemaVersion (version, description, applied) values(@p1,@p2,@p3)`, m.Version, m.Description, now); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// QueryApplied returns the migrations recorded in the version table.
+func QueryApplied(ctx context.Context, db *sql.DB) ([]Applied, error) {
+	rows, err := db.QueryContext(ctx, `select version, description, applied from xSchemaVersion order by version`)
+	if err != nil {
+		return make([]Applied, 0), err
+	}
+	defer rows.Close()
+
+	applied := make([]Applied, 0)
+	for rows.Next() {
+		var a Applied
+		if err := rows.Scan(&a.Version, &a.Description, &a.Applied); err != nil {
+			return make([]Applied, 0), err
+		}
+		applied = append(applied, a)
+	}
+
+	return applied, nil
+}
+
+// Batches splits a script on its GO lines and drops the empty batches.
+func Batches(script string) []string {
+	batches := make([]string, 0)
+
+	var b strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(b.String()); s != "" {
+			batches = append(batches, s)
+		}
+		b.Reset()
+	}
+
+	sc := bufio.NewScanner(strings.NewReader(script))
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.EqualFold(strings.TrimSpace(line), "GO") {
+			flush()
+			continue
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	flush()
+
+	return batches
+}
@@ -0,0 +1,34 @@
+package schema
+
+import (
+	"testing"
+)
+
+func TestBatches(t *testing.T) {
+	tests := []struct {
+		script string
+		want   []string
+	}{
+		{"", []string{}},
+		{"select 1", []string{"select 1"}},
+		{"select 1\nGO\nselect 2\n", []string{"select 1", "select 2"}},
+		{"GO\n\n go \nselect 1\nGo\nGO\n", []string{"select 1"}},
+		{"create table t (\n\tid int\n)\nGO", []string{"create table t (\n\tid int\n)"}},
+		{"select 'GO'\nselect 1 -- GO\nGO", []string{"select 'GO'\nselect 1 -- GO"}},
+		{"GOTO label\nGO", []string{"GOTO label"}},
+	}
+
+	for _, tt := range tests {
+		got := Batches(tt.script)
+		if len(got) != len(tt.want) {
+			t.Errorf("Batches(%q) = %q, want %q", tt.script, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Batches(%q) = %q, want %q", tt.script, got, tt.want)
+				break
+			}
+		}
+	}
+}
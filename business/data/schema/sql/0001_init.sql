@@ -1,12 +1,13 @@
-USE [ADB_MILLEFRUTTISRL]
-GO
-
+-- The tables may already exist where the script was run by hand before the
+-- migrations were introduced.
 SET ANSI_NULLS ON
 GO
 
 SET QUOTED_IDENTIFIER ON
 GO
 
+IF OBJECT_ID(N'[dbo].[xPastorizzatore]', N'U') IS NULL
+BEGIN
 CREATE TABLE [dbo].[xPastorizzatore]
 (
 	[id] [int] IDENTITY(1,1) NOT NULL,
@@ -29,6 +30,7 @@ CREATE TABLE [dbo].[xPastorizzatore]
 	[cd_lotto] ASC
 )WITH (PAD_INDEX = OFF, STATISTICS_NORECOMPUTE = OFF, IGNORE_DUP_KEY = OFF, ALLOW_ROW_LOCKS = ON, ALLOW_PAGE_LOCKS = ON) ON [PRIMARY]
 ) ON [PRIMARY]
+END
 GO
 
 SET ANSI_NULLS ON
@@ -37,6 +39,8 @@ GO
 SET QUOTED_IDENTIFIER ON
 GO
 
+IF OBJECT_ID(N'[dbo].[xCentrifuga]', N'U') IS NULL
+BEGIN
 CREATE TABLE [dbo].[xCentrifuga]
 (
 	[id] [int] IDENTITY(1,1) NOT NULL,
@@ -59,8 +63,9 @@ CREATE TABLE [dbo].[xCentrifuga]
 	[cd_lotto] ASC
 )WITH (PAD_INDEX = OFF, STATISTICS_NORECOMPUTE = OFF, IGNORE_DUP_KEY = OFF, ALLOW_ROW_LOCKS = ON, ALLOW_PAGE_LOCKS = ON) ON [PRIMARY]
 ) ON [PRIMARY]
+END
 GO
 
 EXEC asp_du_AddAlterColumn 'Dotes', 'xId_Centrifuga', 'int NULL', '', 'ID di xCentrifuga'
 EXEC asp_du_AddAlterColumn 'Dotes', 'xId_Pastorizzatore', 'int NULL', '', 'ID di xPastorizzatore'
-
+GO
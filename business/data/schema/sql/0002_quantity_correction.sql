@@ -0,0 +1,52 @@
+SET ANSI_NULLS ON
+GO
+
+SET QUOTED_IDENTIFIER ON
+GO
+
+ALTER TABLE [dbo].[xPastorizzatore] ADD
+	[plc_basil_amount] [int] NULL,
+	[plc_packages] [int] NULL
+GO
+
+ALTER TABLE [dbo].[xCentrifuga] ADD
+	[plc_cycles] [int] NULL
+GO
+
+CREATE TABLE [dbo].[xPastorizzatoreRettifica]
+(
+	[id] [int] IDENTITY(1,1) NOT NULL,
+	[work_id] [int] NOT NULL,
+	[field] [varchar](50) NOT NULL,
+	[plc_value] [int] NOT NULL,
+	[old_value] [int] NOT NULL,
+	[new_value] [int] NOT NULL,
+	[user] [varchar](255) NOT NULL,
+	[reason] [varchar](1000) NOT NULL,
+	[created] [datetime] NOT NULL,
+	CONSTRAINT [PK_xPastorizzatoreRettifica] PRIMARY KEY CLUSTERED
+(
+	[id] ASC
+)WITH (PAD_INDEX = OFF, STATISTICS_NORECOMPUTE = OFF, IGNORE_DUP_KEY = OFF, ALLOW_ROW_LOCKS = ON, ALLOW_PAGE_LOCKS = ON) ON [PRIMARY],
+	CONSTRAINT [FK_xPastorizzatoreRettifica_xPastorizzatore] FOREIGN KEY ([work_id]) REFERENCES [dbo].[xPastorizzatore] ([id]) ON DELETE CASCADE
+) ON [PRIMARY]
+GO
+
+CREATE TABLE [dbo].[xCentrifugaRettifica]
+(
+	[id] [int] IDENTITY(1,1) NOT NULL,
+	[work_id] [int] NOT NULL,
+	[field] [varchar](50) NOT NULL,
+	[plc_value] [int] NOT NULL,
+	[old_value] [int] NOT NULL,
+	[new_value] [int] NOT NULL,
+	[user] [varchar](255) NOT NULL,
+	[reason] [varchar](1000) NOT NULL,
+	[created] [datetime] NOT NULL,
+	CONSTRAINT [PK_xCentrifugaRettifica] PRIMARY KEY CLUSTERED
+(
+	[id] ASC
+)WITH (PAD_INDEX = OFF, STATISTICS_NORECOMPUTE = OFF, IGNORE_DUP_KEY = OFF, ALLOW_ROW_LOCKS = ON, ALLOW_PAGE_LOCKS = ON) ON [PRIMARY],
+	CONSTRAINT [FK_xCentrifugaRettifica_xCentrifuga] FOREIGN KEY ([work_id]) REFERENCES [dbo].[xCentrifuga] ([id]) ON DELETE CASCADE
+) ON [PRIMARY]
+GO
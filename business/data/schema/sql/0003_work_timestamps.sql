@@ -0,0 +1,27 @@
+SET ANSI_NULLS ON
+GO
+
+SET QUOTED_IDENTIFIER ON
+GO
+
+ALTER TABLE [dbo].[xPastorizzatore] ADD
+	[started_at] [datetime] NULL,
+	[ended_at] [datetime] NULL,
+	[paused_at] [datetime] NULL,
+	[active_seconds] [int] NOT NULL CONSTRAINT [DF_xPastorizzatore_active_seconds] DEFAULT 0,
+	[paused_seconds] [int] NOT NULL CONSTRAINT [DF_xPastorizzatore_paused_seconds] DEFAULT 0
+GO
+
+ALTER TABLE [dbo].[xCentrifuga] ADD
+	[started_at] [datetime] NULL,
+	[ended_at] [datetime] NULL,
+	[paused_at] [datetime] NULL,
+	[active_seconds] [int] NOT NULL CONSTRAINT [DF_xCentrifuga_active_seconds] DEFAULT 0,
+	[paused_seconds] [int] NOT NULL CONSTRAINT [DF_xCentrifuga_paused_seconds] DEFAULT 0
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xPastorizzatore_ended_at] ON [dbo].[xPastorizzatore] ([ended_at]) INCLUDE ([cd_ar], [status], [active_seconds], [paused_seconds])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xCentrifuga_ended_at] ON [dbo].[xCentrifuga] ([ended_at]) INCLUDE ([cd_ar], [status], [active_seconds], [paused_seconds])
+GO
@@ -0,0 +1,5 @@
+ALTER TABLE [dbo].[xPastorizzatore] ADD [version] [rowversion] NOT NULL
+GO
+
+ALTER TABLE [dbo].[xCentrifuga] ADD [version] [rowversion] NOT NULL
+GO
@@ -0,0 +1,15 @@
+ALTER TABLE [dbo].[xPastorizzatore] ADD
+	[document_number] [int] NULL,
+	[document_date] [datetime] NULL
+GO
+
+ALTER TABLE [dbo].[xCentrifuga] ADD
+	[document_number] [int] NULL,
+	[document_date] [datetime] NULL
+GO
+
+CREATE NONCLUSTERED INDEX [IX_Dotes_xId_Pastorizzatore] ON [dbo].[DoTes] ([xId_Pastorizzatore]) WHERE [xId_Pastorizzatore] IS NOT NULL
+GO
+
+CREATE NONCLUSTERED INDEX [IX_Dotes_xId_Centrifuga] ON [dbo].[DoTes] ([xId_Centrifuga]) WHERE [xId_Centrifuga] IS NOT NULL
+GO
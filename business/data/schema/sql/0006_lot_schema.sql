@@ -0,0 +1,19 @@
+SET ANSI_NULLS ON
+GO
+
+SET QUOTED_IDENTIFIER ON
+GO
+
+CREATE TABLE [dbo].[xLottoSchema]
+(
+	[id] [int] IDENTITY(1,1) NOT NULL,
+	[machine] [varchar](20) NOT NULL,
+	[cd_ar] [varchar](20) NOT NULL,
+	[pattern] [varchar](100) NOT NULL,
+	CONSTRAINT [PK_xLottoSchema] PRIMARY KEY CLUSTERED
+(
+	[id] ASC
+)WITH (PAD_INDEX = OFF, STATISTICS_NORECOMPUTE = OFF, IGNORE_DUP_KEY = OFF, ALLOW_ROW_LOCKS = ON, ALLOW_PAGE_LOCKS = ON) ON [PRIMARY],
+	CONSTRAINT [UQ_xLottoSchema_machine_cd_ar] UNIQUE ([machine], [cd_ar])
+) ON [PRIMARY]
+GO
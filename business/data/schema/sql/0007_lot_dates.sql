@@ -0,0 +1,2 @@
+EXEC asp_du_AddAlterColumn 'AR', 'xGiorniScadenza', 'int NULL', '', 'Giorni di shelf life del lotto'
+GO
@@ -0,0 +1,25 @@
+SET ANSI_NULLS ON
+GO
+
+SET QUOTED_IDENTIFIER ON
+GO
+
+CREATE TABLE [dbo].[xGenealogia]
+(
+	[id] [int] IDENTITY(1,1) NOT NULL,
+	[parent_work_id] [int] NOT NULL,
+	[child_work_id] [int] NOT NULL,
+	[quantity] [int] NOT NULL,
+	[created] [datetime] NOT NULL,
+	CONSTRAINT [PK_xGenealogia] PRIMARY KEY CLUSTERED
+(
+	[id] ASC
+)WITH (PAD_INDEX = OFF, STATISTICS_NORECOMPUTE = OFF, IGNORE_DUP_KEY = OFF, ALLOW_ROW_LOCKS = ON, ALLOW_PAGE_LOCKS = ON) ON [PRIMARY],
+	CONSTRAINT [FK_xGenealogia_xCentrifuga] FOREIGN KEY ([parent_work_id]) REFERENCES [dbo].[xCentrifuga] ([id]),
+	CONSTRAINT [FK_xGenealogia_xPastorizzatore] FOREIGN KEY ([child_work_id]) REFERENCES [dbo].[xPastorizzatore] ([id]) ON DELETE CASCADE,
+	CONSTRAINT [UQ_xGenealogia_parent_child] UNIQUE ([parent_work_id], [child_work_id])
+) ON [PRIMARY]
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xGenealogia_child_work_id] ON [dbo].[xGenealogia] ([child_work_id])
+GO
@@ -0,0 +1,8 @@
+ALTER TABLE [dbo].[xPastorizzatore] ADD [order_id] [int] NULL
+GO
+
+ALTER TABLE [dbo].[xCentrifuga] ADD [order_id] [int] NULL
+GO
+
+EXEC asp_du_AddAlterColumn 'DoRig', 'xQtaProdotta', 'numeric(18,8) NULL', '', 'Quantità prodotta dalle lavorazioni'
+GO
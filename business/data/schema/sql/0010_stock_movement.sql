@@ -0,0 +1,32 @@
+SET ANSI_NULLS ON
+GO
+
+SET QUOTED_IDENTIFIER ON
+GO
+
+CREATE TABLE [dbo].[xMovimentoMagazzino]
+(
+	[id] [int] IDENTITY(1,1) NOT NULL,
+	[machine] [varchar](20) NOT NULL,
+	[work_id] [int] NOT NULL,
+	[mgmov_id] [int] NOT NULL,
+	[direction] [char](1) NOT NULL,
+	[cd_ar] [varchar](20) NOT NULL,
+	[cd_lotto] [varchar](20) NOT NULL,
+	[warehouse] [varchar](20) NOT NULL,
+	[quantity] [numeric](18, 8) NOT NULL,
+	[reversal_of] [int] NULL,
+	[created] [datetime] NOT NULL,
+	CONSTRAINT [PK_xMovimentoMagazzino] PRIMARY KEY CLUSTERED
+(
+	[id] ASC
+)WITH (PAD_INDEX = OFF, STATISTICS_NORECOMPUTE = OFF, IGNORE_DUP_KEY = OFF, ALLOW_ROW_LOCKS = ON, ALLOW_PAGE_LOCKS = ON) ON [PRIMARY],
+	CONSTRAINT [FK_xMovimentoMagazzino_reversal_of] FOREIGN KEY ([reversal_of]) REFERENCES [dbo].[xMovimentoMagazzino] ([id])
+) ON [PRIMARY]
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xMovimentoMagazzino_machine_work_id] ON [dbo].[xMovimentoMagazzino] ([machine], [work_id])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xMovimentoMagazzino_reversal_of] ON [dbo].[xMovimentoMagazzino] ([reversal_of])
+GO
@@ -0,0 +1,36 @@
+-- Indexes of the filters and sort fields of the work lists.
+CREATE NONCLUSTERED INDEX [IX_xPastorizzatore_date_id] ON [dbo].[xPastorizzatore] ([date], [id])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xPastorizzatore_created_id] ON [dbo].[xPastorizzatore] ([created], [id])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xPastorizzatore_cd_lotto] ON [dbo].[xPastorizzatore] ([cd_lotto])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xPastorizzatore_cd_ar_date] ON [dbo].[xPastorizzatore] ([cd_ar], [date])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xPastorizzatore_status_date] ON [dbo].[xPastorizzatore] ([status], [date])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xPastorizzatore_document_created_date] ON [dbo].[xPastorizzatore] ([document_created], [date])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xCentrifuga_date_id] ON [dbo].[xCentrifuga] ([date], [id])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xCentrifuga_created_id] ON [dbo].[xCentrifuga] ([created], [id])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xCentrifuga_cd_lotto] ON [dbo].[xCentrifuga] ([cd_lotto])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xCentrifuga_cd_ar_date] ON [dbo].[xCentrifuga] ([cd_ar], [date])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xCentrifuga_status_date] ON [dbo].[xCentrifuga] ([status], [date])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xCentrifuga_document_created_date] ON [dbo].[xCentrifuga] ([document_created], [date])
+GO
@@ -0,0 +1,19 @@
+-- Deleted works are kept, archived, with the user, the time and the reason
+-- of the deletion until the retention period purges them.
+ALTER TABLE [dbo].[xPastorizzatore] ADD
+	[deleted_at] [datetime] NULL,
+	[deleted_by] [varchar](255) NULL,
+	[delete_reason] [varchar](1000) NULL
+GO
+
+ALTER TABLE [dbo].[xCentrifuga] ADD
+	[deleted_at] [datetime] NULL,
+	[deleted_by] [varchar](255) NULL,
+	[delete_reason] [varchar](1000) NULL
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xPastorizzatore_deleted_at_id] ON [dbo].[xPastorizzatore] ([deleted_at], [id]) WHERE [deleted_at] IS NOT NULL
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xCentrifuga_deleted_at_id] ON [dbo].[xCentrifuga] ([deleted_at], [id]) WHERE [deleted_at] IS NOT NULL
+GO
@@ -0,0 +1,29 @@
+SET ANSI_NULLS ON
+GO
+
+SET QUOTED_IDENTIFIER ON
+GO
+
+-- Every notification of the monitored tags. The value is numeric, booleans
+-- as 0 and 1, and ts is the source timestamp in Unix milliseconds, so that
+-- the samples are bucketed with integer arithmetic alone.
+CREATE TABLE [dbo].[xCampioneTag]
+(
+	[id] [bigint] IDENTITY(1,1) NOT NULL,
+	[machine] [varchar](20) NOT NULL,
+	[tag] [varchar](255) NOT NULL,
+	[value] [float] NULL,
+	[quality] [bigint] NOT NULL,
+	[ts] [bigint] NOT NULL,
+	CONSTRAINT [PK_xCampioneTag] PRIMARY KEY CLUSTERED
+(
+	[id] ASC
+)WITH (PAD_INDEX = OFF, STATISTICS_NORECOMPUTE = OFF, IGNORE_DUP_KEY = OFF, ALLOW_ROW_LOCKS = ON, ALLOW_PAGE_LOCKS = ON) ON [PRIMARY]
+) ON [PRIMARY]
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xCampioneTag_machine_ts] ON [dbo].[xCampioneTag] ([machine], [ts]) INCLUDE ([tag], [value], [quality])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xCampioneTag_machine_tag_ts] ON [dbo].[xCampioneTag] ([machine], [tag], [ts]) INCLUDE ([value], [quality])
+GO
@@ -0,0 +1,56 @@
+SET ANSI_NULLS ON
+GO
+
+SET QUOTED_IDENTIFIER ON
+GO
+
+-- One minute aggregates of the good quality samples of xCampioneTag: bucket is
+-- the start of the interval in Unix milliseconds, value_last the latest value.
+CREATE TABLE [dbo].[xCampioneTagMinuto]
+(
+	[machine] [varchar](20) NOT NULL,
+	[tag] [varchar](255) NOT NULL,
+	[bucket] [bigint] NOT NULL,
+	[value_count] [int] NOT NULL,
+	[value_min] [float] NOT NULL,
+	[value_max] [float] NOT NULL,
+	[value_avg] [float] NOT NULL,
+	[value_last] [float] NOT NULL,
+	CONSTRAINT [PK_xCampioneTagMinuto] PRIMARY KEY CLUSTERED
+(
+	[machine] ASC,
+	[tag] ASC,
+	[bucket] ASC
+)WITH (PAD_INDEX = OFF, STATISTICS_NORECOMPUTE = OFF, IGNORE_DUP_KEY = OFF, ALLOW_ROW_LOCKS = ON, ALLOW_PAGE_LOCKS = ON) ON [PRIMARY]
+) ON [PRIMARY]
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xCampioneTagMinuto_bucket] ON [dbo].[xCampioneTagMinuto] ([bucket])
+GO
+
+-- One hour aggregates of the good quality samples of xCampioneTag: bucket is
+-- the start of the interval in Unix milliseconds, value_last the latest value.
+CREATE TABLE [dbo].[xCampioneTagOra]
+(
+	[machine] [varchar](20) NOT NULL,
+	[tag] [varchar](255) NOT NULL,
+	[bucket] [bigint] NOT NULL,
+	[value_count] [int] NOT NULL,
+	[value_min] [float] NOT NULL,
+	[value_max] [float] NOT NULL,
+	[value_avg] [float] NOT NULL,
+	[value_last] [float] NOT NULL,
+	CONSTRAINT [PK_xCampioneTagOra] PRIMARY KEY CLUSTERED
+(
+	[machine] ASC,
+	[tag] ASC,
+	[bucket] ASC
+)WITH (PAD_INDEX = OFF, STATISTICS_NORECOMPUTE = OFF, IGNORE_DUP_KEY = OFF, ALLOW_ROW_LOCKS = ON, ALLOW_PAGE_LOCKS = ON) ON [PRIMARY]
+) ON [PRIMARY]
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xCampioneTagOra_bucket] ON [dbo].[xCampioneTagOra] ([bucket])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xCampioneTag_ts] ON [dbo].[xCampioneTag] ([ts])
+GO
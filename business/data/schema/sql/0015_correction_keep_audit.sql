@@ -0,0 +1,21 @@
+SET ANSI_NULLS ON
+GO
+
+SET QUOTED_IDENTIFIER ON
+GO
+
+-- The corrections are the audit trail of a work: removing the work must not
+-- remove them, so a corrected work is never purged.
+ALTER TABLE [dbo].[xPastorizzatoreRettifica] DROP CONSTRAINT [FK_xPastorizzatoreRettifica_xPastorizzatore]
+GO
+
+ALTER TABLE [dbo].[xPastorizzatoreRettifica] ADD CONSTRAINT [FK_xPastorizzatoreRettifica_xPastorizzatore]
+	FOREIGN KEY ([work_id]) REFERENCES [dbo].[xPastorizzatore] ([id]) ON DELETE NO ACTION
+GO
+
+ALTER TABLE [dbo].[xCentrifugaRettifica] DROP CONSTRAINT [FK_xCentrifugaRettifica_xCentrifuga]
+GO
+
+ALTER TABLE [dbo].[xCentrifugaRettifica] ADD CONSTRAINT [FK_xCentrifugaRettifica_xCentrifuga]
+	FOREIGN KEY ([work_id]) REFERENCES [dbo].[xCentrifuga] ([id]) ON DELETE NO ACTION
+GO
@@ -0,0 +1,38 @@
+SET ANSI_NULLS ON
+GO
+
+SET QUOTED_IDENTIFIER ON
+GO
+
+-- The times of the service were written in the local time of the server,
+-- Italy, and read back as UTC. From now on they are written in UTC: the
+-- rows written so far are converted.
+UPDATE [dbo].[xCentrifuga] SET
+	[date] = CONVERT(datetime, [date] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC'),
+	[started_at] = CONVERT(datetime, [started_at] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC'),
+	[ended_at] = CONVERT(datetime, [ended_at] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC'),
+	[paused_at] = CONVERT(datetime, [paused_at] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC'),
+	[created] = CONVERT(datetime, [created] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC'),
+	[deleted_at] = CONVERT(datetime, [deleted_at] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC')
+GO
+
+UPDATE [dbo].[xPastorizzatore] SET
+	[date] = CONVERT(datetime, [date] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC'),
+	[started_at] = CONVERT(datetime, [started_at] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC'),
+	[ended_at] = CONVERT(datetime, [ended_at] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC'),
+	[paused_at] = CONVERT(datetime, [paused_at] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC'),
+	[created] = CONVERT(datetime, [created] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC'),
+	[deleted_at] = CONVERT(datetime, [deleted_at] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC')
+GO
+
+UPDATE [dbo].[xCentrifugaRettifica] SET [created] = CONVERT(datetime, [created] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC')
+GO
+
+UPDATE [dbo].[xPastorizzatoreRettifica] SET [created] = CONVERT(datetime, [created] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC')
+GO
+
+UPDATE [dbo].[xGenealogia] SET [created] = CONVERT(datetime, [created] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC')
+GO
+
+UPDATE [dbo].[xMovimentoMagazzino] SET [created] = CONVERT(datetime, [created] AT TIME ZONE 'W. Europe Standard Time' AT TIME ZONE 'UTC')
+GO
@@ -0,0 +1,23 @@
+SET ANSI_NULLS ON
+GO
+
+SET QUOTED_IDENTIFIER ON
+GO
+
+-- Codes handed out by POST /lots/next, kept from the next lot numbering
+-- until the work using them is inserted or they expire.
+CREATE TABLE [dbo].[xLottoPrenotazione]
+(
+	[id] [int] IDENTITY(1,1) NOT NULL,
+	[machine] [varchar](20) NOT NULL,
+	[cd_ar] [varchar](20) NOT NULL,
+	[cd_lotto] [varchar](20) NOT NULL,
+	[expires_at] [datetime] NOT NULL,
+	[created] [datetime] NOT NULL,
+	CONSTRAINT [PK_xLottoPrenotazione] PRIMARY KEY CLUSTERED
+(
+	[id] ASC
+)WITH (PAD_INDEX = OFF, STATISTICS_NORECOMPUTE = OFF, IGNORE_DUP_KEY = OFF, ALLOW_ROW_LOCKS = ON, ALLOW_PAGE_LOCKS = ON) ON [PRIMARY],
+	CONSTRAINT [UQ_xLottoPrenotazione_cd_lotto] UNIQUE ([cd_lotto])
+) ON [PRIMARY]
+GO
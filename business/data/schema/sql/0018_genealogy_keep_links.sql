@@ -0,0 +1,15 @@
+SET ANSI_NULLS ON
+GO
+
+SET QUOTED_IDENTIFIER ON
+GO
+
+-- The genealogy is the traceability of the lots: removing a pasteurizer work
+-- must not remove the spindryer works it was made from, so a work with
+-- genealogy links is never purged.
+ALTER TABLE [dbo].[xGenealogia] DROP CONSTRAINT [FK_xGenealogia_xPastorizzatore]
+GO
+
+ALTER TABLE [dbo].[xGenealogia] ADD CONSTRAINT [FK_xGenealogia_xPastorizzatore]
+	FOREIGN KEY ([child_work_id]) REFERENCES [dbo].[xPastorizzatore] ([id]) ON DELETE NO ACTION
+GO
@@ -0,0 +1,20 @@
+SET ANSI_NULLS ON
+GO
+
+SET QUOTED_IDENTIFIER ON
+GO
+
+-- The corrections stay the audit trail of a work once the archive retention
+-- purges it: work_id no longer references the work, so that purging a
+-- corrected work keeps its corrections.
+ALTER TABLE [dbo].[xPastorizzatoreRettifica] DROP CONSTRAINT [FK_xPastorizzatoreRettifica_xPastorizzatore]
+GO
+
+ALTER TABLE [dbo].[xCentrifugaRettifica] DROP CONSTRAINT [FK_xCentrifugaRettifica_xCentrifuga]
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xPastorizzatoreRettifica_work_id] ON [dbo].[xPastorizzatoreRettifica] ([work_id])
+GO
+
+CREATE NONCLUSTERED INDEX [IX_xCentrifugaRettifica_work_id] ON [dbo].[xCentrifugaRettifica] ([work_id])
+GO
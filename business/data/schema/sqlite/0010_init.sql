@@ -0,0 +1,232 @@
+-- The development database starts from the tables of the MES as of version
+-- 0010 of sql/, next to a fake copy of the Arca tables with the columns the
+-- service uses and a few articles and production orders to work with.
+
+create table AR
+(
+	Cd_AR text not null primary key,
+	Descrizione text null,
+	Obsoleto integer not null default 0,
+	xGiorniScadenza integer null
+);
+
+create table ARARMisura
+(
+	Cd_AR text not null references AR (Cd_AR),
+	Cd_ARMisura text not null,
+	DefaultMisura integer not null default 0,
+	primary key (Cd_AR, Cd_ARMisura)
+);
+
+create table ARLotto
+(
+	Cd_ARLotto text not null,
+	Cd_AR text not null references AR (Cd_AR),
+	Descrizione text null,
+	DataProduzione datetime null,
+	DataScadenza datetime null,
+	UserIns text null,
+	UserUpd text null,
+	TimeIns datetime null,
+	TimeUpd datetime null,
+	primary key (Cd_ARLotto, Cd_AR)
+);
+
+create table DoTes
+(
+	Id_DoTes integer not null primary key autoincrement,
+	Cd_DO text not null,
+	NumeroDoc integer not null,
+	DataDoc datetime not null,
+	Cd_MGEsercizio text not null,
+	Cd_MG_A text null,
+	UserIns text null,
+	UserUpd text null,
+	TimeIns datetime null,
+	TimeUpd datetime null,
+	xId_Centrifuga integer null,
+	xId_Pastorizzatore integer null
+);
+
+create index IX_Dotes_xId_Centrifuga on DoTes (xId_Centrifuga) where xId_Centrifuga is not null;
+create index IX_Dotes_xId_Pastorizzatore on DoTes (xId_Pastorizzatore) where xId_Pastorizzatore is not null;
+
+create table DoRig
+(
+	Id_DoRig integer not null primary key autoincrement,
+	Id_DoTes integer not null references DoTes (Id_DoTes),
+	Riga integer not null,
+	Cd_AR text null,
+	Cd_ARLotto text null,
+	Qta numeric not null,
+	Cd_MG_A text null,
+	Cd_MGCausale text null,
+	DataDoc datetime null,
+	DataConsegna datetime null,
+	Evasa integer not null default 0,
+	xQtaProdotta numeric null,
+	UserIns text null,
+	UserUpd text null,
+	TimeIns datetime null,
+	TimeUpd datetime null
+);
+
+create table MGMov
+(
+	Id_MGMov integer not null primary key autoincrement,
+	DataMov datetime not null,
+	Cd_MGEsercizio text not null,
+	Cd_MG text not null,
+	Cd_AR text not null,
+	Cd_ARLotto text null,
+	Quantita numeric not null,
+	PartenzaArrivo text not null,
+	UserIns text null,
+	UserUpd text null,
+	TimeIns datetime null,
+	TimeUpd datetime null
+);
+
+create table xCentrifuga
+(
+	id integer not null primary key autoincrement,
+	cd_lotto text not null,
+	cd_ar text not null,
+	cycles integer not null,
+	elaborazione integer null default 0,
+	total_cycles integer not null,
+	plc_cycles integer null,
+	date datetime not null,
+	started_at datetime null,
+	ended_at datetime null,
+	paused_at datetime null,
+	active_seconds integer not null default 0,
+	paused_seconds integer not null default 0,
+	document_created integer not null,
+	document_number integer null,
+	document_date datetime null,
+	order_id integer null,
+	status text not null,
+	created datetime not null,
+	version blob not null default (randomblob(8)),
+	unique (cd_ar, cd_lotto)
+);
+
+create index IX_xCentrifuga_ended_at on xCentrifuga (ended_at);
+
+create table xPastorizzatore
+(
+	id integer not null primary key autoincrement,
+	cd_lotto text not null,
+	cd_ar text not null,
+	basil_amount integer not null,
+	elaborazione integer null default 0,
+	packages integer not null,
+	plc_basil_amount integer null,
+	plc_packages integer null,
+	date datetime not null,
+	started_at datetime null,
+	ended_at datetime null,
+	paused_at datetime null,
+	active_seconds integer not null default 0,
+	paused_seconds integer not null default 0,
+	document_created integer not null,
+	document_number integer null,
+	document_date datetime null,
+	order_id integer null,
+	status text not null,
+	created datetime not null,
+	version blob not null default (randomblob(8)),
+	unique (cd_ar, cd_lotto)
+);
+
+create index IX_xPastorizzatore_ended_at on xPastorizzatore (ended_at);
+
+create table xCentrifugaRettifica
+(
+	id integer not null primary key autoincrement,
+	work_id integer not null references xCentrifuga (id) on delete cascade,
+	field text not null,
+	plc_value integer not null,
+	old_value integer not null,
+	new_value integer not null,
+	"user" text not null,
+	reason text not null,
+	created datetime not null
+);
+
+create table xPastorizzatoreRettifica
+(
+	id integer not null primary key autoincrement,
+	work_id integer not null references xPastorizzatore (id) on delete cascade,
+	field text not null,
+	plc_value integer not null,
+	old_value integer not null,
+	new_value integer not null,
+	"user" text not null,
+	reason text not null,
+	created datetime not null
+);
+
+create table xLottoSchema
+(
+	id integer not null primary key autoincrement,
+	machine text not null,
+	cd_ar text not null,
+	pattern text not null,
+	unique (machine, cd_ar)
+);
+
+create table xGenealogia
+(
+	id integer not null primary key autoincrement,
+	parent_work_id integer not null references xCentrifuga (id),
+	child_work_id integer not null references xPastorizzatore (id) on delete cascade,
+	quantity integer not null,
+	created datetime not null,
+	unique (parent_work_id, child_work_id)
+);
+
+create index IX_xGenealogia_child_work_id on xGenealogia (child_work_id);
+
+create table xMovimentoMagazzino
+(
+	id integer not null primary key autoincrement,
+	machine text not null,
+	work_id integer not null,
+	mgmov_id integer not null,
+	direction text not null,
+	cd_ar text not null,
+	cd_lotto text not null,
+	warehouse text not null,
+	quantity numeric not null,
+	reversal_of integer null references xMovimentoMagazzino (id),
+	created datetime not null
+);
+
+create index IX_xMovimentoMagazzino_machine_work_id on xMovimentoMagazzino (machine, work_id);
+create index IX_xMovimentoMagazzino_reversal_of on xMovimentoMagazzino (reversal_of);
+
+insert into AR (Cd_AR, Descrizione, Obsoleto, xGiorniScadenza) values
+	('BAS-FRESCO', 'Basilico fresco', 0, 3),
+	('BAS-CENTR', 'Basilico centrifugato', 0, 10),
+	('PESTO-190', 'Pesto alla genovese 190 g', 0, 180),
+	('PESTO-500', 'Pesto alla genovese 500 g', 0, 120),
+	('PESTO-OLD', 'Pesto fuori produzione', 1, null);
+
+insert into ARARMisura (Cd_AR, Cd_ARMisura, DefaultMisura) values
+	('BAS-FRESCO', 'KG', 1),
+	('BAS-CENTR', 'KG', 1),
+	('PESTO-190', 'PZ', 1),
+	('PESTO-500', 'PZ', 1),
+	('PESTO-OLD', 'PZ', 1);
+
+insert into DoTes (Id_DoTes, Cd_DO, NumeroDoc, DataDoc, Cd_MGEsercizio, Cd_MG_A, UserIns, UserUpd, TimeIns, TimeUpd) values
+	(1, 'OPC', 1, '2024-01-08 00:00:00', '2024', '00001', 'dev', 'dev', '2024-01-08 00:00:00', '2024-01-08 00:00:00'),
+	(2, 'OPP', 1, '2024-01-08 00:00:00', '2024', '00001', 'dev', 'dev', '2024-01-08 00:00:00', '2024-01-08 00:00:00');
+
+insert into DoRig (Id_DoTes, Riga, Cd_AR, Qta, Cd_MG_A, DataDoc, DataConsegna, UserIns, UserUpd, TimeIns, TimeUpd) values
+	(1, 1, 'BAS-CENTR', 500, '00001', '2024-01-08 00:00:00', '2024-01-10 00:00:00', 'dev', 'dev', '2024-01-08 00:00:00', '2024-01-08 00:00:00'),
+	(1, 2, 'BAS-CENTR', 300, '00001', '2024-01-08 00:00:00', null, 'dev', 'dev', '2024-01-08 00:00:00', '2024-01-08 00:00:00'),
+	(2, 1, 'PESTO-190', 2000, '00001', '2024-01-08 00:00:00', '2024-01-12 00:00:00', 'dev', 'dev', '2024-01-08 00:00:00', '2024-01-08 00:00:00'),
+	(2, 2, 'PESTO-500', 800, '00001', '2024-01-08 00:00:00', null, 'dev', 'dev', '2024-01-08 00:00:00', '2024-01-08 00:00:00');
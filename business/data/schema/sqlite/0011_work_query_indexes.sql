@@ -0,0 +1,13 @@
+create index IX_xPastorizzatore_date_id on xPastorizzatore (date, id);
+create index IX_xPastorizzatore_created_id on xPastorizzatore (created, id);
+create index IX_xPastorizzatore_cd_lotto on xPastorizzatore (cd_lotto);
+create index IX_xPastorizzatore_cd_ar_date on xPastorizzatore (cd_ar, date);
+create index IX_xPastorizzatore_status_date on xPastorizzatore (status, date);
+create index IX_xPastorizzatore_document_created_date on xPastorizzatore (document_created, date);
+
+create index IX_xCentrifuga_date_id on xCentrifuga (date, id);
+create index IX_xCentrifuga_created_id on xCentrifuga (created, id);
+create index IX_xCentrifuga_cd_lotto on xCentrifuga (cd_lotto);
+create index IX_xCentrifuga_cd_ar_date on xCentrifuga (cd_ar, date);
+create index IX_xCentrifuga_status_date on xCentrifuga (status, date);
+create index IX_xCentrifuga_document_created_date on xCentrifuga (document_created, date);
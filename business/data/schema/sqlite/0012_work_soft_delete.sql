@@ -0,0 +1,10 @@
+alter table xPastorizzatore add column deleted_at datetime null;
+alter table xPastorizzatore add column deleted_by text null;
+alter table xPastorizzatore add column delete_reason text null;
+
+alter table xCentrifuga add column deleted_at datetime null;
+alter table xCentrifuga add column deleted_by text null;
+alter table xCentrifuga add column delete_reason text null;
+
+create index IX_xPastorizzatore_deleted_at_id on xPastorizzatore (deleted_at, id) where deleted_at is not null;
+create index IX_xCentrifuga_deleted_at_id on xCentrifuga (deleted_at, id) where deleted_at is not null;
@@ -0,0 +1,12 @@
+create table xCampioneTag
+(
+	id integer not null primary key autoincrement,
+	machine text not null,
+	tag text not null,
+	value real null,
+	quality integer not null,
+	ts integer not null
+);
+
+create index IX_xCampioneTag_machine_ts on xCampioneTag (machine, ts);
+create index IX_xCampioneTag_machine_tag_ts on xCampioneTag (machine, tag, ts);
@@ -0,0 +1,31 @@
+create table xCampioneTagMinuto
+(
+	machine text not null,
+	tag text not null,
+	bucket integer not null,
+	value_count integer not null,
+	value_min real not null,
+	value_max real not null,
+	value_avg real not null,
+	value_last real not null,
+	primary key (machine, tag, bucket)
+);
+
+create index IX_xCampioneTagMinuto_bucket on xCampioneTagMinuto (bucket);
+
+create table xCampioneTagOra
+(
+	machine text not null,
+	tag text not null,
+	bucket integer not null,
+	value_count integer not null,
+	value_min real not null,
+	value_max real not null,
+	value_avg real not null,
+	value_last real not null,
+	primary key (machine, tag, bucket)
+);
+
+create index IX_xCampioneTagOra_bucket on xCampioneTagOra (bucket);
+
+create index IX_xCampioneTag_ts on xCampioneTag (ts);
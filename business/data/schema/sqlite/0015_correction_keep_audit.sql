@@ -0,0 +1,36 @@
+-- The corrections are the audit trail of a work: removing the work must not
+-- remove them, so a corrected work is never purged. SQLite cannot alter a
+-- foreign key, the tables are rebuilt.
+create table xCentrifugaRettifica_new
+(
+	id integer not null primary key autoincrement,
+	work_id integer not null references xCentrifuga (id),
+	field text not null,
+	plc_value integer not null,
+	old_value integer not null,
+	new_value integer not null,
+	"user" text not null,
+	reason text not null,
+	created datetime not null
+);
+
+insert into xCentrifugaRettifica_new select id, work_id, field, plc_value, old_value, new_value, "user", reason, created from xCentrifugaRettifica;
+drop table xCentrifugaRettifica;
+alter table xCentrifugaRettifica_new rename to xCentrifugaRettifica;
+
+create table xPastorizzatoreRettifica_new
+(
+	id integer not null primary key autoincrement,
+	work_id integer not null references xPastorizzatore (id),
+	field text not null,
+	plc_value integer not null,
+	old_value integer not null,
+	new_value integer not null,
+	"user" text not null,
+	reason text not null,
+	created datetime not null
+);
+
+insert into xPastorizzatoreRettifica_new select id, work_id, field, plc_value, old_value, new_value, "user", reason, created from xPastorizzatoreRettifica;
+drop table xPastorizzatoreRettifica;
+alter table xPastorizzatoreRettifica_new rename to xPastorizzatoreRettifica;
@@ -0,0 +1,45 @@
+-- The documents are checked against the document types, the fiscal years
+-- and the parties of Arca, and written with the party and the unit of
+-- measure of the line.
+create table DO
+(
+	Cd_DO text not null primary key,
+	Descrizione text null,
+	TipoDocumento text not null
+);
+
+create table MGEsercizio
+(
+	Cd_MGEsercizio text not null primary key,
+	DataInizio datetime not null,
+	DataFine datetime not null
+);
+
+create table CF
+(
+	Cd_CF text not null primary key,
+	Descrizione text null
+);
+
+alter table DoTes add column TipoDocumento text null;
+alter table DoTes add column Cd_CF text null;
+alter table DoRig add column Cd_CF text null;
+alter table DoRig add column Cd_ARMisura text null;
+
+insert into DO (Cd_DO, Descrizione, TipoDocumento) values
+	('PCE', 'Produzione centrifuga', 'P'),
+	('PPA', 'Produzione pastorizzatore', 'P'),
+	('OPC', 'Ordine di produzione centrifuga', 'O'),
+	('OPP', 'Ordine di produzione pastorizzatore', 'O');
+
+insert into MGEsercizio (Cd_MGEsercizio, DataInizio, DataFine) values
+	('2024', '2024-01-01', '2024-12-31'),
+	('2025', '2025-01-01', '2025-12-31'),
+	('2026', '2026-01-01', '2026-12-31'),
+	('2027', '2027-01-01', '2027-12-31'),
+	('2028', '2028-01-01', '2028-12-31'),
+	('2029', '2029-01-01', '2029-12-31'),
+	('2030', '2030-01-01', '2030-12-31');
+
+insert into CF (Cd_CF, Descrizione) values
+	('F000001', 'Millefrutti srl');
@@ -0,0 +1,11 @@
+-- Codes handed out by POST /lots/next, kept from the next lot numbering
+-- until the work using them is inserted or they expire.
+create table xLottoPrenotazione
+(
+	id integer not null primary key autoincrement,
+	machine text not null,
+	cd_ar text not null,
+	cd_lotto text not null unique,
+	expires_at datetime not null,
+	created datetime not null
+);
@@ -0,0 +1,19 @@
+-- The genealogy is the traceability of the lots: removing a pasteurizer work
+-- must not remove the spindryer works it was made from, so a work with
+-- genealogy links is never purged. SQLite cannot alter a foreign key, the
+-- table is rebuilt.
+create table xGenealogia_new
+(
+	id integer not null primary key autoincrement,
+	parent_work_id integer not null references xCentrifuga (id),
+	child_work_id integer not null references xPastorizzatore (id),
+	quantity integer not null,
+	created datetime not null,
+	unique (parent_work_id, child_work_id)
+);
+
+insert into xGenealogia_new select id, parent_work_id, child_work_id, quantity, created from xGenealogia;
+drop table xGenealogia;
+alter table xGenealogia_new rename to xGenealogia;
+
+create index IX_xGenealogia_child_work_id on xGenealogia (child_work_id);
@@ -0,0 +1,20 @@
+-- The times of the service were written with the offset of the local time of
+-- the machine, and compared as text with the UTC ones written from now on.
+-- They are rewritten in UTC, in the format of the driver: the fraction of the
+-- second without its trailing zeros and the +00:00 offset.
+update xCentrifuga set date = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', date), '0'), '.') || '+00:00' where date not like '%+00:00';
+update xCentrifuga set started_at = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', started_at), '0'), '.') || '+00:00' where started_at not like '%+00:00';
+update xCentrifuga set ended_at = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', ended_at), '0'), '.') || '+00:00' where ended_at not like '%+00:00';
+update xCentrifuga set paused_at = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', paused_at), '0'), '.') || '+00:00' where paused_at not like '%+00:00';
+update xCentrifuga set created = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', created), '0'), '.') || '+00:00' where created not like '%+00:00';
+update xCentrifuga set deleted_at = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', deleted_at), '0'), '.') || '+00:00' where deleted_at not like '%+00:00';
+update xPastorizzatore set date = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', date), '0'), '.') || '+00:00' where date not like '%+00:00';
+update xPastorizzatore set started_at = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', started_at), '0'), '.') || '+00:00' where started_at not like '%+00:00';
+update xPastorizzatore set ended_at = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', ended_at), '0'), '.') || '+00:00' where ended_at not like '%+00:00';
+update xPastorizzatore set paused_at = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', paused_at), '0'), '.') || '+00:00' where paused_at not like '%+00:00';
+update xPastorizzatore set created = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', created), '0'), '.') || '+00:00' where created not like '%+00:00';
+update xPastorizzatore set deleted_at = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', deleted_at), '0'), '.') || '+00:00' where deleted_at not like '%+00:00';
+update xCentrifugaRettifica set created = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', created), '0'), '.') || '+00:00' where created not like '%+00:00';
+update xPastorizzatoreRettifica set created = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', created), '0'), '.') || '+00:00' where created not like '%+00:00';
+update xGenealogia set created = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', created), '0'), '.') || '+00:00' where created not like '%+00:00';
+update xMovimentoMagazzino set created = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', created), '0'), '.') || '+00:00' where created not like '%+00:00';
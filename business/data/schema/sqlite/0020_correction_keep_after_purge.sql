@@ -0,0 +1,41 @@
+-- The corrections stay the audit trail of a work once the archive retention
+-- purges it: work_id no longer references the work, so that purging a
+-- corrected work keeps its corrections. SQLite cannot drop a foreign key,
+-- the tables are rebuilt.
+create table xCentrifugaRettifica_new
+(
+	id integer not null primary key autoincrement,
+	work_id integer not null,
+	field text not null,
+	plc_value integer not null,
+	old_value integer not null,
+	new_value integer not null,
+	"user" text not null,
+	reason text not null,
+	created datetime not null
+);
+
+insert into xCentrifugaRettifica_new select id, work_id, field, plc_value, old_value, new_value, "user", reason, created from xCentrifugaRettifica;
+drop table xCentrifugaRettifica;
+alter table xCentrifugaRettifica_new rename to xCentrifugaRettifica;
+
+create index IX_xCentrifugaRettifica_work_id on xCentrifugaRettifica (work_id);
+
+create table xPastorizzatoreRettifica_new
+(
+	id integer not null primary key autoincrement,
+	work_id integer not null,
+	field text not null,
+	plc_value integer not null,
+	old_value integer not null,
+	new_value integer not null,
+	"user" text not null,
+	reason text not null,
+	created datetime not null
+);
+
+insert into xPastorizzatoreRettifica_new select id, work_id, field, plc_value, old_value, new_value, "user", reason, created from xPastorizzatoreRettifica;
+drop table xPastorizzatoreRettifica;
+alter table xPastorizzatoreRettifica_new rename to xPastorizzatoreRettifica;
+
+create index IX_xPastorizzatoreRettifica_work_id on xPastorizzatoreRettifica (work_id);
@@ -0,0 +1,23 @@
+package spindryer
+
+import (
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/events"
+)
+
+func workCreated(w Work) events.WorkCreated {
+	return events.WorkCreated{WorkID: w.ID, CdLotto: w.CdLotto, Work: w}
+}
+
+func workUpdated(w Work, change string) events.WorkUpdated {
+	return events.WorkUpdated{WorkID: w.ID, CdLotto: w.CdLotto, Change: change, Work: w}
+}
+
+func statusChanged(w Work, previous string) events.StatusChanged {
+	return events.StatusChanged{WorkID: w.ID, CdLotto: w.CdLotto, Previous: previous, Status: w.Status, Work: w}
+}
+
+// cyclesChanged reports the cycles of the work, the counter of the PLC while
+// it runs and the kilograms of basil once it is done.
+func cyclesChanged(w Work, previous int, source string) events.QuantityChanged {
+	return events.QuantityChanged{WorkID: w.ID, CdLotto: w.CdLotto, Quantity: "cycles", Previous: float64(previous), Value: float64(w.Cycles), Source: source}
+}
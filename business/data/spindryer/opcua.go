@@ -2,218 +2,523 @@ package spindryer
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"time"
 
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/arca"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/events"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/sample"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/stock"
 	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/opcuaconn"
-	"github.com/devsamuele/service-kit/ws"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/outbox"
 	"github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/ua"
 )
 
+// Node ids of the PLC tags of the machine, all under ns=2.
+const (
+	nodeOrderConf = "ns=2;s=DB_REPORT_4_0_BIT_NUOVO_ORD_CONF"
+	nodeEndWork   = "ns=2;s=DB_REPORT_4_0_IMP_IN_CICLO_AUT"
+	nodeBatchTot  = "ns=2;s=DB_REPORT_4_0_BATCH_TOTALIZZATORE"
+
+	nodeLotFromMes      = "ns=2;s=DB_REPORT_4_0_LOTTO_DA_MES"
+	nodeNewOrderFromMes = "ns=2;s=DB_REPORT_4_0_BIT_NUOVO_ORD_DA_MES"
+)
+
+// eventQueueSize bounds the notifications waiting for the event loop.
+const eventQueueSize = 256
+
+// replayInterval is how often the notifications left in the outbox are
+// applied again while the database is unreachable.
+const replayInterval = 5 * time.Second
+
+// maxAttempts is how many times in a row a notification is applied while the
+// database is reachable before it is moved to the dead-letter file.
+const maxAttempts = 5
+
+// event is a tag notification queued for the event loop, with the values of
+// the other tags it is applied against. An event without a node asks the
+// loop to reload the active work from the database.
+type event struct {
+	node      string
+	value     interface{}
+	timestamp time.Time
+	related   map[string]interface{}
+}
+
+// OpcuaService processes the spindryer tag notifications one at a time, in
+// the order they are received, against an in-memory copy of the active work.
 type OpcuaService struct {
-	ctx   context.Context
-	c     *opcua.Client
-	log   *log.Logger
-	store Store
-	io    *ws.EventEmitter
+	ctx       context.Context
+	c         *opcua.Client
+	log       *log.Logger
+	store     Storer
+	arca      arca.Storer
+	lot       arca.LotConfig
+	orderUnit string
+	publisher events.Publisher
+	outbox    *outbox.Journal
+	stock     stock.Service
+	samples   *sample.Recorder
+	events    chan event
+	work      *Work
+
+	// loaded and reconciled track the startup of the loop, which is retried
+	// until the database is reachable.
+	loaded     bool
+	reconciled bool
+
+	// backlog tracks the notification_backlog alarm, raised while the
+	// outbox cannot be applied.
+	backlog bool
 }
 
-func NewOpcuaService(ctx context.Context, log *log.Logger, c *opcua.Client, store Store, io *ws.EventEmitter) *OpcuaService {
+func NewOpcuaService(ctx context.Context, log *log.Logger, c *opcua.Client, store Storer, arcaStore arca.Storer, lot arca.LotConfig, orderUnit string, journal *outbox.Journal, stockService stock.Service, samples *sample.Recorder, publisher events.Publisher) *OpcuaService {
 	return &OpcuaService{
-		ctx:   ctx,
-		c:     c,
-		log:   log,
-		store: store,
-		io:    io,
+		ctx:       ctx,
+		c:         c,
+		log:       log,
+		store:     store,
+		arca:      arcaStore,
+		lot:       lot,
+		orderUnit: orderUnit,
+		publisher: publisher,
+		outbox:    journal,
+		stock:     stockService,
+		samples:   samples,
+		events:    make(chan event, eventQueueSize),
 	}
 }
 
 func (o *OpcuaService) Run() {
-	go o.WatchOrderConf("ns=2;s=DB_REPORT_4_0_BIT_NUOVO_ORD_CONF", 1)
-	go o.WatchEndWork("ns=2;s=DB_REPORT_4_0_IMP_IN_CICLO_AUT", 1)
-	go o.WatchBatchTot("ns=2;s=DB_REPORT_4_0_BATCH_TOTALIZZATORE", 1)
-	// go o.WatchOrderConf("ns=3;s=DB_REPORT_4_0_BIT_NUOVO_ORD_CONF", 1)
-	// go o.WatchEndWork("ns=8;s=DB_REPORT_4_0_IMP_IN_CICLO_AUT", 1)
-	// go o.WatchBatchTot("ns=8;s=DB_REPORT_4_0_BATCH_TOTALIZZATORE", 1)
-}
-
-func (o *OpcuaService) WatchBatchTot(nodeID string, clientHandle uint32) {
-	opcuaconn.Subscribe(o.ctx, o.c, nodeID, clientHandle, func(data interface{}) {
-		found := true
-		oldWork, err := o.store.QueryActiveWork(o.ctx)
-		if err != nil {
-			if errors.Is(err, ErrNotFound) {
-				found = false
+	go o.loop()
+
+	go o.watch(nodeOrderConf, 1)
+	go o.watch(nodeEndWork, 1)
+	go o.watch(nodeBatchTot, 1)
+}
+
+// Refresh asks the event loop to reload the active work after it was changed
+// outside of the loop, e.g. through the REST API.
+func (o *OpcuaService) Refresh() {
+	o.enqueue(event{})
+}
+
+func (o *OpcuaService) watch(nodeID string, clientHandle uint32) {
+	opcuaconn.Subscribe(o.ctx, o.c, nodeID, clientHandle, func(data interface{}, status ua.StatusCode, sourceTimestamp time.Time) {
+		o.samples.Record(sample.MachineSpindryer, nodeID, data, uint32(status), sourceTimestamp)
+		ev := event{node: nodeID, value: data, timestamp: sourceTimestamp}
+
+		// The batch counter when the lot is confirmed is the baseline of the
+		// cycles of the work. It is read now and journaled with the
+		// confirmation, which may be applied much later, after an outage of
+		// the database, when the counter has moved on.
+		if bit, _ := data.(bool); bit && nodeID == nodeOrderConf {
+			counter, err := opcuaconn.Read(o.ctx, o.c, nodeBatchTot)
+			if err != nil {
+				o.log.Println("spindryer batch counter:", err)
 			} else {
-				o.log.Println(err)
-				return
+				ev.related = map[string]interface{}{nodeBatchTot: counter}
 			}
 		}
 
-		if found && oldWork.Status == PROCESSING_STATUS_WORK {
-			log.Println("SPINDRYER SUBSCRIPTION - START UPDATE BATCH TOT")
-			cycles, _ := data.(int32)
-			oldWork.Cycles = int(cycles)
+		o.enqueue(ev)
+	})
+}
 
-			tx, err := o.store.BeginTx(o.ctx)
-			if err != nil {
-				o.log.Println(err)
-				return
-			}
-			defer tx.Rollback()
+func (o *OpcuaService) enqueue(ev event) {
+	select {
+	case o.events <- ev:
+	case <-o.ctx.Done():
+	}
+}
 
-			err = o.store.UpdateWork(o.ctx, tx, oldWork)
-			if err != nil {
-				o.log.Println(err)
-				return
+// loop loads the active work, applies the notifications left in the outbox,
+// reconciles the work with the live PLC state and then handles the queued
+// events until the connection is closed. Every notification is written to
+// the outbox before it is applied and removed only once it is stored in the
+// database, so the notifications received while the database is unreachable
+// are applied, in order, when it is back.
+func (o *OpcuaService) loop() {
+	ticker := time.NewTicker(replayInterval)
+	defer ticker.Stop()
+
+	o.replay()
+
+	for {
+		select {
+		case <-o.ctx.Done():
+			return
+		case <-ticker.C:
+			o.replay()
+		case ev := <-o.events:
+			if ev.node == "" {
+				if err := o.reload(); err != nil {
+					o.log.Println(err)
+				}
+				continue
 			}
 
-			if err := tx.Commit(); err != nil {
-				o.log.Println(err)
-				return
+			if _, err := o.outbox.Append(ev.node, ev.value, ev.timestamp, ev.related); err != nil {
+				o.log.Println("spindryer outbox:", err)
+				if err := o.handle(ev); err != nil {
+					o.log.Println(err)
+				}
+				continue
 			}
-			log.Println("total partial cycles:", cycles)
-			log.Println("SPINDRYER SUBSCRIPTION - END UPDATE BATCH TOT")
+			o.replay()
 		}
-	})
+	}
 }
 
-func (o *OpcuaService) WatchOrderConf(nodeID string, clientHandle uint32) {
-	opcuaconn.Subscribe(o.ctx, o.c, nodeID, clientHandle, func(data interface{}) {
-		bit, _ := data.(bool)
-		if bit {
-			found := true
-			oldWork, err := o.store.QueryActiveWork(o.ctx)
-			if err != nil {
-				if errors.Is(err, ErrNotFound) {
-					found = false
-				} else {
-					o.log.Println(err)
+// replay applies the pending notifications of the outbox in order and
+// acknowledges them one by one. It stops at the first failure, leaving the
+// rest for the next attempt, and reconciles the work with the PLC once the
+// outbox has been drained for the first time. A notification that keeps
+// failing while the database is reachable cannot be applied at all: after
+// maxAttempts it is moved to the dead-letter file, so that it does not hold
+// back the ones after it.
+func (o *OpcuaService) replay() {
+	if !o.loaded {
+		if err := o.reload(); err != nil {
+			o.log.Println(err)
+			return
+		}
+		o.loaded = true
+	}
+
+	for _, rec := range o.outbox.Pending() {
+		ev := event{node: rec.Node, value: rec.Value.V, timestamp: rec.Timestamp, related: relatedValues(rec)}
+		if err := o.handle(ev); err != nil {
+			o.log.Printf("spindryer outbox: notification %d not applied: %v", rec.Seq, err)
+			if o.store.Ping(o.ctx) == nil && o.outbox.Failed(rec.Seq) >= maxAttempts {
+				if err := o.outbox.Discard(rec, err, time.Now()); err != nil {
+					o.log.Println("spindryer outbox:", err)
 					return
 				}
+				o.log.Printf("spindryer outbox: notification %d moved to %s", rec.Seq, o.outbox.DeadPath())
+				o.publisher.Publish(events.Alarm{Code: events.AlarmNotificationDiscarded, Severity: events.SeverityError, Active: true, Message: fmt.Sprintf("spindryer notification %d discarded after %d attempts: %v", rec.Seq, maxAttempts, err)})
+				continue
 			}
+			if !o.backlog {
+				o.backlog = true
+				o.publisher.Publish(events.Alarm{Code: events.AlarmNotificationBacklog, Severity: events.SeverityWarning, Active: true, Message: "spindryer notifications are not being stored: " + err.Error()})
+			}
+			return
+		}
 
-			if found && oldWork.Status == PROCESSING_STATUS_SENT {
-				log.Println("SPINDRYER SUBSCRIPTION - START WORK")
-				work := oldWork
-				work.Status = PROCESSING_STATUS_WORK
+		if err := o.outbox.Ack(rec.Seq); err != nil {
+			o.log.Println("spindryer outbox:", err)
+			return
+		}
+	}
 
-				tx, err := o.store.BeginTx(o.ctx)
-				if err != nil {
-					o.log.Println(err)
-					return
-				}
-				defer tx.Rollback()
+	if o.backlog {
+		o.backlog = false
+		o.publisher.Publish(events.Alarm{Code: events.AlarmNotificationBacklog, Severity: events.SeverityWarning, Active: false, Message: "spindryer notifications stored"})
+	}
 
-				var totalCycles int32
-				// newTotalCycles, err := opcuaconn.Read(o.ctx, o.c, "ns=8;s=DB_REPORT_4_0_BATCH_TOTALIZZATORE")
-				newTotalCycles, err := opcuaconn.Read(o.ctx, o.c, "ns=2;s=DB_REPORT_4_0_BATCH_TOTALIZZATORE")
-				if err != nil {
-					o.log.Println(err)
-				}
+	if !o.reconciled {
+		if err := o.apply(o.reconcile); err != nil {
+			o.log.Println("spindryer reconcile:", err)
+			return
+		}
+		o.reconciled = true
+	}
+}
 
-				totalCycles, _ = newTotalCycles.(int32)
-				log.Println("total initial cycles:", totalCycles)
-				work.TotalCycles = int(totalCycles)
+// relatedValues returns the related values of the record as an event takes them.
+func relatedValues(rec outbox.Record) map[string]interface{} {
+	if len(rec.Related) == 0 {
+		return nil
+	}
 
-				err = o.store.UpdateWork(o.ctx, tx, work)
-				if err != nil {
-					o.log.Println(err)
-					return
-				}
+	related := make(map[string]interface{}, len(rec.Related))
+	for n, v := range rec.Related {
+		related[n] = v.V
+	}
+	return related
+}
 
-				b, err := json.Marshal(&work)
-				if err != nil {
-					o.log.Println(err)
-					return
-				}
+func (o *OpcuaService) handle(ev event) error {
+	switch ev.node {
+	case nodeOrderConf:
+		return o.onOrderConf(ev)
+	case nodeBatchTot:
+		return o.onBatchTot(ev)
+	case nodeEndWork:
+		return o.onEndWork(ev)
+	}
+	return nil
+}
 
-				if err := o.io.Broadcast("spindryer-status-change", b); err != nil {
-					o.log.Println(err)
-					return
-				}
+// reconcile compares the active work with the live PLC state and applies the
+// transitions and counter updates missed while the service was not
+// subscribed, e.g. because the backend was restarted during a lot. The
+// confirmation bit stays high until the PLC clears it, so a bit is only
+// taken for the work when the PLC set it after the lot was sent to the
+// machine, and the cycle bit ends the work only when it dropped after the
+// work started. A server not reporting when the bits changed gives its own
+// timestamp or the time of the read, see opcuaconn.ReadValue, and the bits
+// are then taken as they are. The work is then published as recovered.
+func (o *OpcuaService) reconcile(work Work) (Work, error) {
+	orderConf, confirmed, err := opcuaconn.ReadValue(o.ctx, o.c, nodeOrderConf)
+	if err != nil {
+		return Work{}, err
+	}
 
-				if err := tx.Commit(); err != nil {
-					o.log.Println(err)
-					return
-				}
+	inCycle, stopped, err := opcuaconn.ReadValue(o.ctx, o.c, nodeEndWork)
+	if err != nil {
+		return Work{}, err
+	}
+
+	batchTot, err := opcuaconn.Read(o.ctx, o.c, nodeBatchTot)
+	if err != nil {
+		return Work{}, err
+	}
+
+	recovered := false
+
+	if bit, _ := orderConf.(bool); bit && work.Status == PROCESSING_STATUS_SENT {
+		if confirmed.After(work.Created) {
+			work, err = o.startWork(work, confirmed, batchTot)
+			if err != nil {
+				return Work{}, err
 			}
+			recovered = true
+			o.log.Printf("SPINDRYER RECOVERED - START WORK %d", work.ID)
+		} else {
+			o.log.Printf("SPINDRYER RECONCILE - work %d: order confirmation of %v predates the lot, ignored", work.ID, confirmed)
 		}
+	}
+
+	if work.Status != PROCESSING_STATUS_WORK {
+		return work, nil
+	}
+
+	if cycles, ok := batchTot.(int32); ok && int(cycles) != work.Cycles {
+		work, err = o.updateCycles(work, int(cycles), events.SourceReconcile)
+		if err != nil {
+			return Work{}, err
+		}
+		recovered = true
+		o.log.Printf("SPINDRYER RECOVERED - UPDATE BATCH TOT %d: %d", work.ID, cycles)
+	}
+
+	if bit, _ := inCycle.(bool); !bit && work.StartedAt != nil && stopped.After(*work.StartedAt) {
+		work, err = o.endWork(work, stopped)
+		if err != nil {
+			return Work{}, err
+		}
+		recovered = true
+		o.log.Printf("SPINDRYER RECOVERED - END WORK %d", work.ID)
+	}
+
+	if recovered {
+		o.publisher.Publish(workUpdated(work, events.ChangeRecovered))
+	}
+
+	return work, nil
+}
+
+func (o *OpcuaService) onBatchTot(ev event) error {
+	cycles, _ := ev.value.(int32)
+
+	return o.apply(func(work Work) (Work, error) {
+		if work.Status != PROCESSING_STATUS_WORK {
+			return work, nil
+		}
+
+		log.Println("SPINDRYER SUBSCRIPTION - START UPDATE BATCH TOT")
+		work, err := o.updateCycles(work, int(cycles), events.SourcePLC)
+		if err != nil {
+			return Work{}, err
+		}
+		log.Println("total partial cycles:", cycles)
+		log.Println("SPINDRYER SUBSCRIPTION - END UPDATE BATCH TOT")
+		return work, nil
+	})
+}
+
+func (o *OpcuaService) onOrderConf(ev event) error {
+	if bit, _ := ev.value.(bool); !bit {
+		return nil
+	}
+
+	return o.apply(func(work Work) (Work, error) {
+		if work.Status != PROCESSING_STATUS_SENT {
+			return work, nil
+		}
+
+		log.Println("SPINDRYER SUBSCRIPTION - START WORK")
+		return o.startWork(work, ev.timestamp, ev.related[nodeBatchTot])
 	})
 }
 
-func (o *OpcuaService) WatchEndWork(nodeID string, clientHandle uint32) {
+func (o *OpcuaService) onEndWork(ev event) error {
+	if bit, _ := ev.value.(bool); bit {
+		return nil
+	}
 
-	opcuaconn.Subscribe(o.ctx, o.c, nodeID, clientHandle, func(data interface{}) {
-		bit, _ := data.(bool)
-		if !bit {
-			found := true
-			oldWork, err := o.store.QueryActiveWork(o.ctx)
-			if err != nil {
-				if errors.Is(err, ErrNotFound) {
-					found = false
-				} else {
-					o.log.Println(err)
-					return
+	return o.apply(func(work Work) (Work, error) {
+		if work.Status != PROCESSING_STATUS_WORK {
+			return work, nil
+		}
+
+		work, err := o.endWork(work, ev.timestamp)
+		if err != nil {
+			return Work{}, err
+		}
+		log.Println("SPINDRYER SUBSCRIPTION - END WORK")
+		return work, nil
+	})
+}
+
+// apply runs fn on the in-memory active work, if there is one, and keeps the
+// result. Without an in-memory work the active one is reloaded first: a work
+// inserted through the REST API reaches the loop with the refresh queued
+// after its commit, and the PLC may confirm the lot before that. When fn
+// loses an optimistic concurrency check the work is reloaded from the
+// database and fn runs again.
+func (o *OpcuaService) apply(fn func(work Work) (Work, error)) error {
+	return retryOnConflict(func() error {
+		if o.work == nil {
+			if err := o.reload(); err != nil {
+				return err
+			}
+			if o.work == nil {
+				return nil
+			}
+		}
+
+		work, err := fn(*o.work)
+		if err != nil {
+			if errors.Is(err, ErrConflict) {
+				if err := o.reload(); err != nil {
+					return err
 				}
 			}
+			return err
+		}
 
-			if found && oldWork.Status == PROCESSING_STATUS_WORK {
-				work := oldWork
-				work.Status = PROCESSING_STATUS_DONE
+		o.setWork(work)
+		return nil
+	})
+}
 
-				tx, err := o.store.BeginTx(o.ctx)
-				if err != nil {
-					o.log.Println(err)
-					return
-				}
-				defer tx.Rollback()
+// reload replaces the in-memory active work with the one stored in the database.
+func (o *OpcuaService) reload() error {
+	work, err := o.store.QueryActiveWork(o.ctx)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			o.work = nil
+			return nil
+		}
+		return err
+	}
 
-				// var totalCycles int32
-				// newTotalCycles, err := opcuaconn.Read(o.ctx, o.c, "ns=2;s=DB_REPORT_4_0_BATCH_TOTALIZZATORE")
-				// // newTotalCycles, err := opcuaconn.Read(o.ctx, o.c, "ns=8;s=DB_REPORT_4_0_BATCH_TOTALIZZATORE")
-				// if err != nil {
-				// 	o.log.Println(err)
-				// }
+	o.setWork(work)
+	return nil
+}
 
-				// totalCycles, _ = newTotalCycles.(int32)
-				// log.Println("total cycles:", totalCycles)
+func (o *OpcuaService) setWork(work Work) {
+	if work.Status == PROCESSING_STATUS_DONE {
+		o.work = nil
+		return
+	}
+	o.work = &work
+}
 
-				// TODO multiply by K
-				// work.Cycles = int(totalCycles) - oldWork.TotalCycles
-				// log.Println("total cycles result:", totalCycles)
+// startWork moves a sent work to work once the PLC has confirmed the order,
+// keeping counter, the batch counter at that moment, as the starting point.
+func (o *OpcuaService) startWork(work Work, now time.Time, counter interface{}) (Work, error) {
+	work.Status = PROCESSING_STATUS_WORK
+	started := now.UTC()
+	work.StartedAt = &started
+
+	// The confirmations journaled before the counter was kept with them
+	// fall back on the live counter.
+	if counter == nil {
+		var err error
+		counter, err = opcuaconn.Read(o.ctx, o.c, nodeBatchTot)
+		if err != nil {
+			o.log.Println(err)
+		}
+	}
 
-				work.Cycles = work.Cycles - work.TotalCycles
-				log.Println("total end cycles:", work.Cycles)
-				work.Cycles = work.Cycles * 5 // per ogni ciclo 5 kg di basilico
+	totalCycles, _ := counter.(int32)
+	log.Println("total initial cycles:", totalCycles)
+	work.TotalCycles = int(totalCycles)
+	work.Cycles = int(totalCycles)
 
-				err = o.store.UpdateWork(o.ctx, tx, work)
-				if err != nil {
-					o.log.Println(err)
-					return
-				}
+	work, err := saveWork(o.ctx, o.store, work, o.store.UpdateWorkStart)
+	if err != nil {
+		return Work{}, err
+	}
 
-				b, err := json.Marshal(&work)
-				if err != nil {
-					o.log.Println(err)
-					return
-				}
+	o.publisher.Publish(statusChanged(work, PROCESSING_STATUS_SENT))
+	o.publisher.Legacy("spindryer-status-change", work)
+	return work, nil
+}
 
-				if err := o.io.Broadcast("spindryer-status-change", b); err != nil {
-					o.log.Println(err)
-					return
-				}
+func (o *OpcuaService) updateCycles(work Work, cycles int, source string) (Work, error) {
+	previous := work.Cycles
+	work.Cycles = cycles
 
-				if err := tx.Commit(); err != nil {
-					o.log.Println(err)
-					return
-				}
+	work, err := saveWork(o.ctx, o.store, work, o.store.UpdateCycles)
+	if err != nil {
+		return Work{}, err
+	}
 
-				log.Println("SPINDRYER SUBSCRIPTION - END WORK")
+	o.publisher.Publish(cyclesChanged(work, previous, source))
+	return work, nil
+}
 
-			}
+// endWork closes the work and turns the batch counter delta into kilograms.
+func (o *OpcuaService) endWork(work Work, now time.Time) (Work, error) {
+	work.Status = PROCESSING_STATUS_DONE
+	work.End(now)
+
+	// TODO multiply by K
+	work.Cycles = work.Cycles - work.TotalCycles
+	log.Println("total end cycles:", work.Cycles)
+	work.Cycles = work.Cycles * 5 // per ogni ciclo 5 kg di basilico
+
+	tx, err := o.store.BeginTx(o.ctx)
+	if err != nil {
+		return Work{}, err
+	}
+
+	defer tx.Rollback()
+
+	version, err := o.store.UpdateWorkEnd(o.ctx, tx, work)
+	if err != nil {
+		return Work{}, err
+	}
 
+	// The lot is produced when the work ends.
+	if err := o.arca.UpdateLotDates(o.ctx, tx, o.lot, arca.NewLot{CdAr: work.CdAr, CdLotto: work.CdLotto, ProductionDate: now}, now); err != nil {
+		return Work{}, err
+	}
+
+	if work.OrderID != nil {
+		if err := o.arca.AddOrderProgress(o.ctx, tx, *work.OrderID, float64(work.Cycles), o.orderUnit, now); err != nil {
+			return Work{}, err
 		}
-	})
+	}
+
+	if err := o.stock.Post(o.ctx, tx, work.ID, producedMovement(work), nil, now); err != nil {
+		return Work{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Work{}, err
+	}
+	work.Version = version
+
+	o.publisher.Publish(statusChanged(work, PROCESSING_STATUS_WORK))
+	o.publisher.Legacy("spindryer-status-change", work)
+	return work, nil
 }
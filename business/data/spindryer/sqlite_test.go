@@ -0,0 +1,199 @@
+//go:build sqlite
+// +build sqlite
+
+package spindryer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/data/schema"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/database"
+	"github.com/devsamuele/millefrutti-industria_4_0_backend/business/sys/page"
+)
+
+// newTestStore returns a store on an in-memory database migrated to the
+// latest version. The database lives as long as its only connection.
+func newTestStore(t *testing.T) SQLiteStore {
+	t.Helper()
+
+	db, err := database.Open(database.Config{Driver: database.DriverSQLite, Path: ":memory:"})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := schema.Migrate(context.Background(), db, database.DriverSQLite, time.Now()); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	return NewSQLiteStore(db, log.New(io.Discard, "", 0))
+}
+
+func insertWork(t *testing.T, s SQLiteStore, w Work) Work {
+	t.Helper()
+	ctx := context.Background()
+
+	tx, err := s.BeginTx(ctx)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	defer tx.Rollback()
+
+	id, err := s.InsertWork(ctx, tx, w)
+	if err != nil {
+		t.Fatalf("InsertWork: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	w, err = s.QueryWorkByID(ctx, id)
+	if err != nil {
+		t.Fatalf("QueryWorkByID: %v", err)
+	}
+	return w
+}
+
+func TestSQLiteStoreWork(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	date := time.Date(2022, 9, 1, 6, 30, 0, 0, time.UTC)
+	w := insertWork(t, s, Work{CdLotto: "22244C001", CdAr: "BAS", Date: date, Status: PROCESSING_STATUS_SENT, Created: date})
+	if w.CdLotto != "22244C001" || w.Status != PROCESSING_STATUS_SENT || !w.Date.Equal(date) || len(w.Version) == 0 {
+		t.Fatalf("QueryWorkByID = %+v", w)
+	}
+
+	active, err := s.QueryActiveWork(ctx)
+	if err != nil || active.ID != w.ID {
+		t.Fatalf("QueryActiveWork = %d, %v, want %d", active.ID, err, w.ID)
+	}
+
+	// Starting the work changes its version: an update with the previous
+	// one is a conflict.
+	stale := w
+	started := date.Add(time.Minute)
+	w.Status = PROCESSING_STATUS_WORK
+	w.StartedAt = &started
+	w.Cycles = 3
+
+	tx, err := s.BeginTx(ctx)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	defer tx.Rollback()
+
+	version, err := s.UpdateWorkStart(ctx, tx, w)
+	if err != nil {
+		t.Fatalf("UpdateWorkStart: %v", err)
+	}
+	if string(version) == string(stale.Version) {
+		t.Fatal("UpdateWorkStart kept the version")
+	}
+
+	stale.Cycles = 5
+	if _, err := s.UpdateCycles(ctx, tx, stale); !errors.Is(err, ErrConflict) {
+		t.Fatalf("UpdateCycles with a stale version = %v, want %v", err, ErrConflict)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	got, err := s.QueryWorkByID(ctx, w.ID)
+	if err != nil {
+		t.Fatalf("QueryWorkByID: %v", err)
+	}
+	if got.Status != PROCESSING_STATUS_WORK || got.Cycles != 3 || got.StartedAt == nil || !got.StartedAt.Equal(started) || string(got.Version) != string(version) {
+		t.Fatalf("QueryWorkByID after start = %+v", got)
+	}
+
+	if _, err := s.QueryWorkByID(ctx, w.ID+1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("QueryWorkByID of a missing work = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestSQLiteStoreQueryWork(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	day := time.Date(2022, 9, 1, 6, 0, 0, 0, time.UTC)
+	for i, cdAr := range []string{"BAS", "BAS", "PRE"} {
+		date := day.Add(time.Duration(i) * 24 * time.Hour)
+		insertWork(t, s, Work{CdLotto: "L" + strconv.Itoa(i+1), CdAr: cdAr, Date: date, Status: PROCESSING_STATUS_DONE, Created: date})
+	}
+
+	orders, err := page.ParseSort("-date", workSortFields, "-date")
+	if err != nil {
+		t.Fatalf("ParseSort: %v", err)
+	}
+
+	cdAr := "BAS"
+	works, err := s.QueryWork(ctx, WorkFilter{CdAr: &cdAr}, orders, nil, 10)
+	if err != nil {
+		t.Fatalf("QueryWork: %v", err)
+	}
+	if len(works) != 2 || works[0].CdLotto != "L2" || works[1].CdLotto != "L1" {
+		t.Fatalf("QueryWork by article = %+v", works)
+	}
+
+	// The next page starts after the sort values of the last work.
+	works, err = s.QueryWork(ctx, WorkFilter{}, orders, sortValues(works[0], orders), 10)
+	if err != nil {
+		t.Fatalf("QueryWork after L2: %v", err)
+	}
+	if len(works) != 1 || works[0].CdLotto != "L1" {
+		t.Fatalf("QueryWork after L2 = %+v", works)
+	}
+
+	from := day.Add(24 * time.Hour)
+	works, err = s.QueryWork(ctx, WorkFilter{From: &from}, orders, nil, 10)
+	if err != nil {
+		t.Fatalf("QueryWork from: %v", err)
+	}
+	if len(works) != 2 || works[0].CdLotto != "L3" {
+		t.Fatalf("QueryWork from %v = %+v", from, works)
+	}
+}
+
+func TestSQLiteStorePurgeKeepsCorrections(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	date := time.Date(2022, 9, 1, 6, 0, 0, 0, time.UTC)
+	w := insertWork(t, s, Work{CdLotto: "L1", CdAr: "BAS", Date: date, Status: PROCESSING_STATUS_DONE, Created: date})
+
+	tx, err := s.BeginTx(ctx)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := s.InsertCorrection(ctx, tx, Correction{WorkID: w.ID, Field: "cycles", PlcValue: 1, OldValue: 1, NewValue: 2, User: "user", Reason: "reason", Created: date}); err != nil {
+		t.Fatalf("InsertCorrection: %v", err)
+	}
+
+	w.DeletedAt = &date
+	if _, err := s.UpdateDeleted(ctx, tx, w); err != nil {
+		t.Fatalf("UpdateDeleted: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	n, err := s.PurgeWorks(ctx, date.Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("PurgeWorks = %d, %v, want 1", n, err)
+	}
+
+	corrections, err := s.QueryCorrections(ctx, w.ID)
+	if err != nil || len(corrections) != 1 {
+		t.Fatalf("QueryCorrections of the work purged = %d, %v, want 1", len(corrections), err)
+	}
+}
@@ -0,0 +1,41 @@
+package stock
+
+import "time"
+
+// Directions of a movement, as PartenzaArrivo of MGMov.
+const (
+	DirectionLoad   = "A"
+	DirectionUnload = "P"
+)
+
+// Config enables the stock movements of a machine and selects the
+// warehouses the produced lots are loaded into and the consumed lots are
+// unloaded from.
+type Config struct {
+	Enabled         bool
+	LoadWarehouse   string
+	UnloadWarehouse string
+}
+
+// NewMovement is a quantity of a lot to move.
+type NewMovement struct {
+	CdAr     string
+	CdLotto  string
+	Quantity float64
+}
+
+// Movement is a movement posted to MGMov for a work. A reversal has the
+// opposite direction of the movement it reverses and ReversalOf set to its id.
+type Movement struct {
+	ID         int       `json:"id" db:"id"`
+	Machine    string    `json:"machine" db:"machine"`
+	WorkID     int       `json:"work_id" db:"work_id"`
+	MGMovID    int       `json:"mgmov_id" db:"mgmov_id"`
+	Direction  string    `json:"direction" db:"direction"`
+	CdAr       string    `json:"cd_ar" db:"cd_ar"`
+	CdLotto    string    `json:"cd_lotto" db:"cd_lotto"`
+	Warehouse  string    `json:"warehouse" db:"warehouse"`
+	Quantity   float64   `json:"quantity" db:"quantity"`
+	ReversalOf *int      `json:"reversal_of" db:"reversal_of"`
+	Created    time.Time `json:"created" db:"created"`
+}
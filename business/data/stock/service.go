@@ -0,0 +1,199 @@
+package stock
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Service posts the stock movements of the works of a machine.
+type Service struct {
+	store   Storer
+	machine string
+	cfg     Config
+	log     *log.Logger
+}
+
+func NewService(store Storer, machine string, cfg Config, log *log.Logger) Service {
+	return Service{store: store, machine: machine, cfg: cfg, log: log}
+}
+
+// Post loads the produced lot into the load warehouse and unloads the
+// consumed lots from the unload warehouse, in tx. Nothing is posted when the
+// movements of the machine are disabled.
+func (s Service) Post(ctx context.Context, tx *sql.Tx, workID int, produced NewMovement, consumed []NewMovement, now time.Time) error {
+	if !s.cfg.Enabled {
+		return nil
+	}
+
+	if err := s.post(ctx, tx, workID, DirectionLoad, s.cfg.LoadWarehouse, produced, nil, now); err != nil {
+		return err
+	}
+
+	for _, nm := range consumed {
+		if err := s.post(ctx, tx, workID, DirectionUnload, s.cfg.UnloadWarehouse, nm, nil, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reverse posts, in tx, the opposite of every movement of the work not
+// reversed yet. It also runs when the movements are disabled, so that the
+// ones posted before are still reversed.
+func (s Service) Reverse(ctx context.Context, tx *sql.Tx, workID int, now time.Time) error {
+	movements, err := s.store.QueryActiveMovements(ctx, tx, s.machine, workID)
+	if err != nil {
+		return err
+	}
+	return s.reverse(ctx, tx, workID, movements, now)
+}
+
+// ReverseLoads reverses, in tx, the load of the produced lot posted for the
+// work, once its production document loads the lot, so that the lot is not
+// counted twice.
+func (s Service) ReverseLoads(ctx context.Context, tx *sql.Tx, workID int, now time.Time) error {
+	movements, err := s.store.QueryActiveMovements(ctx, tx, s.machine, workID)
+	if err != nil {
+		return err
+	}
+	loads, _ := split(movements)
+	return s.reverse(ctx, tx, workID, loads, now)
+}
+
+// Repost brings the movements of the work in line with the corrected
+// quantities, in tx: the load of the produced lot and the unloads of the
+// consumed ones are reversed and posted again, each only when they differ
+// from the ones posted before. With the movements disabled the ones posted
+// before are left as they are, since they could only be reversed.
+func (s Service) Repost(ctx context.Context, tx *sql.Tx, workID int, produced NewMovement, consumed []NewMovement, now time.Time) error {
+	if !s.cfg.Enabled {
+		return nil
+	}
+
+	movements, err := s.store.QueryActiveMovements(ctx, tx, s.machine, workID)
+	if err != nil {
+		return err
+	}
+	loads, unloads := split(movements)
+
+	if !matches(loads, s.cfg.LoadWarehouse, []NewMovement{produced}) {
+		if err := s.reverse(ctx, tx, workID, loads, now); err != nil {
+			return err
+		}
+		if err := s.post(ctx, tx, workID, DirectionLoad, s.cfg.LoadWarehouse, produced, nil, now); err != nil {
+			return err
+		}
+	}
+
+	if !matches(unloads, s.cfg.UnloadWarehouse, consumed) {
+		if err := s.reverse(ctx, tx, workID, unloads, now); err != nil {
+			return err
+		}
+		for _, nm := range consumed {
+			if err := s.post(ctx, tx, workID, DirectionUnload, s.cfg.UnloadWarehouse, nm, nil, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// reverse posts the opposite of each of the movements.
+func (s Service) reverse(ctx context.Context, tx *sql.Tx, workID int, movements []Movement, now time.Time) error {
+	for _, m := range movements {
+		opposite := DirectionLoad
+		if m.Direction == DirectionLoad {
+			opposite = DirectionUnload
+		}
+
+		id := m.ID
+		nm := NewMovement{CdAr: m.CdAr, CdLotto: m.CdLotto, Quantity: m.Quantity}
+		if err := s.post(ctx, tx, workID, opposite, m.Warehouse, nm, &id, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// split separates the loads from the unloads.
+func split(movements []Movement) (loads, unloads []Movement) {
+	for _, m := range movements {
+		if m.Direction == DirectionLoad {
+			loads = append(loads, m)
+			continue
+		}
+		unloads = append(unloads, m)
+	}
+	return loads, unloads
+}
+
+// matches reports whether the posted movements are the ones post would
+// write for want into warehouse, in any order. Empty quantities are never
+// posted and are left out.
+func matches(posted []Movement, warehouse string, want []NewMovement) bool {
+	used := make([]bool, len(posted))
+	n := 0
+	for _, nm := range want {
+		if nm.Quantity <= 0 {
+			continue
+		}
+		n++
+
+		found := false
+		for i, m := range posted {
+			if used[i] || m.Warehouse != warehouse || m.CdAr != nm.CdAr || m.CdLotto != nm.CdLotto || m.Quantity != nm.Quantity {
+				continue
+			}
+			used[i] = true
+			found = true
+			break
+		}
+		if !found {
+			return false
+		}
+	}
+	return n == len(posted)
+}
+
+func (s Service) QueryMovements(ctx context.Context, workID int) ([]Movement, error) {
+	movements, err := s.store.QueryMovements(ctx, s.machine, workID)
+	if err != nil {
+		return make([]Movement, 0), err
+	}
+	return movements, nil
+}
+
+func (s Service) post(ctx context.Context, tx *sql.Tx, workID int, direction, warehouse string, nm NewMovement, reversalOf *int, now time.Time) error {
+	if nm.Quantity <= 0 {
+		return nil
+	}
+
+	m := Movement{
+		Machine:    s.machine,
+		WorkID:     workID,
+		Direction:  direction,
+		CdAr:       nm.CdAr,
+		CdLotto:    nm.CdLotto,
+		Warehouse:  warehouse,
+		Quantity:   nm.Quantity,
+		ReversalOf: reversalOf,
+		Created:    now,
+	}
+
+	id, err := s.store.InsertMGMov(ctx, tx, m)
+	if err != nil {
+		return err
+	}
+	m.MGMovID = id
+
+	if _, err := s.store.InsertMovement(ctx, tx, m); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package stock
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// memStore keeps the movements in memory, ignoring the transactions.
+type memStore struct {
+	movements []Movement
+}
+
+func (m *memStore) InsertMGMov(ctx context.Context, tx *sql.Tx, mv Movement) (int, error) {
+	return len(m.movements) + 1, nil
+}
+
+func (m *memStore) InsertMovement(ctx context.Context, tx *sql.Tx, mv Movement) (int, error) {
+	mv.ID = len(m.movements) + 1
+	m.movements = append(m.movements, mv)
+	return mv.ID, nil
+}
+
+func (m *memStore) QueryActiveMovements(ctx context.Context, tx *sql.Tx, machine string, workID int) ([]Movement, error) {
+	reversed := make(map[int]bool)
+	for _, mv := range m.movements {
+		if mv.ReversalOf != nil {
+			reversed[*mv.ReversalOf] = true
+		}
+	}
+
+	active := make([]Movement, 0)
+	for _, mv := range m.movements {
+		if mv.Machine == machine && mv.WorkID == workID && mv.ReversalOf == nil && !reversed[mv.ID] {
+			active = append(active, mv)
+		}
+	}
+	return active, nil
+}
+
+func (m *memStore) QueryMovements(ctx context.Context, machine string, workID int) ([]Movement, error) {
+	return m.movements, nil
+}
+
+func newTestService(store Storer) Service {
+	return NewService(store, "pasteurizer", Config{Enabled: true, LoadWarehouse: "L", UnloadWarehouse: "U"}, log.New(io.Discard, "", 0))
+}
+
+func TestRepostLoad(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := &memStore{}
+	s := newTestService(store)
+
+	produced := NewMovement{CdAr: "BAS", CdLotto: "P1", Quantity: 100}
+	consumed := []NewMovement{{CdAr: "CEN", CdLotto: "C1", Quantity: 40}, {CdAr: "CEN", CdLotto: "C2", Quantity: 60}}
+	if err := s.Post(ctx, nil, 1, produced, consumed, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// The consumed lots are the same, in another order: only the load moves.
+	produced.Quantity = 120
+	if err := s.Repost(ctx, nil, 1, produced, []NewMovement{consumed[1], consumed[0]}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.movements) != 5 {
+		t.Fatalf("movements: got %d, want 5", len(store.movements))
+	}
+
+	active, _ := store.QueryActiveMovements(ctx, nil, "pasteurizer", 1)
+	loads, unloads := split(active)
+	if len(loads) != 1 || loads[0].Quantity != 120 || loads[0].Warehouse != "L" {
+		t.Fatalf("loads: got %+v", loads)
+	}
+	if len(unloads) != 2 || unloads[0].ID != 2 || unloads[1].ID != 3 {
+		t.Fatalf("unloads: got %+v", unloads)
+	}
+}
+
+func TestRepostUnchanged(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := &memStore{}
+	s := newTestService(store)
+
+	produced := NewMovement{CdAr: "BAS", CdLotto: "P1", Quantity: 100}
+	consumed := []NewMovement{{CdAr: "CEN", CdLotto: "C1", Quantity: 40}, {CdAr: "CEN", CdLotto: "C2", Quantity: 0}}
+	if err := s.Post(ctx, nil, 1, produced, consumed, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Repost(ctx, nil, 1, produced, consumed, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.movements) != 2 {
+		t.Fatalf("movements: got %d, want 2", len(store.movements))
+	}
+}
+
+func TestRepostAfterReverseLoads(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := &memStore{}
+	s := newTestService(store)
+
+	produced := NewMovement{CdAr: "BAS", CdLotto: "P1", Quantity: 100}
+	if err := s.Post(ctx, nil, 1, produced, nil, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReverseLoads(ctx, nil, 1, now); err != nil {
+		t.Fatal(err)
+	}
+
+	active, _ := store.QueryActiveMovements(ctx, nil, "pasteurizer", 1)
+	if len(active) != 0 {
+		t.Fatalf("active after ReverseLoads: got %+v", active)
+	}
+
+	// The document is deleted: the load is posted again.
+	if err := s.Repost(ctx, nil, 1, produced, nil, now); err != nil {
+		t.Fatal(err)
+	}
+
+	active, _ = store.QueryActiveMovements(ctx, nil, "pasteurizer", 1)
+	if len(active) != 1 || active[0].Direction != DirectionLoad || active[0].Quantity != 100 {
+		t.Fatalf("active after Repost: got %+v", active)
+	}
+}
@@ -0,0 +1,86 @@
+package stock
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+)
+
+// SQLiteStore implements Storer on the fake MGMov table of the development
+// database.
+type SQLiteStore struct {
+	db  *sql.DB
+	log *log.Logger
+}
+
+func NewSQLiteStore(db *sql.DB, log *log.Logger) SQLiteStore {
+	return SQLiteStore{db: db, log: log}
+}
+
+func (s SQLiteStore) InsertMGMov(ctx context.Context, tx *sql.Tx, m Movement) (int, error) {
+	row := tx.QueryRowContext(ctx, `insert into MGMov (DataMov, Cd_MGEsercizio, Cd_MG, Cd_AR, Cd_ARLotto, Quantita, PartenzaArrivo, UserIns, UserUpd, TimeIns, TimeUpd)
+	values(?1,?2,?3,?4,?5,?6,?7,?8,?9,?10,?11) returning Id_MGMov`,
+		m.Created.Local(), strconv.Itoa(m.Created.Local().Year()), m.Warehouse, m.CdAr, m.CdLotto, m.Quantity, m.Direction, user, user, m.Created.Local(), m.Created.Local())
+
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s SQLiteStore) InsertMovement(ctx context.Context, tx *sql.Tx, m Movement) (int, error) {
+	row := tx.QueryRowContext(ctx, `insert into xMovimentoMagazzino (machine, work_id, mgmov_id, direction, cd_ar, cd_lotto, warehouse, quantity, reversal_of, created)
+	values(?1,?2,?3,?4,?5,?6,?7,?8,?9,?10) returning id`,
+		m.Machine, m.WorkID, m.MGMovID, m.Direction, m.CdAr, m.CdLotto, m.Warehouse, m.Quantity, m.ReversalOf, m.Created.UTC())
+
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s SQLiteStore) QueryActiveMovements(ctx context.Context, tx *sql.Tx, machine string, workID int) ([]Movement, error) {
+	rows, err := tx.QueryContext(ctx, `select m.id, m.machine, m.work_id, m.mgmov_id, m.direction, m.cd_ar, m.cd_lotto, m.warehouse, m.quantity, m.reversal_of, m.created
+	from xMovimentoMagazzino m where m.machine = ?1 and m.work_id = ?2 and m.reversal_of is null
+	and not exists (select 1 from xMovimentoMagazzino r where r.reversal_of = m.id) order by m.id`, machine, workID)
+	if err != nil {
+		return make([]Movement, 0), err
+	}
+	defer rows.Close()
+
+	movements := make([]Movement, 0)
+	for rows.Next() {
+		var m Movement
+		if err := rows.Scan(&m.ID, &m.Machine, &m.WorkID, &m.MGMovID, &m.Direction, &m.CdAr, &m.CdLotto, &m.Warehouse, &m.Quantity, &m.ReversalOf, &m.Created); err != nil {
+			return make([]Movement, 0), err
+		}
+		movements = append(movements, m)
+	}
+
+	return movements, nil
+}
+
+func (s SQLiteStore) QueryMovements(ctx context.Context, machine string, workID int) ([]Movement, error) {
+	rows, err := s.db.QueryContext(ctx, `select id, machine, work_id, mgmov_id, direction, cd_ar, cd_lotto, warehouse, quantity, reversal_of, created
+	from xMovimentoMagazzino where machine = ?1 and work_id = ?2 order by id`, machine, workID)
+	if err != nil {
+		return make([]Movement, 0), err
+	}
+	defer rows.Close()
+
+	movements := make([]Movement, 0)
+	for rows.Next() {
+		var m Movement
+		if err := rows.Scan(&m.ID, &m.Machine, &m.WorkID, &m.MGMovID, &m.Direction, &m.CdAr, &m.CdLotto, &m.Warehouse, &m.Quantity, &m.ReversalOf, &m.Created); err != nil {
+			return make([]Movement, 0), err
+		}
+		movements = append(movements, m)
+	}
+
+	return movements, nil
+}
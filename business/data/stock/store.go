@@ -0,0 +1,102 @@
+package stock
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+)
+
+// user is written in the Arca audit columns of the movements.
+const user = "opcua-service"
+
+// Storer is the stock movements data. Store implements it on the Arca
+// database, SQLiteStore on the development one.
+type Storer interface {
+	InsertMGMov(ctx context.Context, tx *sql.Tx, m Movement) (int, error)
+	InsertMovement(ctx context.Context, tx *sql.Tx, m Movement) (int, error)
+	QueryActiveMovements(ctx context.Context, tx *sql.Tx, machine string, workID int) ([]Movement, error)
+	QueryMovements(ctx context.Context, machine string, workID int) ([]Movement, error)
+}
+
+type Store struct {
+	db  *sql.DB
+	log *log.Logger
+}
+
+func NewStore(db *sql.DB, log *log.Logger) Store {
+	return Store{db: db, log: log}
+}
+
+// InsertMGMov posts the movement in the Arca warehouse and returns its id.
+func (s Store) InsertMGMov(ctx context.Context, tx *sql.Tx, m Movement) (int, error) {
+	row := tx.QueryRowContext(ctx, `insert into MGMov (DataMov, Cd_MGEsercizio, Cd_MG, Cd_AR, Cd_ARLotto, Quantita, PartenzaArrivo, UserIns, UserUpd, TimeIns, TimeUpd) 
+	output inserted.Id_MGMov values(@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8,@p9,@p10,@p11)`,
+		m.Created.Local(), strconv.Itoa(m.Created.Local().Year()), m.Warehouse, m.CdAr, m.CdLotto, m.Quantity, m.Direction, user, user, m.Created.Local(), m.Created.Local())
+
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s Store) InsertMovement(ctx context.Context, tx *sql.Tx, m Movement) (int, error) {
+	row := tx.QueryRowContext(ctx, `insert into xMovimentoMagazzino (machine, work_id, mgmov_id, direction, cd_ar, cd_lotto, warehouse, quantity, reversal_of, created) 
+	values(@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8,@p9,@p10); select ID = convert(bigint, SCOPE_IDENTITY())`,
+		m.Machine, m.WorkID, m.MGMovID, m.Direction, m.CdAr, m.CdLotto, m.Warehouse, m.Quantity, m.ReversalOf, m.Created.UTC())
+	if err := row.Err(); err != nil {
+		return 0, err
+	}
+
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// QueryActiveMovements returns the movements of the work that are not
+// reversals and have not been reversed yet.
+func (s Store) QueryActiveMovements(ctx context.Context, tx *sql.Tx, machine string, workID int) ([]Movement, error) {
+	rows, err := tx.QueryContext(ctx, `select m.id, m.machine, m.work_id, m.mgmov_id, m.direction, m.cd_ar, m.cd_lotto, m.warehouse, m.quantity, m.reversal_of, m.created 
+	from xMovimentoMagazzino m with (updlock) where m.machine = @p1 and m.work_id = @p2 and m.reversal_of is null 
+	and not exists (select 1 from xMovimentoMagazzino r where r.reversal_of = m.id) order by m.id`, machine, workID)
+	if err != nil {
+		return make([]Movement, 0), err
+	}
+	defer rows.Close()
+
+	movements := make([]Movement, 0)
+	for rows.Next() {
+		var m Movement
+		if err := rows.Scan(&m.ID, &m.Machine, &m.WorkID, &m.MGMovID, &m.Direction, &m.CdAr, &m.CdLotto, &m.Warehouse, &m.Quantity, &m.ReversalOf, &m.Created); err != nil {
+			return make([]Movement, 0), err
+		}
+		movements = append(movements, m)
+	}
+
+	return movements, nil
+}
+
+func (s Store) QueryMovements(ctx context.Context, machine string, workID int) ([]Movement, error) {
+	rows, err := s.db.QueryContext(ctx, `select id, machine, work_id, mgmov_id, direction, cd_ar, cd_lotto, warehouse, quantity, reversal_of, created 
+	from xMovimentoMagazzino where machine = @p1 and work_id = @p2 order by id`, machine, workID)
+	if err != nil {
+		return make([]Movement, 0), err
+	}
+	defer rows.Close()
+
+	movements := make([]Movement, 0)
+	for rows.Next() {
+		var m Movement
+		if err := rows.Scan(&m.ID, &m.Machine, &m.WorkID, &m.MGMovID, &m.Direction, &m.CdAr, &m.CdLotto, &m.Warehouse, &m.Quantity, &m.ReversalOf, &m.Created); err != nil {
+			return make([]Movement, 0), err
+		}
+		movements = append(movements, m)
+	}
+
+	return movements, nil
+}
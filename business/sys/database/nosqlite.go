@@ -0,0 +1,15 @@
+//go:build !sqlite
+// +build !sqlite
+
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// openSQLite fails on the builds without the sqlite driver, such as the
+// Windows release, built without cgo.
+func openSQLite(path string) (*sql.DB, error) {
+	return nil, errors.New("sqlite driver not built in, build with -tags sqlite")
+}
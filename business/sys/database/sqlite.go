@@ -0,0 +1,28 @@
+//go:build sqlite
+// +build sqlite
+
+package database
+
+import (
+	"database/sql"
+	"net/url"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openSQLite opens the database file in WAL mode. Transactions take the
+// write lock when they begin, the closest SQLite gets to the updlock hints
+// used on SQL Server.
+func openSQLite(path string) (*sql.DB, error) {
+	q := make(url.Values)
+	q.Set("_journal_mode", "WAL")
+	q.Set("_busy_timeout", "5000")
+	q.Set("_txlock", "immediate")
+	q.Set("_foreign_keys", "on")
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
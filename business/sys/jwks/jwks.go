@@ -0,0 +1,165 @@
+// Package jwks looks up the public keys of the tokens in a key set pinned by
+// the configuration. The jku header of a token is chosen by whoever signs it,
+// so it must never select the keys the token is verified with.
+package jwks
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// ErrNotConfigured is returned by the lookup when no key set is configured:
+// every token is then rejected.
+var ErrNotConfigured = errors.New("no key set configured")
+
+// Config is the key set and how it is fetched.
+type Config struct {
+	URL          string
+	Timeout      time.Duration
+	RefreshEvery time.Duration
+}
+
+// Cache holds the key set fetched from the configured URL. It is fetched
+// again once older than RefreshEvery, or when a token names an unknown key,
+// at most once every RefreshEvery/10. A single fetch runs at a time, outside
+// of the lock: the other lookups meanwhile use the key set held. While the
+// URL fails the last key set fetched is kept, and the fetch is retried after
+// RefreshEvery/10, then twice as long each time up to RefreshEvery.
+type Cache struct {
+	cfg Config
+
+	mu       sync.Mutex
+	set      jwk.Set
+	fetched  time.Time
+	err      error
+	next     time.Time
+	wait     time.Duration
+	fetching chan struct{}
+}
+
+// minWait bounds the interval between two fetches.
+const minWait = time.Second
+
+func New(cfg Config) *Cache {
+	return &Cache{cfg: cfg}
+}
+
+// Lookup implements auth.PublicKeyLookup, ignoring jku.
+func (c *Cache) Lookup(kid, jku string) (*rsa.PublicKey, error) {
+	if c.cfg.URL == "" {
+		return nil, ErrNotConfigured
+	}
+
+	set, err := c.keys(false)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := set.LookupKeyID(kid)
+	if !ok {
+		// The keys may have been rotated since the last fetch.
+		if set, err = c.keys(true); err == nil {
+			key, ok = set.LookupKeyID(kid)
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("key %q not found", kid)
+	}
+
+	var pk rsa.PublicKey
+	if err := key.Raw(&pk); err != nil {
+		return nil, fmt.Errorf("key %q: %w", kid, err)
+	}
+
+	return &pk, nil
+}
+
+// keys returns the key set, fetched first when it is older than
+// RefreshEvery or, with unknown set, as soon as the last fetch allows.
+func (c *Cache) keys(unknown bool) (jwk.Set, error) {
+	c.mu.Lock()
+
+	now := time.Now()
+	due := unknown || c.set == nil || now.Sub(c.fetched) > c.cfg.RefreshEvery
+	if !due || now.Before(c.next) {
+		defer c.mu.Unlock()
+		return c.current()
+	}
+
+	if c.fetching != nil {
+		// Another lookup is fetching: the key set held is used, if any,
+		// unless the key is unknown to it.
+		done := c.fetching
+		if c.set != nil && !unknown {
+			defer c.mu.Unlock()
+			return c.current()
+		}
+		c.mu.Unlock()
+		<-done
+	} else {
+		c.fetching = make(chan struct{})
+		c.mu.Unlock()
+		c.fetch()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current()
+}
+
+// current returns the last key set fetched or, when none was, the error of
+// the last fetch.
+func (c *Cache) current() (jwk.Set, error) {
+	if c.set == nil {
+		return nil, c.err
+	}
+	return c.set, nil
+}
+
+// fetch fetches the key set, without the lock, and records the result.
+func (c *Cache) fetch() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
+	defer cancel()
+
+	set, err := jwk.Fetch(ctx, c.cfg.URL)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	interval := c.cfg.RefreshEvery / 10
+	if interval < minWait {
+		interval = minWait
+	}
+
+	if err != nil {
+		c.err = fmt.Errorf("fetching key set: %w", err)
+		limit := c.cfg.RefreshEvery
+		if limit < interval {
+			limit = interval
+		}
+		c.wait *= 2
+		if c.wait < interval {
+			c.wait = interval
+		}
+		if c.wait > limit {
+			c.wait = limit
+		}
+		c.next = now.Add(c.wait)
+	} else {
+		c.set = set
+		c.fetched = now
+		c.err = nil
+		c.wait = 0
+		c.next = now.Add(interval)
+	}
+
+	close(c.fetching)
+	c.fetching = nil
+}
@@ -0,0 +1,180 @@
+package jwks
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// issuer serves the key set of its keys, or fails.
+type issuer struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	keys     map[string]*rsa.PrivateKey
+	failing  bool
+	requests int
+}
+
+func newIssuer(t *testing.T, kids ...string) *issuer {
+	is := issuer{t: t, keys: make(map[string]*rsa.PrivateKey)}
+	for _, kid := range kids {
+		is.add(kid)
+	}
+	return &is
+}
+
+func (is *issuer) add(kid string) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		is.t.Fatalf("generating key: %v", err)
+	}
+
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	is.keys[kid] = pk
+}
+
+func (is *issuer) fail(failing bool) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	is.failing = failing
+}
+
+func (is *issuer) count() int {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	return is.requests
+}
+
+func (is *issuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	is.requests++
+	if is.failing {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	set := jwk.NewSet()
+	for kid, pk := range is.keys {
+		key, err := jwk.New(pk.PublicKey)
+		if err != nil {
+			is.t.Errorf("key %s: %v", kid, err)
+			return
+		}
+		if err := key.Set(jwk.KeyIDKey, kid); err != nil {
+			is.t.Errorf("key %s: %v", kid, err)
+			return
+		}
+		set.Add(key)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(set); err != nil {
+		is.t.Errorf("encoding key set: %v", err)
+	}
+}
+
+func TestLookupNotConfigured(t *testing.T) {
+	c := New(Config{})
+	if _, err := c.Lookup("k1", ""); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Lookup = %v, want %v", err, ErrNotConfigured)
+	}
+}
+
+func TestLookupKeepsKeySet(t *testing.T) {
+	is := newIssuer(t, "k1")
+	srv := httptest.NewServer(is)
+	defer srv.Close()
+
+	c := New(Config{URL: srv.URL, Timeout: 5 * time.Second, RefreshEvery: time.Hour})
+	if _, err := c.Lookup("k1", ""); err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+
+	// The key set is due while the issuer fails: the one held is used and
+	// the fetch is not retried at every lookup.
+	is.fail(true)
+	c.mu.Lock()
+	c.fetched = c.fetched.Add(-2 * time.Hour)
+	c.next = time.Time{}
+	c.mu.Unlock()
+
+	for i := 0; i < 5; i++ {
+		if _, err := c.Lookup("k1", ""); err != nil {
+			t.Fatalf("Lookup with the issuer failing: %v", err)
+		}
+	}
+	if n := is.count(); n != 2 {
+		t.Errorf("issuer requests = %d, want 2", n)
+	}
+}
+
+func TestLookupUnknownKey(t *testing.T) {
+	is := newIssuer(t, "k1")
+	srv := httptest.NewServer(is)
+	defer srv.Close()
+
+	c := New(Config{URL: srv.URL, Timeout: 5 * time.Second, RefreshEvery: time.Hour})
+	if _, err := c.Lookup("k1", ""); err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+
+	// Right after a fetch an unknown key does not fetch again.
+	is.add("k2")
+	for i := 0; i < 5; i++ {
+		if _, err := c.Lookup("k2", ""); err == nil {
+			t.Fatal("Lookup of k2 succeeded before the key set was fetched again")
+		}
+	}
+	if n := is.count(); n != 1 {
+		t.Errorf("issuer requests = %d, want 1", n)
+	}
+
+	// Once allowed, it does.
+	c.mu.Lock()
+	c.next = time.Time{}
+	c.mu.Unlock()
+
+	if _, err := c.Lookup("k2", ""); err != nil {
+		t.Fatalf("Lookup of k2: %v", err)
+	}
+	if n := is.count(); n != 2 {
+		t.Errorf("issuer requests = %d, want 2", n)
+	}
+}
+
+func TestLookupUnreachable(t *testing.T) {
+	is := newIssuer(t, "k1")
+	is.fail(true)
+	srv := httptest.NewServer(is)
+	defer srv.Close()
+
+	c := New(Config{URL: srv.URL, Timeout: 5 * time.Second, RefreshEvery: time.Hour})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Lookup("k1", ""); err == nil {
+				t.Error("Lookup succeeded with no key set")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := is.count(); n != 1 {
+		t.Errorf("issuer requests = %d, want 1", n)
+	}
+}
@@ -4,14 +4,30 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/gopcua/opcua"
 	"github.com/gopcua/opcua/ua"
 )
 
-type SubscribeFn func(ctx context.Context, c *opcua.Client, nodeID string, clientHandle uint32, callback func(data interface{}))
+// Config is the OPC UA server of a machine.
+type Config struct {
+	Endpoint    string
+	DialTimeout time.Duration
+}
+
+// NewClient returns a client of the server, without security as the PLCs
+// expose none.
+func NewClient(cfg Config) *opcua.Client {
+	return opcua.NewClient(cfg.Endpoint, opcua.SecurityMode(ua.MessageSecurityModeNone), opcua.DialTimeout(cfg.DialTimeout))
+}
 
-func Subscribe(ctx context.Context, c *opcua.Client, nodeID string, clientHandle uint32, callback func(data interface{})) {
+type SubscribeFn func(ctx context.Context, c *opcua.Client, nodeID string, clientHandle uint32, callback func(data interface{}, status ua.StatusCode, sourceTimestamp time.Time))
+
+// Subscribe monitors nodeID and calls callback with every new value, its
+// quality and the time the PLC sampled it, falling back to the receive time
+// when the server does not report a source timestamp.
+func Subscribe(ctx context.Context, c *opcua.Client, nodeID string, clientHandle uint32, callback func(data interface{}, status ua.StatusCode, sourceTimestamp time.Time)) {
 
 	notifyCh := make(chan *opcua.PublishNotificationData)
 
@@ -52,7 +68,7 @@ func Subscribe(ctx context.Context, c *opcua.Client, nodeID string, clientHandle
 			case *ua.DataChangeNotification:
 				for _, item := range x.MonitoredItems {
 					data := item.Value.Value.Value()
-					callback(data)
+					callback(data, item.Value.Status, changedAt(item.Value, time.Now()))
 				}
 
 			default:
@@ -142,10 +158,17 @@ func Write(ctx context.Context, c *opcua.Client, nodeID string, value interface{
 }
 
 func Read(ctx context.Context, c *opcua.Client, nodeID string) (interface{}, error) {
+	value, _, err := ReadValue(ctx, c, nodeID)
+	return value, err
+}
+
+// ReadValue reads the value of the node together with the time the PLC last
+// changed it, see changedAt.
+func ReadValue(ctx context.Context, c *opcua.Client, nodeID string) (interface{}, time.Time, error) {
 
 	id, err := ua.ParseNodeID(nodeID)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 
 	rReq := ua.ReadRequest{
@@ -156,12 +179,25 @@ func Read(ctx context.Context, c *opcua.Client, nodeID string) (interface{}, err
 
 	rResp, err := c.ReadWithContext(ctx, &rReq)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 
 	if rResp.Results[0].Status != ua.StatusOK {
-		return nil, fmt.Errorf("status not OK: %v", rResp.Results[0].Status)
+		return nil, time.Time{}, fmt.Errorf("status not OK: %v", rResp.Results[0].Status)
 	}
 
-	return rResp.Results[0].Value.Value(), nil
+	return rResp.Results[0].Value.Value(), changedAt(rResp.Results[0], time.Now()), nil
+}
+
+// changedAt is the source timestamp of dv, the time the PLC changed the
+// value. A server not reporting it gives its own timestamp instead, or at
+// worst now, the time the value was received.
+func changedAt(dv *ua.DataValue, now time.Time) time.Time {
+	if !dv.SourceTimestamp.IsZero() {
+		return dv.SourceTimestamp
+	}
+	if !dv.ServerTimestamp.IsZero() {
+		return dv.ServerTimestamp
+	}
+	return now
 }
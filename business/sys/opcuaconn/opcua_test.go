@@ -0,0 +1,31 @@
+package opcuaconn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+)
+
+func TestChangedAt(t *testing.T) {
+	source := time.Date(2022, 9, 1, 8, 0, 0, 0, time.UTC)
+	server := source.Add(time.Second)
+	now := source.Add(time.Minute)
+
+	tests := []struct {
+		name string
+		dv   ua.DataValue
+		want time.Time
+	}{
+		{"source", ua.DataValue{SourceTimestamp: source, ServerTimestamp: server}, source},
+		{"no source", ua.DataValue{ServerTimestamp: server}, server},
+		{"none", ua.DataValue{}, now},
+	}
+
+	for _, tt := range tests {
+		dv := tt.dv
+		if got := changedAt(&dv, now); !got.Equal(tt.want) {
+			t.Errorf("%s: changedAt = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// Package outbox keeps the tag notifications of a machine in a local journal
+// until they have been applied to the database, so that they survive a
+// database outage or a restart of the service.
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is a tag notification stored in the journal. Related holds the
+// values of other tags read when the notification was received, which it
+// must be applied against.
+type Record struct {
+	Seq       uint64           `json:"seq"`
+	Node      string           `json:"node"`
+	Value     Value            `json:"value"`
+	Timestamp time.Time        `json:"timestamp"`
+	Related   map[string]Value `json:"related,omitempty"`
+}
+
+// DeadLetter is a record given up on, with the last error applying it.
+type DeadLetter struct {
+	Record Record    `json:"record"`
+	Error  string    `json:"error"`
+	Time   time.Time `json:"time"`
+}
+
+// Journal is an append-only file of records. Records stay pending until they
+// are acknowledged, in order, with Ack, or given up on with Discard, which
+// moves them to a dead-letter file next to the journal. The last
+// acknowledged sequence number is kept in a separate file next to the
+// journal.
+type Journal struct {
+	mu       sync.Mutex
+	f        *os.File
+	ackPath  string
+	deadPath string
+	lastSeq  uint64
+	pending  []Record
+
+	// failedSeq and failures count the failed attempts to apply the first
+	// pending record.
+	failedSeq uint64
+	failures  int
+}
+
+// Open opens, creating it if needed, the journal called name in dir and
+// loads the records not acknowledged yet. A record truncated by a crash
+// while it was written is discarded.
+func Open(dir, name string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, name+".journal")
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	j := Journal{
+		f:        f,
+		ackPath:  filepath.Join(dir, name+".ack"),
+		deadPath: filepath.Join(dir, name+".dead"),
+	}
+
+	acked, err := j.readAck()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	j.lastSeq = acked
+
+	if err := j.load(acked); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("loading journal %s: %w", path, err)
+	}
+
+	return &j, nil
+}
+
+func (j *Journal) readAck() (uint64, error) {
+	b, err := os.ReadFile(j.ackPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// load reads the journal, keeps the records after acked and cuts the file
+// after the last complete record.
+func (j *Journal) load(acked uint64) error {
+	r := bufio.NewReader(j.f)
+
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var rec Record
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			break
+		}
+		offset += int64(len(line))
+
+		if rec.Seq > j.lastSeq {
+			j.lastSeq = rec.Seq
+		}
+		if rec.Seq > acked {
+			j.pending = append(j.pending, rec)
+		}
+	}
+
+	if err := j.f.Truncate(offset); err != nil {
+		return err
+	}
+
+	_, err := j.f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// Append writes the record to the journal, flushed to disk, and returns it
+// with its sequence number. related may be nil.
+func (j *Journal) Append(node string, value interface{}, timestamp time.Time, related map[string]interface{}) (Record, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	rec := Record{
+		Seq:       j.lastSeq + 1,
+		Node:      node,
+		Value:     Value{V: value},
+		Timestamp: timestamp,
+	}
+	if len(related) > 0 {
+		rec.Related = make(map[string]Value, len(related))
+		for n, v := range related {
+			rec.Related[n] = Value{V: v}
+		}
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return Record{}, err
+	}
+
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return Record{}, err
+	}
+
+	if err := j.f.Sync(); err != nil {
+		return Record{}, err
+	}
+
+	j.lastSeq = rec.Seq
+	j.pending = append(j.pending, rec)
+	return rec, nil
+}
+
+// Pending returns the records not acknowledged yet, oldest first.
+func (j *Journal) Pending() []Record {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	pending := make([]Record, len(j.pending))
+	copy(pending, j.pending)
+	return pending
+}
+
+// Ack marks the records up to seq as applied. The journal file is emptied
+// once no record is pending anymore.
+func (j *Journal) Ack(seq uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.writeAck(seq); err != nil {
+		return err
+	}
+
+	i := 0
+	for i < len(j.pending) && j.pending[i].Seq <= seq {
+		i++
+	}
+	j.pending = j.pending[i:]
+
+	if len(j.pending) > 0 {
+		return nil
+	}
+
+	if err := j.f.Truncate(0); err != nil {
+		return err
+	}
+
+	_, err := j.f.Seek(0, io.SeekStart)
+	return err
+}
+
+// Failed records a failed attempt to apply the record seq and returns the
+// number of attempts failed in a row for it.
+func (j *Journal) Failed(seq uint64) int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.failedSeq != seq {
+		j.failedSeq, j.failures = seq, 0
+	}
+	j.failures++
+	return j.failures
+}
+
+// Discard appends the record to the dead-letter file, flushed to disk, with
+// the error that kept it from being applied, and then acknowledges it.
+func (j *Journal) Discard(rec Record, reason error, now time.Time) error {
+	b, err := json.Marshal(DeadLetter{Record: rec, Error: reason.Error(), Time: now})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.deadPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return j.Ack(rec.Seq)
+}
+
+// DeadPath returns the path of the dead-letter file.
+func (j *Journal) DeadPath() string {
+	return j.deadPath
+}
+
+// writeAck replaces the acknowledgement file atomically.
+func (j *Journal) writeAck(seq uint64) error {
+	tmp := j.ackPath + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteString(strconv.FormatUint(seq, 10)); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, j.ackPath)
+}
+
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
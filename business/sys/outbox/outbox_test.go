@@ -0,0 +1,207 @@
+package outbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournalReopen(t *testing.T) {
+	dir := t.TempDir()
+	ts := time.Date(2024, time.March, 5, 14, 30, 0, 0, time.UTC)
+
+	j, err := Open(dir, "spindryer")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	values := []interface{}{true, int16(-3), uint32(7), int64(1 << 40), float32(1.5), "C", nil}
+	for i, v := range values {
+		rec, err := j.Append("ns=2;s=tag", v, ts, map[string]interface{}{"ns=2;s=counter": int32(i)})
+		if err != nil {
+			t.Fatalf("Append(%v): %v", v, err)
+		}
+		if rec.Seq != uint64(i+1) {
+			t.Errorf("Append(%v): seq %d, want %d", v, rec.Seq, i+1)
+		}
+	}
+
+	if err := j.Ack(2); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	j, err = Open(dir, "spindryer")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer j.Close()
+
+	pending := j.Pending()
+	if len(pending) != len(values)-2 {
+		t.Fatalf("%d records pending, want %d", len(pending), len(values)-2)
+	}
+	for i, rec := range pending {
+		if rec.Seq != uint64(i+3) {
+			t.Errorf("record %d: seq %d, want %d", i, rec.Seq, i+3)
+		}
+		if rec.Value.V != values[i+2] {
+			t.Errorf("record %d: value %v (%T), want %v (%T)", i, rec.Value.V, rec.Value.V, values[i+2], values[i+2])
+		}
+		if !rec.Timestamp.Equal(ts) {
+			t.Errorf("record %d: timestamp %v, want %v", i, rec.Timestamp, ts)
+		}
+		if got := rec.Related["ns=2;s=counter"].V; got != int32(i+2) {
+			t.Errorf("record %d: related %v (%T), want %d", i, got, got, i+2)
+		}
+	}
+
+	// The sequence goes on after the records loaded.
+	rec, err := j.Append("ns=2;s=tag", true, ts, nil)
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if rec.Seq != uint64(len(values)+1) {
+		t.Errorf("Append after reopen: seq %d, want %d", rec.Seq, len(values)+1)
+	}
+}
+
+func TestJournalTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	j, err := Open(dir, "pasteurizer")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := j.Append("ns=2;s=tag", int64(1), time.Now(), nil); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A crash while writing the second record.
+	f, err := os.OpenFile(filepath.Join(dir, "pasteurizer.journal"), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"seq":2,"node":"ns=2;s=ta`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	j, err = Open(dir, "pasteurizer")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer j.Close()
+
+	if pending := j.Pending(); len(pending) != 1 || pending[0].Seq != 1 {
+		t.Fatalf("pending %+v, want record 1 only", pending)
+	}
+
+	rec, err := j.Append("ns=2;s=tag", int64(2), time.Now(), nil)
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if rec.Seq != 2 {
+		t.Errorf("Append: seq %d, want 2", rec.Seq)
+	}
+	if pending := j.Pending(); len(pending) != 2 {
+		t.Errorf("%d records pending, want 2", len(pending))
+	}
+}
+
+func TestJournalAckEmpties(t *testing.T) {
+	dir := t.TempDir()
+
+	j, err := Open(dir, "spindryer")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer j.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := j.Append("ns=2;s=tag", int64(i), time.Now(), nil); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if err := j.Ack(3); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, "spindryer.journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 0 {
+		t.Errorf("journal of %d bytes once acknowledged, want empty", fi.Size())
+	}
+}
+
+func TestJournalDiscard(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, time.March, 5, 14, 30, 0, 0, time.UTC)
+
+	j, err := Open(dir, "spindryer")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer j.Close()
+
+	first, err := j.Append("ns=2;s=tag", true, now, nil)
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if _, err := j.Append("ns=2;s=tag", false, now, nil); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	for want := 1; want <= 3; want++ {
+		if got := j.Failed(first.Seq); got != want {
+			t.Errorf("Failed: %d attempts, want %d", got, want)
+		}
+	}
+
+	if err := j.Discard(first, errors.New("no active work"), now); err != nil {
+		t.Fatalf("Discard: %v", err)
+	}
+
+	if pending := j.Pending(); len(pending) != 1 || pending[0].Seq != first.Seq+1 {
+		t.Errorf("pending %+v, want the second record only", pending)
+	}
+
+	// The attempts are counted again for another record.
+	if got := j.Failed(first.Seq + 1); got != 1 {
+		t.Errorf("Failed: %d attempts, want 1", got)
+	}
+
+	f, err := os.Open(j.DeadPath())
+	if err != nil {
+		t.Fatalf("opening the dead letters: %v", err)
+	}
+	defer f.Close()
+
+	var letters []DeadLetter
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var d DeadLetter
+		if err := json.Unmarshal(sc.Bytes(), &d); err != nil {
+			t.Fatalf("decoding a dead letter: %v", err)
+		}
+		letters = append(letters, d)
+	}
+
+	if len(letters) != 1 {
+		t.Fatalf("%d dead letters, want 1", len(letters))
+	}
+	if d := letters[0]; d.Record.Seq != first.Seq || d.Record.Value.V != true || d.Error != "no active work" || !d.Time.Equal(now) {
+		t.Errorf("dead letter %+v", d)
+	}
+}
@@ -0,0 +1,98 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Value is a tag value that keeps its Go type through the journal, so that
+// a replayed notification is decoded like the original one.
+type Value struct {
+	V interface{}
+}
+
+type typedValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	var typ string
+	switch v.V.(type) {
+	case nil:
+		typ = "nil"
+	case bool:
+		typ = "bool"
+	case int16:
+		typ = "int16"
+	case int32:
+		typ = "int32"
+	case int64:
+		typ = "int64"
+	case uint16:
+		typ = "uint16"
+	case uint32:
+		typ = "uint32"
+	case uint64:
+		typ = "uint64"
+	case float32:
+		typ = "float32"
+	case float64:
+		typ = "float64"
+	case string:
+		typ = "string"
+	default:
+		return nil, fmt.Errorf("unsupported tag value type %T", v.V)
+	}
+
+	b, err := json.Marshal(v.V)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(typedValue{Type: typ, Value: b})
+}
+
+func (v *Value) UnmarshalJSON(b []byte) error {
+	var tv typedValue
+	if err := json.Unmarshal(b, &tv); err != nil {
+		return err
+	}
+
+	var dst interface{}
+	switch tv.Type {
+	case "nil":
+		v.V = nil
+		return nil
+	case "bool":
+		dst = new(bool)
+	case "int16":
+		dst = new(int16)
+	case "int32":
+		dst = new(int32)
+	case "int64":
+		dst = new(int64)
+	case "uint16":
+		dst = new(uint16)
+	case "uint32":
+		dst = new(uint32)
+	case "uint64":
+		dst = new(uint64)
+	case "float32":
+		dst = new(float32)
+	case "float64":
+		dst = new(float64)
+	case "string":
+		dst = new(string)
+	default:
+		return fmt.Errorf("unsupported tag value type %q", tv.Type)
+	}
+
+	if err := json.Unmarshal(tv.Value, dst); err != nil {
+		return err
+	}
+
+	v.V = reflect.ValueOf(dst).Elem().Interface()
+	return nil
+}
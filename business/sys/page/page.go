@@ -0,0 +1,233 @@
+// Package page sorts and pages query results with opaque cursors: the token
+// of a page holds the sort values of its last row, and the next page starts
+// right after them, so that rows inserted meanwhile never shift the pages.
+package page
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+var (
+	ErrInvalidSort  = errors.New("invalid sort")
+	ErrInvalidToken = errors.New("invalid page token")
+)
+
+// Kind is the type of the values of a sort field, needed to restore them
+// from a token.
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Time
+)
+
+// Field is a sortable field exposed as Name and stored in Column. Columns
+// must be not null.
+type Field struct {
+	Name   string
+	Column string
+	Kind   Kind
+}
+
+// Order is a field with its direction.
+type Order struct {
+	Field Field
+	Desc  bool
+}
+
+// Param returns the placeholder of the n-th argument of a query.
+type Param func(n int) string
+
+// ParamSQLServer and ParamSQLite are the placeholders of the two drivers.
+var (
+	ParamSQLServer Param = func(n int) string { return "@p" + strconv.Itoa(n) }
+	ParamSQLite    Param = func(n int) string { return "?" + strconv.Itoa(n) }
+)
+
+// ArgsSQLServer returns args with the times, in UTC like the columns they
+// are compared with, bound as datetime. go-mssqldb sends them as
+// datetimeoffset otherwise, and the conversion of the datetime columns keeps
+// their 1/300 s ticks, so that a row equal to the sort values of a token
+// would no longer be found equal and could be skipped.
+func ArgsSQLServer(args []interface{}) []interface{} {
+	bound := make([]interface{}, len(args))
+	for i, a := range args {
+		if t, ok := a.(time.Time); ok {
+			a = mssql.DateTime1(t.UTC())
+		}
+		bound[i] = a
+	}
+	return bound
+}
+
+// ParseSort parses a comma separated list of field names, each prefixed by
+// - for the descending order, into the orders to apply. def is used when
+// spec is empty. The unique field id is appended when missing, in the
+// direction of the last order, so that the rows are always in a total order.
+func ParseSort(spec string, fields []Field, def string) ([]Order, error) {
+	if strings.TrimSpace(spec) == "" {
+		spec = def
+	}
+
+	byName := make(map[string]Field, len(fields))
+	for _, f := range fields {
+		byName[f.Name] = f
+	}
+
+	orders := make([]Order, 0)
+	seen := make(map[string]bool)
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		desc := strings.HasPrefix(s, "-")
+		name := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+		f, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidSort, name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%w: field %q repeated", ErrInvalidSort, name)
+		}
+		seen[name] = true
+
+		orders = append(orders, Order{Field: f, Desc: desc})
+	}
+
+	if !seen["id"] {
+		id, ok := byName["id"]
+		if !ok {
+			return nil, fmt.Errorf("%w: no id field", ErrInvalidSort)
+		}
+		orders = append(orders, Order{Field: id, Desc: orders[len(orders)-1].Desc})
+	}
+
+	return orders, nil
+}
+
+// Spec formats the orders back as accepted by ParseSort.
+func Spec(orders []Order) string {
+	names := make([]string, len(orders))
+	for i, o := range orders {
+		names[i] = o.Field.Name
+		if o.Desc {
+			names[i] = "-" + names[i]
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// OrderBy returns the order by clause of the orders.
+func OrderBy(orders []Order) string {
+	columns := make([]string, len(orders))
+	for i, o := range orders {
+		columns[i] = o.Field.Column
+		if o.Desc {
+			columns[i] += " desc"
+		}
+	}
+	return " order by " + strings.Join(columns, ", ")
+}
+
+// After returns the condition selecting the rows that follow, in the
+// orders, the row whose sort values are bound to the placeholders starting
+// from next, in the same order.
+func After(orders []Order, param Param, next int) string {
+	terms := make([]string, len(orders))
+	for i, o := range orders {
+		conds := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			conds = append(conds, orders[j].Field.Column+" = "+param(next+j))
+		}
+
+		op := " > "
+		if o.Desc {
+			op = " < "
+		}
+		conds = append(conds, o.Field.Column+op+param(next+i))
+
+		terms[i] = "(" + strings.Join(conds, " and ") + ")"
+	}
+
+	return "(" + strings.Join(terms, " or ") + ")"
+}
+
+type token struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+}
+
+// Token encodes the sort values of the last row of a page.
+func Token(orders []Order, values []interface{}) (string, error) {
+	vs := make([]interface{}, len(values))
+	for i, v := range values {
+		if t, ok := v.(time.Time); ok {
+			v = t.Format(time.RFC3339Nano)
+		}
+		vs[i] = v
+	}
+
+	b, err := json.Marshal(token{Sort: Spec(orders), Values: vs})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ParseToken decodes a token of Token for the same orders.
+func ParseToken(s string, orders []Order) ([]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var t token
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if t.Sort != Spec(orders) || len(t.Values) != len(orders) {
+		return nil, fmt.Errorf("%w: issued for another sort", ErrInvalidToken)
+	}
+
+	values := make([]interface{}, len(orders))
+	for i, o := range orders {
+		switch o.Field.Kind {
+		case Int:
+			n, ok := t.Values[i].(float64)
+			if !ok {
+				return nil, ErrInvalidToken
+			}
+			values[i] = int(n)
+
+		case Time:
+			s, ok := t.Values[i].(string)
+			if !ok {
+				return nil, ErrInvalidToken
+			}
+			tm, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, ErrInvalidToken
+			}
+			values[i] = tm.UTC()
+
+		default:
+			s, ok := t.Values[i].(string)
+			if !ok {
+				return nil, ErrInvalidToken
+			}
+			values[i] = s
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,159 @@
+package page
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+var fields = []Field{
+	{Name: "id", Column: "w.id", Kind: Int},
+	{Name: "cd_lotto", Column: "w.cd_lotto", Kind: String},
+	{Name: "created", Column: "w.created", Kind: Time},
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+		err  error
+	}{
+		{"", "-created,-id", nil},
+		{"cd_lotto", "cd_lotto,id", nil},
+		{"-cd_lotto", "-cd_lotto,-id", nil},
+		{"+created, -cd_lotto", "created,-cd_lotto,-id", nil},
+		{"id,-created", "id,-created", nil},
+		{"quantity", "", ErrInvalidSort},
+		{"created,-created", "", ErrInvalidSort},
+		{"created,", "", ErrInvalidSort},
+	}
+
+	for _, tt := range tests {
+		orders, err := ParseSort(tt.spec, fields, "-created")
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParseSort(%q): error %v, want %v", tt.spec, err, tt.err)
+			continue
+		}
+		if err == nil && Spec(orders) != tt.want {
+			t.Errorf("ParseSort(%q) = %q, want %q", tt.spec, Spec(orders), tt.want)
+		}
+	}
+}
+
+func TestParseSortNoID(t *testing.T) {
+	if _, err := ParseSort("name", []Field{{Name: "name", Column: "name"}}, "name"); !errors.Is(err, ErrInvalidSort) {
+		t.Errorf("ParseSort without an id field: error %v, want %v", err, ErrInvalidSort)
+	}
+}
+
+func TestOrderByAfter(t *testing.T) {
+	orders, err := ParseSort("-created", fields, "")
+	if err != nil {
+		t.Fatalf("ParseSort: %v", err)
+	}
+
+	if got, want := OrderBy(orders), " order by w.created desc, w.id desc"; got != want {
+		t.Errorf("OrderBy = %q, want %q", got, want)
+	}
+
+	want := "((w.created < @p3) or (w.created = @p3 and w.id < @p4))"
+	if got := After(orders, ParamSQLServer, 3); got != want {
+		t.Errorf("After = %q, want %q", got, want)
+	}
+
+	want = "((w.created < ?1) or (w.created = ?1 and w.id < ?2))"
+	if got := After(orders, ParamSQLite, 1); got != want {
+		t.Errorf("After = %q, want %q", got, want)
+	}
+}
+
+func TestToken(t *testing.T) {
+	orders, err := ParseSort("cd_lotto,-created", fields, "")
+	if err != nil {
+		t.Fatalf("ParseSort: %v", err)
+	}
+
+	// A token issued with a local time resumes from the same instant in UTC.
+	created := time.Date(2024, time.March, 5, 14, 30, 15, 123456700, time.FixedZone("CET", 3600))
+	tok, err := Token(orders, []interface{}{"24065C001", created, 42})
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+
+	values, err := ParseToken(tok, orders)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+
+	if values[0] != "24065C001" {
+		t.Errorf("cd_lotto = %v, want 24065C001", values[0])
+	}
+	if tm, ok := values[1].(time.Time); !ok || !tm.Equal(created) || tm.Location() != time.UTC {
+		t.Errorf("created = %v, want %v", values[1], created.UTC())
+	}
+	if values[2] != 42 {
+		t.Errorf("id = %v (%T), want 42", values[2], values[2])
+	}
+}
+
+func TestParseTokenInvalid(t *testing.T) {
+	orders, err := ParseSort("cd_lotto", fields, "")
+	if err != nil {
+		t.Fatalf("ParseSort: %v", err)
+	}
+	other, err := ParseSort("-cd_lotto", fields, "")
+	if err != nil {
+		t.Fatalf("ParseSort: %v", err)
+	}
+
+	tok, err := Token(other, []interface{}{"24065C001", 42})
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	wrongKind, err := Token(orders, []interface{}{42, "24065C001"})
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+
+	tests := []string{
+		"not base64!",
+		"bm90IGpzb24",
+		tok,
+		wrongKind,
+	}
+
+	for _, s := range tests {
+		if _, err := ParseToken(s, orders); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("ParseToken(%q): error %v, want %v", s, err, ErrInvalidToken)
+		}
+	}
+}
+
+func TestArgsSQLServer(t *testing.T) {
+	local := time.Date(2024, time.March, 5, 0, 0, 0, 0, time.FixedZone("CET", 3600))
+	args := []interface{}{"24065C001", local, 42, true}
+
+	bound := ArgsSQLServer(args)
+	if len(bound) != len(args) {
+		t.Fatalf("%d arguments bound, want %d", len(bound), len(args))
+	}
+
+	dt, ok := bound[1].(mssql.DateTime1)
+	if !ok {
+		t.Fatalf("time bound as %T, want mssql.DateTime1", bound[1])
+	}
+	if want := time.Date(2024, time.March, 4, 23, 0, 0, 0, time.UTC); time.Time(dt) != want {
+		t.Errorf("time bound as %v, want %v", time.Time(dt), want)
+	}
+
+	for _, i := range []int{0, 2, 3} {
+		if bound[i] != args[i] {
+			t.Errorf("argument %d bound as %v, want %v", i, bound[i], args[i])
+		}
+	}
+	if _, ok := args[1].(time.Time); !ok {
+		t.Errorf("args modified: %T", args[1])
+	}
+}
@@ -0,0 +1,107 @@
+// Package profile selects the settings that differ between the environments
+// the service runs in: the database, the machine endpoints and the feature
+// toggles.
+package profile
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ardanlabs/conf"
+)
+
+// Names of the profiles.
+const (
+	Production = "production"
+	Demo       = "demo"
+	Local      = "local"
+)
+
+// Profile is a named set of configuration values, keyed as the flags
+// without the leading dashes, e.g. db-host. It is a conf.Sourcer that
+// overrides the defaults of the fields and is overridden in turn by the
+// environment and the flags.
+type Profile struct {
+	Name   string            `json:"name"`
+	Values map[string]string `json:"values"`
+}
+
+// The simulator exposes the tags of both machines.
+const simulator = "opc.tcp://MacBook-Pro-di-Samuele.local:53530/OPCUA/SimulationServer"
+
+var profiles = map[string]map[string]string{
+	// The Arca database of Millefrutti and the PLCs of the plant.
+	Production: {
+		"db-driver":                   "sqlserver",
+		"db-host":                     "192.168.1.10:1433",
+		"db-user":                     "cash",
+		"db-name":                     "ADB_MILLEFRUTTISRL",
+		"spindryer-opcua-endpoint":    "opc.tcp://192.168.1.22:4840",
+		"pasteurizer-opcua-endpoint":  "opc.tcp://192.168.1.181:4840",
+		"spindryer-stock-movements":   "false",
+		"pasteurizer-stock-movements": "false",
+	},
+	// The demo copy of the Arca database and the OPC UA simulator.
+	Demo: {
+		"db-driver":                   "sqlserver",
+		"db-host":                     "192.168.0.15:1433",
+		"db-user":                     "sa",
+		"db-name":                     "ADB_DEMO",
+		"spindryer-opcua-endpoint":    simulator,
+		"pasteurizer-opcua-endpoint":  simulator,
+		"spindryer-stock-movements":   "true",
+		"pasteurizer-stock-movements": "true",
+	},
+	// The offline development database, kept up to date on its own, and
+	// the simulator running on the same machine.
+	Local: {
+		"db-driver":                   "sqlite",
+		"db-path":                     "arca-dev.db",
+		"db-migrate-on-start":         "true",
+		"spindryer-party":             "F000001",
+		"pasteurizer-party":           "F000001",
+		"spindryer-opcua-endpoint":    "opc.tcp://localhost:53530/OPCUA/SimulationServer",
+		"pasteurizer-opcua-endpoint":  "opc.tcp://localhost:53530/OPCUA/SimulationServer",
+		"spindryer-stock-movements":   "true",
+		"pasteurizer-stock-movements": "true",
+	},
+}
+
+// Lookup returns the profile called name.
+func Lookup(name string) (Profile, error) {
+	values, ok := profiles[name]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for n := range profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Profile{}, fmt.Errorf("unknown profile %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+
+	return Profile{Name: name, Values: values}, nil
+}
+
+// Source implements conf.Sourcer.
+func (p Profile) Source(fld conf.Field) (string, bool) {
+	v, ok := p.Values[strings.ToLower(strings.Join(fld.FlagKey, "-"))]
+	return v, ok
+}
+
+// Secret returns the secret read from file, with the surrounding blanks
+// trimmed, or value when file is empty. The file is the way to pass a
+// secret that must not show up in the environment, e.g. a Docker secret.
+func Secret(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("reading secret: %w", err)
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
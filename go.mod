@@ -7,6 +7,8 @@ require (
 	github.com/denisenkom/go-mssqldb v0.12.2
 	github.com/devsamuele/service-kit v0.0.0-20220909153645-426487c65b97
 	github.com/gopcua/opcua v0.3.5
+	github.com/lestrrat-go/jwx v1.2.25
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/rs/cors v1.8.2
 )
 
@@ -23,7 +25,6 @@ require (
 	github.com/lestrrat-go/blackmagic v1.0.0 // indirect
 	github.com/lestrrat-go/httpcc v1.0.1 // indirect
 	github.com/lestrrat-go/iter v1.0.1 // indirect
-	github.com/lestrrat-go/jwx v1.2.25 // indirect
 	github.com/lestrrat-go/option v1.0.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	golang.org/x/crypto v0.0.0-20220427172511-eb4f295cb31f // indirect